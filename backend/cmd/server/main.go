@@ -4,7 +4,9 @@ import (
 	"context"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/cors"
@@ -77,15 +79,26 @@ func main() {
 	app.Use(middleware.RateLimitMiddleware)
 	v1 := app.Group("/api/v1")
 
-	router.SetupRoutes(v1, supaClient, cfg)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	queueDone := router.SetupRoutes(ctx, v1, supaClient, cfg)
 
 	go middleware.CleanupClients(ctx)
 
+	go func() {
+		<-ctx.Done()
+		// A second signal kills the server without waiting for running jobs
+		stop()
+		log.Printf("Shutting down, running jobs finish first")
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Server shutdown error: %v", err)
+		}
+	}()
+
 	if err := app.Listen(":" + utils.GetEnv("BACKEND_PORT", "8080")); err != nil {
-		cancel()
+		stop()
 		panic(err)
 	}
+	<-queueDone
 }
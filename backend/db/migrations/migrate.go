@@ -14,7 +14,7 @@ import (
 
 const (
 	// Current schema version - increment this when making schema changes
-	CurrentSchemaVersion = 1
+	CurrentSchemaVersion = 26
 )
 
 func RunDatabaseMigrations() error {
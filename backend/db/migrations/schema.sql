@@ -111,3 +111,271 @@ drop trigger if exists on_auth_user_created on auth.users;
 create trigger on_auth_user_created
   after insert on auth.users
   for each row execute function public.handle_new_user();
+
+-- v2: named quality presets requested for each job
+alter table public.downloads add column if not exists quality text not null default '1080p';
+alter table public.time_range_downloads add column if not exists quality text not null default '1080p';
+
+-- v3: audio-only extraction
+alter table public.downloads add column if not exists audio_mode text not null default 'default';
+alter table public.downloads add column if not exists audio_codec text;
+alter table public.downloads add column if not exists audio_bitrate integer;
+alter table public.time_range_downloads add column if not exists audio_mode text not null default 'default';
+alter table public.time_range_downloads add column if not exists audio_codec text;
+alter table public.time_range_downloads add column if not exists audio_bitrate integer;
+
+-- v4: SponsorBlock segment removal / marking
+alter table public.downloads add column if not exists sponsorblock text not null default 'off';
+alter table public.downloads add column if not exists sponsorblock_categories text[];
+alter table public.downloads add column if not exists sponsor_segments jsonb;
+alter table public.time_range_downloads add column if not exists sponsorblock text not null default 'off';
+alter table public.time_range_downloads add column if not exists sponsorblock_categories text[];
+alter table public.time_range_downloads add column if not exists sponsor_segments jsonb;
+alter table public.time_range_downloads add column if not exists final_duration double precision;
+
+-- v5: subtitle download, embedding and burn-in
+alter table public.downloads add column if not exists subtitles text not null default 'off';
+alter table public.downloads add column if not exists subtitle_languages text[];
+alter table public.downloads add column if not exists subtitle_auto boolean;
+alter table public.downloads add column if not exists subtitle_format text;
+alter table public.time_range_downloads add column if not exists subtitles text not null default 'off';
+alter table public.time_range_downloads add column if not exists subtitle_languages text[];
+alter table public.time_range_downloads add column if not exists subtitle_auto boolean;
+alter table public.time_range_downloads add column if not exists subtitle_format text;
+
+-- v6: embedded thumbnail as cover art
+alter table public.downloads add column if not exists thumbnail boolean not null default false;
+alter table public.time_range_downloads add column if not exists thumbnail boolean not null default false;
+
+-- v7: live progress parsed from yt-dlp output
+alter table public.downloads add column if not exists phase text;
+alter table public.downloads add column if not exists progress_percent real not null default 0;
+alter table public.downloads add column if not exists downloaded_bytes bigint;
+alter table public.downloads add column if not exists total_bytes bigint;
+alter table public.downloads add column if not exists speed_bps double precision;
+alter table public.downloads add column if not exists eta_seconds integer;
+alter table public.time_range_downloads add column if not exists phase text;
+alter table public.time_range_downloads add column if not exists progress_percent real not null default 0;
+alter table public.time_range_downloads add column if not exists downloaded_bytes bigint;
+alter table public.time_range_downloads add column if not exists total_bytes bigint;
+alter table public.time_range_downloads add column if not exists speed_bps double precision;
+alter table public.time_range_downloads add column if not exists eta_seconds integer;
+
+-- v8: cancelled jobs
+alter table public.downloads drop constraint if exists downloads_status_check;
+alter table public.downloads add constraint downloads_status_check check (status in ('pending', 'processing', 'completed', 'failed', 'cancelled'));
+
+-- v9: failure reason codes (timeout, stall_timeout, download_error)
+alter table public.downloads add column if not exists error_code text;
+alter table public.time_range_downloads add column if not exists error_code text;
+
+-- v10: job owners and served output files
+alter table public.downloads add column if not exists user_id uuid references auth.users(id) on delete set null;
+alter table public.downloads add column if not exists output_file text;
+alter table public.time_range_downloads add column if not exists user_id uuid references auth.users(id) on delete set null;
+
+-- v11: structured job results, outputs are named after the job ID
+alter table public.downloads add column if not exists final_duration double precision;
+alter table public.downloads add column if not exists output_name text;
+alter table public.downloads add column if not exists output_size bigint;
+alter table public.downloads add column if not exists output_width integer;
+alter table public.downloads add column if not exists output_height integer;
+alter table public.downloads add column if not exists output_video_codec text;
+alter table public.downloads add column if not exists output_audio_codec text;
+alter table public.downloads add column if not exists output_container text;
+alter table public.time_range_downloads add column if not exists output_name text;
+alter table public.time_range_downloads add column if not exists output_size bigint;
+alter table public.time_range_downloads add column if not exists output_width integer;
+alter table public.time_range_downloads add column if not exists output_height integer;
+alter table public.time_range_downloads add column if not exists output_video_codec text;
+alter table public.time_range_downloads add column if not exists output_audio_codec text;
+alter table public.time_range_downloads add column if not exists output_container text;
+
+-- v12: playlists expanded into one child download per entry
+create table if not exists public.playlist_downloads (
+  id uuid primary key default gen_random_uuid(),
+  url text not null,
+  user_id uuid references auth.users(id) on delete set null,
+  title text,
+  entry_count integer not null default 0,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz default now()
+);
+
+drop trigger if exists set_updated_at_playlist_downloads on public.playlist_downloads;
+
+create trigger set_updated_at_playlist_downloads
+  before update on public.playlist_downloads
+  for each row execute function public.set_updated_at();
+
+alter table public.downloads add column if not exists playlist_id uuid references public.playlist_downloads(id) on delete cascade;
+alter table public.downloads add column if not exists playlist_index integer;
+create index if not exists downloads_playlist_id_idx on public.downloads (playlist_id);
+
+-- v13: multi-range clip jobs, each segment is a time range download under the job
+create table if not exists public.clip_downloads (
+  id uuid primary key default gen_random_uuid(),
+  url text not null,
+  user_id uuid references auth.users(id) on delete set null,
+  output_mode text not null default 'separate' check (output_mode in ('separate', 'concat')),
+  segment_count integer not null default 0,
+  status text not null default 'processing' check (status in ('pending', 'processing', 'completed', 'failed', 'cancelled', 'partial')),
+  message text,
+  error_code text,
+  output_file text,
+  output_name text,
+  output_size bigint,
+  output_width integer,
+  output_height integer,
+  output_video_codec text,
+  output_audio_codec text,
+  output_container text,
+  final_duration double precision,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz default now()
+);
+
+drop trigger if exists set_updated_at_clip_downloads on public.clip_downloads;
+
+create trigger set_updated_at_clip_downloads
+  before update on public.clip_downloads
+  for each row execute function public.set_updated_at();
+
+alter table public.time_range_downloads add column if not exists clip_job_id uuid references public.clip_downloads(id) on delete cascade;
+alter table public.time_range_downloads add column if not exists segment_index integer;
+alter table public.time_range_downloads add column if not exists segment_name text;
+create index if not exists time_range_downloads_clip_job_id_idx on public.time_range_downloads (clip_job_id);
+
+-- v14: cut precision of time range jobs and where their clips really start and end
+alter table public.time_range_downloads add column if not exists cut_precision text;
+alter table public.time_range_downloads add column if not exists cut_start double precision;
+alter table public.time_range_downloads add column if not exists cut_end double precision;
+
+-- v15: time ranges with millisecond precision
+alter table public.time_range_downloads alter column start_time type numeric(12, 3) using start_time::numeric(12, 3);
+alter table public.time_range_downloads alter column end_time type numeric(12, 3) using end_time::numeric(12, 3);
+
+-- v16: gif and animated webp exports of time range jobs
+alter table public.time_range_downloads add column if not exists output_format text check (output_format in ('gif', 'webp'));
+alter table public.time_range_downloads add column if not exists animation_width integer;
+alter table public.time_range_downloads add column if not exists animation_fps double precision;
+alter table public.time_range_downloads add column if not exists animation_loop integer;
+alter table public.time_range_downloads add column if not exists animation_single_pass boolean;
+alter table public.time_range_downloads add column if not exists animation_max_bytes bigint;
+alter table public.time_range_downloads add column if not exists output_fps double precision;
+
+-- v17: vertical 9:16 reframing of time range jobs
+alter table public.time_range_downloads add column if not exists reframe text check (reframe in ('center', 'crop', 'blur'));
+alter table public.time_range_downloads add column if not exists reframe_crop_x double precision check (reframe_crop_x between 0 and 1);
+
+-- v18: per-user watermarks and the jobs that use them
+create table if not exists public.watermarks (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references auth.users(id) on delete cascade,
+  name text not null,
+  kind text not null check (kind in ('image', 'text')),
+  text text,
+  image_file text,
+  position text not null default 'bottom-right' check (position in ('top-left', 'top-right', 'bottom-left', 'bottom-right', 'center')),
+  margin integer not null default 24,
+  opacity double precision not null default 0.8 check (opacity > 0 and opacity <= 1),
+  scale double precision not null,
+  created_at timestamptz not null default now(),
+  check ((kind = 'image') = (image_file is not null) and (kind = 'text') = (text is not null))
+);
+
+create index if not exists watermarks_user_id_idx on public.watermarks (user_id);
+
+alter table public.downloads add column if not exists watermark_id uuid references public.watermarks(id) on delete set null;
+alter table public.time_range_downloads add column if not exists watermark_id uuid references public.watermarks(id) on delete set null;
+
+-- v19: output codec and container selection
+alter table public.downloads add column if not exists video_codec text check (video_codec in ('h264', 'h265', 'vp9', 'av1'));
+alter table public.downloads add column if not exists container text check (container in ('mp4', 'webm', 'mkv'));
+alter table public.downloads add column if not exists crf integer;
+alter table public.downloads add column if not exists encoder_preset text;
+alter table public.time_range_downloads add column if not exists video_codec text check (video_codec in ('h264', 'h265', 'vp9', 'av1'));
+alter table public.time_range_downloads add column if not exists container text check (container in ('mp4', 'webm', 'mkv'));
+alter table public.time_range_downloads add column if not exists crf integer;
+alter table public.time_range_downloads add column if not exists encoder_preset text;
+
+-- v20: loudness normalization, fades and stereo downmix of the audio track
+alter table public.downloads add column if not exists normalize boolean;
+alter table public.downloads add column if not exists target_lufs double precision check (target_lufs between -70 and -5);
+alter table public.downloads add column if not exists fade_in double precision check (fade_in between 0 and 30);
+alter table public.downloads add column if not exists fade_out double precision check (fade_out between 0 and 30);
+alter table public.downloads add column if not exists stereo boolean;
+alter table public.downloads add column if not exists loudness jsonb;
+alter table public.time_range_downloads add column if not exists normalize boolean;
+alter table public.time_range_downloads add column if not exists target_lufs double precision check (target_lufs between -70 and -5);
+alter table public.time_range_downloads add column if not exists fade_in double precision check (fade_in between 0 and 30);
+alter table public.time_range_downloads add column if not exists fade_out double precision check (fade_out between 0 and 30);
+alter table public.time_range_downloads add column if not exists stereo boolean;
+alter table public.time_range_downloads add column if not exists loudness jsonb;
+
+-- v21: storyboard jobs, sprite sheets plus a WebVTT thumbnail track
+create table if not exists public.storyboards (
+  id uuid primary key default gen_random_uuid(),
+  url text not null,
+  user_id uuid references auth.users(id) on delete set null,
+  status text not null default 'processing' check (status in ('processing', 'completed', 'failed', 'cancelled')),
+  message text,
+  error_code text,
+  frame_interval double precision not null,
+  frame_width integer not null,
+  sheet_columns integer not null,
+  video_id text,
+  output_dir text,
+  sprites text[],
+  frame_count integer,
+  tile_width integer,
+  tile_height integer,
+  cached boolean,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz default now()
+);
+
+drop trigger if exists set_updated_at_storyboards on public.storyboards;
+
+create trigger set_updated_at_storyboards
+  before update on public.storyboards
+  for each row execute function public.set_updated_at();
+
+-- v22: persistent job queue, full video and time range jobs wait as pending until a worker claims them
+create table if not exists public.job_queue (
+  id uuid primary key,
+  kind text not null check (kind in ('full_video', 'time_range')),
+  url text not null,
+  user_id uuid references auth.users(id) on delete set null,
+  payload jsonb not null,
+  status text not null default 'pending' check (status in ('pending', 'running', 'done', 'cancelled')),
+  worker_id text,
+  enqueued_at timestamptz not null default now(),
+  started_at timestamptz,
+  heartbeat_at timestamptz,
+  finished_at timestamptz
+);
+
+create index if not exists job_queue_pending_idx on public.job_queue (enqueued_at) where status = 'pending';
+create index if not exists job_queue_running_idx on public.job_queue (worker_id, heartbeat_at) where status = 'running';
+create index if not exists job_queue_finished_idx on public.job_queue (finished_at desc) where status = 'done';
+
+-- v23: the format and height yt-dlp resolved each job's quality preset to
+alter table public.downloads add column if not exists source_format text;
+alter table public.downloads add column if not exists source_height integer;
+alter table public.time_range_downloads add column if not exists source_format text;
+alter table public.time_range_downloads add column if not exists source_height integer;
+
+-- v24: cancel requests for running queued jobs, the server running the job polls for them
+alter table public.job_queue add column if not exists cancel_requested boolean not null default false;
+
+-- v25: playlist children, clip jobs and storyboards wait in the job queue as well
+alter table public.job_queue drop constraint if exists job_queue_kind_check;
+alter table public.job_queue add constraint job_queue_kind_check check (kind in ('full_video', 'time_range', 'playlist_entry', 'clips', 'storyboard'));
+alter table public.storyboards drop constraint if exists storyboards_status_check;
+alter table public.storyboards add constraint storyboards_status_check check (status in ('pending', 'processing', 'completed', 'failed', 'cancelled'));
+alter table public.storyboards alter column status set default 'pending';
+alter table public.clip_downloads alter column status set default 'pending';
+
+-- v26: playlist children held back by their owner's rate limit are not claimed before not_before
+alter table public.job_queue add column if not exists not_before timestamptz;
@@ -0,0 +1,372 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/verse91/ytb-clipy/backend/internal/middleware"
+	"github.com/verse91/ytb-clipy/backend/internal/service"
+	"github.com/verse91/ytb-clipy/backend/pkg/logger"
+	"github.com/verse91/ytb-clipy/backend/pkg/response"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+)
+
+// previewMaxAge is how long clients may cache frames, storyboard and waveform files, they never change
+const previewMaxAge = 24 * 60 * 60
+
+// StoryboardRequest starts a storyboard job, zero options take the defaults
+type StoryboardRequest struct {
+	URL      string  `json:"url" binding:"required"`
+	Interval float64 `json:"interval"` // seconds between frames, 10 by default
+	Width    int     `json:"width"`    // of one frame, 160 by default
+	Columns  int     `json:"columns"`  // frames per sprite sheet row, 10 by default
+}
+
+// SourceSnapshotHandler returns the frame of a source video at the time query parameter, so it
+// can be used as an <img> source: GET /video/snapshot?url=...&time=1:02.5&format=png&width=640
+func (vc *VideoController) SourceSnapshotHandler(c fiber.Ctx) error {
+	videoURL := c.Query("url")
+	if videoURL == "" {
+		return response.ErrorResponse(c, response.ErrURLRequired, "URL is required")
+	}
+
+	// Validate URL format and scheme
+	parsedURL, err := url.ParseRequestURI(videoURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "URL must be a valid HTTP or HTTPS URL")
+	}
+
+	input, err := snapshotInput(c)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrInvalidSnapshot, err.Error())
+	}
+
+	path, err := vc.VideoService.SourceSnapshot(videoURL, input)
+	if err != nil {
+		return snapshotErrorResponse(c, err, "SourceSnapshotHandler")
+	}
+	return sendPreviewFile(c, path)
+}
+
+// DownloadSnapshotHandler returns a frame of a finished full video download
+func (vc *VideoController) DownloadSnapshotHandler(c fiber.Ctx) error {
+	downloadID := c.Params("id")
+
+	if downloadID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Download ID is required")
+	}
+
+	input, err := snapshotInput(c)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrInvalidSnapshot, err.Error())
+	}
+
+	path, err := vc.VideoService.DownloadSnapshot(downloadID, middleware.UserID(c), input)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidSnapshot) || errors.Is(err, service.ErrPreviewFailed) || errors.Is(err, service.ErrPreviewBusy) {
+			return snapshotErrorResponse(c, err, "DownloadSnapshotHandler")
+		}
+		return fileErrorResponse(c, err, "Download not found or failed to get status")
+	}
+	return sendPreviewFile(c, path)
+}
+
+// TimeRangeSnapshotHandler returns a frame of a finished time range clip
+func (vc *VideoController) TimeRangeSnapshotHandler(c fiber.Ctx) error {
+	downloadID := c.Params("id")
+
+	if downloadID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Download ID is required")
+	}
+
+	input, err := snapshotInput(c)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrInvalidSnapshot, err.Error())
+	}
+
+	path, err := vc.VideoService.TimeRangeSnapshot(downloadID, middleware.UserID(c), input)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidSnapshot) || errors.Is(err, service.ErrPreviewFailed) || errors.Is(err, service.ErrPreviewBusy) {
+			return snapshotErrorResponse(c, err, "TimeRangeSnapshotHandler")
+		}
+		return fileErrorResponse(c, err, "Time range download not found or failed to get status")
+	}
+	return sendPreviewFile(c, path)
+}
+
+// SourceWaveformHandler returns waveform peaks of a source video's audio in the audiowaveform
+// JSON or binary format: GET /video/waveform?url=...&resolution=100&format=dat
+func (vc *VideoController) SourceWaveformHandler(c fiber.Ctx) error {
+	videoURL := c.Query("url")
+	if videoURL == "" {
+		return response.ErrorResponse(c, response.ErrURLRequired, "URL is required")
+	}
+
+	// Validate URL format and scheme
+	parsedURL, err := url.ParseRequestURI(videoURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "URL must be a valid HTTP or HTTPS URL")
+	}
+
+	input, err := waveformInput(c)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrInvalidWaveform, err.Error())
+	}
+
+	path, err := vc.VideoService.SourceWaveform(videoURL, input)
+	if err != nil {
+		return waveformErrorResponse(c, err, "SourceWaveformHandler")
+	}
+	return sendPreviewFile(c, path)
+}
+
+// DownloadWaveformHandler returns waveform peaks of a finished full video download
+func (vc *VideoController) DownloadWaveformHandler(c fiber.Ctx) error {
+	downloadID := c.Params("id")
+
+	if downloadID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Download ID is required")
+	}
+
+	input, err := waveformInput(c)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrInvalidWaveform, err.Error())
+	}
+
+	path, err := vc.VideoService.DownloadWaveform(downloadID, middleware.UserID(c), input)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidWaveform) || errors.Is(err, service.ErrPreviewFailed) || errors.Is(err, service.ErrPreviewBusy) {
+			return waveformErrorResponse(c, err, "DownloadWaveformHandler")
+		}
+		return fileErrorResponse(c, err, "Download not found or failed to get status")
+	}
+	return sendPreviewFile(c, path)
+}
+
+// TimeRangeWaveformHandler returns waveform peaks of a finished time range clip
+func (vc *VideoController) TimeRangeWaveformHandler(c fiber.Ctx) error {
+	downloadID := c.Params("id")
+
+	if downloadID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Download ID is required")
+	}
+
+	input, err := waveformInput(c)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrInvalidWaveform, err.Error())
+	}
+
+	path, err := vc.VideoService.TimeRangeWaveform(downloadID, middleware.UserID(c), input)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidWaveform) || errors.Is(err, service.ErrPreviewFailed) || errors.Is(err, service.ErrPreviewBusy) {
+			return waveformErrorResponse(c, err, "TimeRangeWaveformHandler")
+		}
+		return fileErrorResponse(c, err, "Time range download not found or failed to get status")
+	}
+	return sendPreviewFile(c, path)
+}
+
+// CreateStoryboardHandler starts a job building sprite sheets and a WebVTT thumbnail track
+func (vc *VideoController) CreateStoryboardHandler(c fiber.Ctx) error {
+	var req StoryboardRequest
+
+	if err := c.Bind().JSON(&req); err != nil {
+		logger.Log.Error("JSON bind error in storyboard request",
+			zap.Error(err),
+			zap.String("handler", "CreateStoryboardHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, fmt.Sprintf("Invalid request body: %v", err))
+	}
+
+	if req.URL == "" {
+		return response.ErrorResponse(c, response.ErrURLRequired, "URL is required")
+	}
+
+	// Validate URL format and scheme
+	parsedURL, err := url.ParseRequestURI(req.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "URL must be a valid HTTP or HTTPS URL")
+	}
+
+	input := service.StoryboardInput{Interval: req.Interval, Width: req.Width, Columns: req.Columns}
+	storyboardID, err := vc.VideoService.CreateStoryboard(req.URL, middleware.UserID(c), input)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidStoryboard) {
+			return response.ErrorResponse(c, response.ErrInvalidStoryboard, err.Error())
+		}
+		logger.Log.Error("Failed to start storyboard",
+			zap.Error(err),
+			zap.String("url", req.URL),
+			zap.String("handler", "CreateStoryboardHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrPreviewFailed, "Failed to start storyboard: "+err.Error())
+	}
+
+	data := fiber.Map{
+		"storyboard_id": storyboardID,
+		"status":        service.StatusPending,
+		"message":       "Storyboard queued successfully",
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+func (vc *VideoController) GetStoryboardStatusHandler(c fiber.Ctx) error {
+	storyboardID := c.Params("id")
+
+	if storyboardID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Storyboard ID is required")
+	}
+
+	status, err := vc.VideoService.GetStoryboardStatus(storyboardID)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Storyboard not found or failed to get status")
+	}
+
+	prettyJSON, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeStatus, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+func (vc *VideoController) CancelStoryboardHandler(c fiber.Ctx) error {
+	storyboardID := c.Params("id")
+
+	if storyboardID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Storyboard ID is required")
+	}
+
+	if err := vc.VideoService.CancelStoryboard(storyboardID, middleware.UserID(c)); err != nil {
+		if errors.Is(err, service.ErrDownloadNotRunning) {
+			return response.ErrorResponse(c, response.ErrDownloadNotRunning, "Storyboard is not running")
+		}
+		if errors.Is(err, service.ErrDownloadForbidden) {
+			return response.ErrorResponse(c, response.ErrDownloadForbidden, "Access denied: can only cancel own storyboards")
+		}
+		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Storyboard not found or failed to get status")
+	}
+
+	logger.Log.Info("Cancelled storyboard",
+		zap.String("storyboard_id", storyboardID),
+		zap.String("handler", "CancelStoryboardHandler"),
+	)
+
+	data := fiber.Map{
+		"storyboard_id": storyboardID,
+		"status":        service.StatusCancelled,
+		"message":       "Storyboard cancelled successfully",
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+// StoryboardFileHandler serves the storyboard.vtt track or a sprite sheet of a finished storyboard.
+// The track names sprites relative to itself, so players resolve them to this same route.
+func (vc *VideoController) StoryboardFileHandler(c fiber.Ctx) error {
+	storyboardID := c.Params("id")
+
+	if storyboardID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Storyboard ID is required")
+	}
+
+	file, err := vc.VideoService.StoryboardFile(storyboardID, c.Params("file"))
+	if err != nil {
+		return fileErrorResponse(c, err, "Storyboard or file not found")
+	}
+	return sendPreviewFile(c, file.Path)
+}
+
+// snapshotInput reads the time, format and width query parameters of a snapshot request
+func snapshotInput(c fiber.Ctx) (service.SnapshotInput, error) {
+	input := service.SnapshotInput{
+		Time:   c.Query("time"),
+		Format: c.Query("format"),
+	}
+	if value := c.Query("width"); value != "" {
+		width, err := strconv.Atoi(value)
+		if err != nil {
+			return input, fmt.Errorf("width must be an integer")
+		}
+		input.Width = width
+	}
+	return input, nil
+}
+
+func snapshotErrorResponse(c fiber.Ctx, err error, handler string) error {
+	if errors.Is(err, service.ErrInvalidSnapshot) {
+		return response.ErrorResponse(c, response.ErrInvalidSnapshot, err.Error())
+	}
+	if errors.Is(err, service.ErrPreviewBusy) {
+		return response.ErrorResponse(c, response.ErrTooManyRequests, err.Error())
+	}
+	logger.Log.Warn("Failed to grab snapshot",
+		zap.Error(err),
+		zap.String("handler", handler),
+	)
+	return response.ErrorResponse(c, response.ErrPreviewFailed, err.Error())
+}
+
+// waveformInput reads the resolution and format query parameters of a waveform request
+func waveformInput(c fiber.Ctx) (service.WaveformInput, error) {
+	input := service.WaveformInput{Format: c.Query("format")}
+	if value := c.Query("resolution"); value != "" {
+		resolution, err := strconv.Atoi(value)
+		if err != nil {
+			return input, fmt.Errorf("resolution must be an integer")
+		}
+		input.Resolution = resolution
+	}
+	return input, nil
+}
+
+func waveformErrorResponse(c fiber.Ctx, err error, handler string) error {
+	if errors.Is(err, service.ErrInvalidWaveform) {
+		return response.ErrorResponse(c, response.ErrInvalidWaveform, err.Error())
+	}
+	if errors.Is(err, service.ErrPreviewBusy) {
+		return response.ErrorResponse(c, response.ErrTooManyRequests, err.Error())
+	}
+	logger.Log.Warn("Failed to compute waveform",
+		zap.Error(err),
+		zap.String("handler", handler),
+	)
+	return response.ErrorResponse(c, response.ErrPreviewFailed, err.Error())
+}
+
+// sendPreviewFile serves a cached frame, storyboard or waveform file inline, for <img> and <track>
+// elements and waveform libraries
+func sendPreviewFile(c fiber.Ctx, path string) error {
+	if err := c.SendFile(path); err != nil {
+		return err
+	}
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	switch ext {
+	case "vtt":
+		c.Set(fiber.HeaderContentType, "text/vtt; charset=utf-8")
+	case "dat":
+		c.Set(fiber.HeaderContentType, "application/octet-stream")
+	default:
+		c.Type(ext)
+	}
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", previewMaxAge))
+	return nil
+}
@@ -1,9 +1,18 @@
 package controller
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/verse91/ytb-clipy/backend/internal/middleware"
 	"github.com/verse91/ytb-clipy/backend/internal/repo"
 	"github.com/verse91/ytb-clipy/backend/internal/service"
 	"github.com/verse91/ytb-clipy/backend/pkg/logger"
@@ -19,20 +28,254 @@ type VideoController struct {
 	VideoService *service.VideoService
 }
 
+// DownloadOptionsRequest holds the job options accepted by both download endpoints
+type DownloadOptionsRequest struct {
+	Quality      string `json:"quality"`
+	Audio        string `json:"audio"` // "default", "only" (audio-only) or "mute" (no audio track)
+	AudioCodec   string `json:"audio_codec"`
+	AudioBitrate int    `json:"audio_bitrate"`
+
+	SponsorBlock           string   `json:"sponsorblock"` // "off", "remove" or "mark" (as chapters)
+	SponsorBlockCategories []string `json:"sponsorblock_categories"`
+
+	Subtitles         string   `json:"subtitles"` // "off", "sidecar", "embed" or "burn"
+	SubtitleLanguages []string `json:"subtitle_languages"`
+	SubtitleAuto      bool     `json:"subtitle_auto"` // allow auto-generated subtitles
+	SubtitleFormat    string   `json:"subtitle_format"`
+
+	Thumbnail bool `json:"thumbnail"` // embed the source thumbnail as cover art
+
+	CutPrecision string `json:"cut_precision"` // "fast", "accurate" or "reencode", time range and clip jobs only
+
+	// Animated image export instead of a video, time range jobs only
+	OutputFormat        string  `json:"output_format"`   // "gif" or "webp"
+	AnimationWidth      int     `json:"animation_width"` // pixels, default 480
+	AnimationFPS        float64 `json:"animation_fps"`   // default 15
+	AnimationLoop       int     `json:"animation_loop"`  // times to play, 0 loops forever
+	AnimationSinglePass bool    `json:"animation_single_pass"`
+	AnimationMaxBytes   int64   `json:"animation_max_bytes"` // lower fps and width until the file fits
+
+	// Vertical 1080x1920 output, time range jobs only
+	Reframe      string   `json:"reframe"`        // "center", "crop" or "blur" (blurred background)
+	ReframeCropX *float64 `json:"reframe_crop_x"` // with "crop": 0 is the left edge, 1 the right edge
+
+	WatermarkID string `json:"watermark_id"` // one of the caller's stored watermarks
+
+	VideoCodec    string `json:"video_codec"`    // "h264", "h265", "vp9" or "av1"
+	Container     string `json:"container"`      // "mp4", "webm" or "mkv" (mkv alone remuxes the source)
+	CRF           int    `json:"crf"`            // transcode quality, lower is better, codec dependent default
+	EncoderPreset string `json:"encoder_preset"` // x264 style speed preset, "medium" by default
+
+	// Audio post-processing, not available for muted or animated outputs
+	Normalize  bool    `json:"normalize"`   // EBU R128 loudness normalization
+	TargetLUFS float64 `json:"target_lufs"` // with normalize, -14 by default
+	FadeIn     float64 `json:"fade_in"`     // seconds, up to 30
+	FadeOut    float64 `json:"fade_out"`
+	Stereo     bool    `json:"stereo"` // downmix to two channels
+}
+
 type VideoRequest struct {
 	URL string `json:"url" binding:"required"`
+	DownloadOptionsRequest
+}
+
+type PlaylistRequest struct {
+	URL           string `json:"url" binding:"required"`
+	PlaylistStart int    `json:"playlist_start"` // 1-based, first entry when omitted
+	PlaylistEnd   int    `json:"playlist_end"`   // inclusive, last entry when omitted
+	MaxItems      int    `json:"max_items"`
+	DownloadOptionsRequest
+}
+
+type ProbeRequest struct {
+	URL string `json:"url" binding:"required"`
 }
 
+// TimeRangeVideoRequest takes the end as end_time or as a duration from the start, a missing
+// start_time falls back to the t parameter of the URL and then to 0. Alternatively chapter
+// selects the range by the video's chapters, without any of the three.
 type TimeRangeVideoRequest struct {
-	URL       string `json:"url" binding:"required"`
-	StartTime int    `json:"start_time" binding:"required"`
-	EndTime   int    `json:"end_time" binding:"required"`
+	URL       string          `json:"url" binding:"required"`
+	StartTime Timestamp       `json:"start_time"`
+	EndTime   Timestamp       `json:"end_time"`
+	Duration  Timestamp       `json:"duration"`
+	Chapter   ChapterSelector `json:"chapter"`
+	DownloadOptionsRequest
+}
+
+// Timestamp accepts seconds as a JSON number or a string in any notation the service parses
+// ("1:02:03.250", "02:03", "90.5", "1h2m3s")
+type Timestamp string
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*t = Timestamp(s)
+		return nil
+	}
+	seconds, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("timestamp must be a number of seconds or a string: %w", err)
+	}
+	if seconds < 0 {
+		return fmt.Errorf("timestamp cannot be negative")
+	}
+	*t = Timestamp(strconv.FormatFloat(seconds, 'f', -1, 64))
+	return nil
+}
+
+// ChapterSelector accepts a chapter as its 1-based index (a JSON number), a part of its title
+// (a string) or a list mixing both: 3, "intro", [2, "Q&A"]
+type ChapterSelector []service.ChapterSelector
+
+func (s *ChapterSelector) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+	if len(data) > 0 && data[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		selectors := make(ChapterSelector, 0, len(items))
+		for _, item := range items {
+			sel, err := parseChapterSelector(bytes.TrimSpace(item))
+			if err != nil {
+				return err
+			}
+			selectors = append(selectors, sel)
+		}
+		*s = selectors
+		return nil
+	}
+	sel, err := parseChapterSelector(data)
+	if err != nil {
+		return err
+	}
+	*s = ChapterSelector{sel}
+	return nil
+}
+
+// parseChapterSelector reads one chapter index or title
+func parseChapterSelector(data []byte) (service.ChapterSelector, error) {
+	if len(data) > 0 && data[0] == '"' {
+		var title string
+		if err := json.Unmarshal(data, &title); err != nil {
+			return service.ChapterSelector{}, err
+		}
+		if strings.TrimSpace(title) == "" {
+			return service.ChapterSelector{}, fmt.Errorf("chapter title cannot be empty")
+		}
+		return service.ChapterSelector{Title: title}, nil
+	}
+	index, err := strconv.Atoi(string(data))
+	if err != nil {
+		return service.ChapterSelector{}, fmt.Errorf("chapter must be a 1-based index or a title")
+	}
+	if index < 1 {
+		return service.ChapterSelector{}, fmt.Errorf("chapter index starts at 1")
+	}
+	return service.ChapterSelector{Index: index}, nil
+}
+
+// ClipSegmentRequest is one named range of a clip request, in seconds
+type ClipSegmentRequest struct {
+	Name      string `json:"name"`
+	StartTime int    `json:"start_time"`
+	EndTime   int    `json:"end_time"`
+}
+
+type ClipsRequest struct {
+	URL      string               `json:"url" binding:"required"`
+	Segments []ClipSegmentRequest `json:"segments" binding:"required"`
+	Output   string               `json:"output"` // "separate" (one file per segment) or "concat"
+	DownloadOptionsRequest
+}
+
+func (r DownloadOptionsRequest) toService() service.DownloadOptions {
+	return service.DownloadOptions{
+		Quality:      r.Quality,
+		Audio:        r.Audio,
+		AudioCodec:   r.AudioCodec,
+		AudioBitrate: r.AudioBitrate,
+
+		SponsorBlock:           r.SponsorBlock,
+		SponsorBlockCategories: r.SponsorBlockCategories,
+
+		Subtitles:         r.Subtitles,
+		SubtitleLanguages: r.SubtitleLanguages,
+		SubtitleAuto:      r.SubtitleAuto,
+		SubtitleFormat:    r.SubtitleFormat,
+
+		Thumbnail: r.Thumbnail,
+
+		CutPrecision: r.CutPrecision,
+
+		OutputFormat:        r.OutputFormat,
+		AnimationWidth:      r.AnimationWidth,
+		AnimationFPS:        r.AnimationFPS,
+		AnimationLoop:       r.AnimationLoop,
+		AnimationSinglePass: r.AnimationSinglePass,
+		AnimationMaxBytes:   r.AnimationMaxBytes,
+
+		Reframe:      r.Reframe,
+		ReframeCropX: r.ReframeCropX,
+
+		WatermarkID: r.WatermarkID,
+
+		VideoCodec:    r.VideoCodec,
+		Container:     r.Container,
+		CRF:           r.CRF,
+		EncoderPreset: r.EncoderPreset,
+
+		Normalize:  r.Normalize,
+		TargetLUFS: r.TargetLUFS,
+		FadeIn:     r.FadeIn,
+		FadeOut:    r.FadeOut,
+		Stereo:     r.Stereo,
+	}
+}
+
+// optionErrorCode maps job option validation errors to their response codes
+func optionErrorCode(err error) (int, bool) {
+	switch {
+	case errors.Is(err, service.ErrInvalidQuality):
+		return response.ErrInvalidQuality, true
+	case errors.Is(err, service.ErrInvalidAudio):
+		return response.ErrInvalidAudio, true
+	case errors.Is(err, service.ErrInvalidSponsorBlock):
+		return response.ErrInvalidSponsorBlock, true
+	case errors.Is(err, service.ErrInvalidSubtitles):
+		return response.ErrInvalidSubtitles, true
+	case errors.Is(err, service.ErrInvalidCutPrecision):
+		return response.ErrInvalidCutPrecision, true
+	case errors.Is(err, service.ErrInvalidAnimation):
+		return response.ErrInvalidAnimation, true
+	case errors.Is(err, service.ErrInvalidReframe):
+		return response.ErrInvalidReframe, true
+	case errors.Is(err, service.ErrInvalidEncoding):
+		return response.ErrInvalidEncoding, true
+	case errors.Is(err, service.ErrInvalidWatermark):
+		return response.ErrInvalidWatermark, true
+	case errors.Is(err, service.ErrWatermarkNotFound):
+		return response.ErrWatermarkNotFound, true
+	}
+	return 0, false
 }
 
 func NewVideoController(supabaseClient *supabase.Client) *VideoController {
 	videoRepo := repo.NewVideoRepo(supabaseClient)
 	return &VideoController{
-		VideoService: service.NewVideoService(videoRepo),
+		VideoService: service.NewVideoService(videoRepo, service.NewUserService(supabaseClient)),
 	}
 }
 
@@ -55,6 +298,8 @@ func (vc *VideoController) DownloadHandler(c fiber.Ctx) error {
 
 	logger.Log.Info("Parsed download request",
 		zap.String("url", req.URL),
+		zap.String("quality", req.Quality),
+		zap.String("audio", req.Audio),
 		zap.String("handler", "DownloadHandler"),
 	)
 
@@ -85,8 +330,11 @@ func (vc *VideoController) DownloadHandler(c fiber.Ctx) error {
 		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "URL must use HTTP or HTTPS scheme")
 	}
 
-	downloadID, err := vc.VideoService.DownloadFullVideo(req.URL)
+	downloadID, err := vc.VideoService.DownloadFullVideo(req.URL, middleware.UserID(c), req.toService())
 	if err != nil {
+		if code, ok := optionErrorCode(err); ok {
+			return response.ErrorResponse(c, code, err.Error())
+		}
 		logger.Log.Error("Failed to start download",
 			zap.Error(err),
 			zap.String("url", req.URL),
@@ -97,8 +345,8 @@ func (vc *VideoController) DownloadHandler(c fiber.Ctx) error {
 
 	data := fiber.Map{
 		"download_id": downloadID,
-		"status":      "processing",
-		"message":     "Download started successfully",
+		"status":      service.StatusPending,
+		"message":     "Download queued successfully",
 	}
 
 	prettyJSON, err := json.MarshalIndent(data, "", "  ")
@@ -121,12 +369,9 @@ func (vc *VideoController) GetDownloadStatus(c fiber.Ctx) error {
 		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Download not found or failed to get status")
 	}
 
-	data := fiber.Map{
-		"download_id": downloadID,
-		"status":      status,
-	}
+	status["download_id"] = downloadID
 
-	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	prettyJSON, err := json.MarshalIndent(status, "", "  ")
 	if err != nil {
 		return response.ErrorResponse(c, response.ErrSerializeStatus, "Failed to serialize response")
 	}
@@ -147,8 +392,15 @@ func (vc *VideoController) DownloadTimeRangeHandler(c fiber.Ctx) error {
 
 	logger.Log.Info("Parsed time range download request",
 		zap.String("url", req.URL),
-		zap.Int("start_time", req.StartTime),
-		zap.Int("end_time", req.EndTime),
+		zap.String("start_time", string(req.StartTime)),
+		zap.String("end_time", string(req.EndTime)),
+		zap.String("duration", string(req.Duration)),
+		zap.Int("chapters", len(req.Chapter)),
+		zap.String("quality", req.Quality),
+		zap.String("audio", req.Audio),
+		zap.String("cut_precision", req.CutPrecision),
+		zap.String("output_format", req.OutputFormat),
+		zap.String("reframe", req.Reframe),
 		zap.String("handler", "DownloadTimeRangeHandler"),
 	)
 
@@ -176,28 +428,188 @@ func (vc *VideoController) DownloadTimeRangeHandler(c fiber.Ctx) error {
 		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "URL must use HTTP or HTTPS scheme")
 	}
 
-	if req.StartTime < 0 || req.EndTime <= req.StartTime {
-		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "Invalid time range: start_time must be >= 0 and end_time must be > start_time")
+	input := service.TimeRangeInput{
+		Start:    string(req.StartTime),
+		End:      string(req.EndTime),
+		Duration: string(req.Duration),
+		Chapters: req.Chapter,
 	}
-
-	downloadID, err := vc.VideoService.DownloadVideoTimeRange(req.URL, req.StartTime, req.EndTime)
+	job, err := vc.VideoService.DownloadVideoTimeRange(req.URL, middleware.UserID(c), input, req.toService())
 	if err != nil {
+		if code, ok := optionErrorCode(err); ok {
+			return response.ErrorResponse(c, code, err.Error())
+		}
+		if errors.Is(err, service.ErrInvalidTimeRange) {
+			return response.ErrorResponse(c, response.ErrInvalidTimeRange, err.Error())
+		}
+		if errors.Is(err, service.ErrEndPastDuration) {
+			return response.ErrorResponse(c, response.ErrEndPastDuration, err.Error())
+		}
+		if errors.Is(err, service.ErrNoChapters) {
+			return response.ErrorResponse(c, response.ErrNoChapters, err.Error())
+		}
+		if errors.Is(err, service.ErrVideoUnavailable) {
+			return response.ErrorResponse(c, response.ErrVideoUnavailable, err.Error())
+		}
+		if errors.Is(err, service.ErrProbeFailed) {
+			logger.Log.Warn("Failed to probe video for time range download",
+				zap.Error(err),
+				zap.String("url", req.URL),
+				zap.String("handler", "DownloadTimeRangeHandler"),
+			)
+			return response.ErrorResponse(c, response.ErrProbeFailed, err.Error())
+		}
 		logger.Log.Error("Failed to start time range download",
 			zap.Error(err),
 			zap.String("url", req.URL),
-			zap.Int("start_time", req.StartTime),
-			zap.Int("end_time", req.EndTime),
+			zap.String("start_time", string(req.StartTime)),
+			zap.String("end_time", string(req.EndTime)),
 			zap.String("handler", "DownloadTimeRangeHandler"),
 		)
 		return response.ErrorResponse(c, response.ErrDownloadStartFailed, "Failed to start time range download: "+err.Error())
 	}
 
 	data := fiber.Map{
-		"download_id": downloadID,
-		"status":      "processing",
-		"message":     "Time range download started successfully",
-		"start_time":  req.StartTime,
-		"end_time":    req.EndTime,
+		"download_id": job.ID,
+		"status":      service.StatusPending,
+		"message":     "Time range download queued successfully",
+		"start_time":  job.Range.StartSeconds(),
+		"end_time":    job.Range.EndSeconds(),
+	}
+	if len(job.Chapters) > 0 {
+		data["chapters"] = job.Chapters
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+// DownloadPlaylistHandler starts one full video download per playlist entry
+func (vc *VideoController) DownloadPlaylistHandler(c fiber.Ctx) error {
+	var req PlaylistRequest
+
+	if err := c.Bind().JSON(&req); err != nil {
+		logger.Log.Error("JSON bind error in playlist download request",
+			zap.Error(err),
+			zap.String("handler", "DownloadPlaylistHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, fmt.Sprintf("Invalid request body: %v", err))
+	}
+
+	logger.Log.Info("Parsed playlist download request",
+		zap.String("url", req.URL),
+		zap.Int("playlist_start", req.PlaylistStart),
+		zap.Int("playlist_end", req.PlaylistEnd),
+		zap.Int("max_items", req.MaxItems),
+		zap.String("handler", "DownloadPlaylistHandler"),
+	)
+
+	if req.URL == "" {
+		return response.ErrorResponse(c, response.ErrURLRequired, "URL is required")
+	}
+
+	// Validate URL format and scheme
+	parsedURL, err := url.ParseRequestURI(req.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "URL must be a valid HTTP or HTTPS URL")
+	}
+
+	rng := service.PlaylistRange{Start: req.PlaylistStart, End: req.PlaylistEnd, MaxItems: req.MaxItems}
+	job, err := vc.VideoService.DownloadPlaylist(req.URL, middleware.UserID(c), rng, req.toService(), middleware.ClientThrottle(c))
+	if err != nil {
+		if code, ok := optionErrorCode(err); ok {
+			return response.ErrorResponse(c, code, err.Error())
+		}
+		switch {
+		case errors.Is(err, service.ErrInvalidPlaylist):
+			return response.ErrorResponse(c, response.ErrInvalidPlaylist, err.Error())
+		case errors.Is(err, service.ErrInsufficientCredits):
+			return response.ErrorResponse(c, response.ErrInsufficientCredits, err.Error())
+		case errors.Is(err, service.ErrVideoUnavailable):
+			return response.ErrorResponse(c, response.ErrVideoUnavailable, err.Error())
+		case errors.Is(err, service.ErrProbeFailed):
+			return response.ErrorResponse(c, response.ErrProbeFailed, err.Error())
+		}
+		logger.Log.Error("Failed to start playlist download",
+			zap.Error(err),
+			zap.String("url", req.URL),
+			zap.String("handler", "DownloadPlaylistHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrDownloadStartFailed, "Failed to start playlist download: "+err.Error())
+	}
+
+	data := fiber.Map{
+		"playlist_id": job.ID,
+		"title":       job.Title,
+		"status":      service.StatusPending,
+		"message":     "Playlist download queued successfully",
+		"entry_count": len(job.Children),
+		"children":    job.Children,
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+func (vc *VideoController) GetPlaylistStatusHandler(c fiber.Ctx) error {
+	playlistID := c.Params("id")
+
+	if playlistID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Playlist ID is required")
+	}
+
+	status, err := vc.VideoService.GetPlaylistStatus(playlistID, middleware.UserID(c))
+	if err != nil {
+		if errors.Is(err, service.ErrDownloadForbidden) {
+			return response.ErrorResponse(c, response.ErrDownloadForbidden, "Access denied: can only view own playlists")
+		}
+		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Playlist not found or failed to get status")
+	}
+
+	status["playlist_id"] = playlistID
+
+	prettyJSON, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeStatus, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+func (vc *VideoController) CancelPlaylistHandler(c fiber.Ctx) error {
+	playlistID := c.Params("id")
+
+	if playlistID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Playlist ID is required")
+	}
+
+	if err := vc.VideoService.CancelPlaylist(playlistID, middleware.UserID(c)); err != nil {
+		if errors.Is(err, service.ErrDownloadNotRunning) {
+			return response.ErrorResponse(c, response.ErrDownloadNotRunning, "Playlist is not running")
+		}
+		if errors.Is(err, service.ErrDownloadForbidden) {
+			return response.ErrorResponse(c, response.ErrDownloadForbidden, "Access denied: can only cancel own playlists")
+		}
+		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Playlist not found or failed to get status")
+	}
+
+	logger.Log.Info("Cancelled playlist download",
+		zap.String("playlist_id", playlistID),
+		zap.String("handler", "CancelPlaylistHandler"),
+	)
+
+	data := fiber.Map{
+		"playlist_id": playlistID,
+		"status":      service.StatusCancelled,
+		"message":     "Playlist download cancelled successfully",
 	}
 
 	prettyJSON, err := json.MarshalIndent(data, "", "  ")
@@ -208,6 +620,49 @@ func (vc *VideoController) DownloadTimeRangeHandler(c fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).Send(prettyJSON)
 }
 
+// ProbeHandler returns a video's metadata and formats so users can check it before downloading
+func (vc *VideoController) ProbeHandler(c fiber.Ctx) error {
+	var req ProbeRequest
+
+	if err := c.Bind().JSON(&req); err != nil {
+		logger.Log.Error("JSON bind error in probe request",
+			zap.Error(err),
+			zap.String("handler", "ProbeHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, fmt.Sprintf("Invalid request body: %v", err))
+	}
+
+	if req.URL == "" {
+		return response.ErrorResponse(c, response.ErrURLRequired, "URL is required")
+	}
+
+	// Validate URL format and scheme
+	parsedURL, err := url.ParseRequestURI(req.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "URL must be a valid HTTP or HTTPS URL")
+	}
+
+	info, err := vc.VideoService.ProbeVideo(req.URL)
+	if err != nil {
+		logger.Log.Warn("Failed to probe video",
+			zap.Error(err),
+			zap.String("url", req.URL),
+			zap.String("handler", "ProbeHandler"),
+		)
+		if errors.Is(err, service.ErrVideoUnavailable) {
+			return response.ErrorResponse(c, response.ErrVideoUnavailable, err.Error())
+		}
+		return response.ErrorResponse(c, response.ErrProbeFailed, err.Error())
+	}
+
+	prettyJSON, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
 func (vc *VideoController) GetTimeRangeDownloadStatusHandler(c fiber.Ctx) error {
 	downloadID := c.Params("id")
 
@@ -227,3 +682,314 @@ func (vc *VideoController) GetTimeRangeDownloadStatusHandler(c fiber.Ctx) error
 
 	return c.Status(fiber.StatusOK).Send(prettyJSON)
 }
+
+func (vc *VideoController) CancelDownloadHandler(c fiber.Ctx) error {
+	downloadID := c.Params("id")
+
+	if downloadID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Download ID is required")
+	}
+
+	if err := vc.VideoService.CancelDownload(downloadID, middleware.UserID(c)); err != nil {
+		if errors.Is(err, service.ErrDownloadNotRunning) {
+			return response.ErrorResponse(c, response.ErrDownloadNotRunning, "Download is not running")
+		}
+		if errors.Is(err, service.ErrDownloadForbidden) {
+			return response.ErrorResponse(c, response.ErrDownloadForbidden, "Access denied: can only cancel own downloads")
+		}
+		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Download not found or failed to get status")
+	}
+
+	logger.Log.Info("Cancelled download",
+		zap.String("download_id", downloadID),
+		zap.String("handler", "CancelDownloadHandler"),
+	)
+
+	data := fiber.Map{
+		"download_id": downloadID,
+		"status":      service.StatusCancelled,
+		"message":     "Download cancelled successfully",
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+func (vc *VideoController) CancelTimeRangeDownloadHandler(c fiber.Ctx) error {
+	downloadID := c.Params("id")
+
+	if downloadID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Download ID is required")
+	}
+
+	if err := vc.VideoService.CancelTimeRangeDownload(downloadID, middleware.UserID(c)); err != nil {
+		if errors.Is(err, service.ErrDownloadNotRunning) {
+			return response.ErrorResponse(c, response.ErrDownloadNotRunning, "Time range download is not running")
+		}
+		if errors.Is(err, service.ErrDownloadForbidden) {
+			return response.ErrorResponse(c, response.ErrDownloadForbidden, "Access denied: can only cancel own downloads")
+		}
+		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Time range download not found or failed to get status")
+	}
+
+	logger.Log.Info("Cancelled time range download",
+		zap.String("download_id", downloadID),
+		zap.String("handler", "CancelTimeRangeDownloadHandler"),
+	)
+
+	data := fiber.Map{
+		"download_id": downloadID,
+		"status":      service.StatusCancelled,
+		"message":     "Time range download cancelled successfully",
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+// DownloadClipsHandler starts a multi-range job cutting several segments from one video
+func (vc *VideoController) DownloadClipsHandler(c fiber.Ctx) error {
+	var req ClipsRequest
+
+	if err := c.Bind().JSON(&req); err != nil {
+		logger.Log.Error("JSON bind error in clip download request",
+			zap.Error(err),
+			zap.String("handler", "DownloadClipsHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, fmt.Sprintf("Invalid request body: %v", err))
+	}
+
+	logger.Log.Info("Parsed clip download request",
+		zap.String("url", req.URL),
+		zap.Int("segments", len(req.Segments)),
+		zap.String("output", req.Output),
+		zap.String("handler", "DownloadClipsHandler"),
+	)
+
+	if req.URL == "" {
+		return response.ErrorResponse(c, response.ErrURLRequired, "URL is required")
+	}
+
+	// Validate URL format and scheme
+	parsedURL, err := url.ParseRequestURI(req.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return response.ErrorResponse(c, response.ErrInvalidRequestBody, "URL must be a valid HTTP or HTTPS URL")
+	}
+
+	segments := make([]service.ClipSegment, len(req.Segments))
+	for i, seg := range req.Segments {
+		segments[i] = service.ClipSegment{Name: seg.Name, StartTime: seg.StartTime, EndTime: seg.EndTime}
+	}
+	job, err := vc.VideoService.DownloadClips(req.URL, middleware.UserID(c), segments, req.Output, req.toService())
+	if err != nil {
+		if code, ok := optionErrorCode(err); ok {
+			return response.ErrorResponse(c, code, err.Error())
+		}
+		switch {
+		case errors.Is(err, service.ErrInvalidClips):
+			return response.ErrorResponse(c, response.ErrInvalidClips, err.Error())
+		case errors.Is(err, service.ErrEndPastDuration):
+			return response.ErrorResponse(c, response.ErrEndPastDuration, err.Error())
+		case errors.Is(err, service.ErrVideoUnavailable):
+			return response.ErrorResponse(c, response.ErrVideoUnavailable, err.Error())
+		case errors.Is(err, service.ErrProbeFailed):
+			return response.ErrorResponse(c, response.ErrProbeFailed, err.Error())
+		}
+		logger.Log.Error("Failed to start clip download",
+			zap.Error(err),
+			zap.String("url", req.URL),
+			zap.String("handler", "DownloadClipsHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrDownloadStartFailed, "Failed to start clip download: "+err.Error())
+	}
+
+	data := fiber.Map{
+		"clip_job_id": job.ID,
+		"status":      service.StatusPending,
+		"message":     "Clip download queued successfully",
+		"output":      job.Output,
+		"segments":    job.Segments,
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+func (vc *VideoController) GetClipStatusHandler(c fiber.Ctx) error {
+	clipJobID := c.Params("id")
+
+	if clipJobID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Clip job ID is required")
+	}
+
+	status, err := vc.VideoService.GetClipStatus(clipJobID)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Clip job not found or failed to get status")
+	}
+
+	status["clip_job_id"] = clipJobID
+
+	prettyJSON, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeStatus, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+func (vc *VideoController) CancelClipsHandler(c fiber.Ctx) error {
+	clipJobID := c.Params("id")
+
+	if clipJobID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Clip job ID is required")
+	}
+
+	if err := vc.VideoService.CancelClips(clipJobID, middleware.UserID(c)); err != nil {
+		if errors.Is(err, service.ErrDownloadNotRunning) {
+			return response.ErrorResponse(c, response.ErrDownloadNotRunning, "Clip job is not running")
+		}
+		if errors.Is(err, service.ErrDownloadForbidden) {
+			return response.ErrorResponse(c, response.ErrDownloadForbidden, "Access denied: can only cancel own clip jobs")
+		}
+		return response.ErrorResponse(c, response.ErrDownloadNotFound, "Clip job not found or failed to get status")
+	}
+
+	logger.Log.Info("Cancelled clip download",
+		zap.String("clip_job_id", clipJobID),
+		zap.String("handler", "CancelClipsHandler"),
+	)
+
+	data := fiber.Map{
+		"clip_job_id": clipJobID,
+		"status":      service.StatusCancelled,
+		"message":     "Clip download cancelled, segments already cut are kept",
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+func (vc *VideoController) DownloadFileHandler(c fiber.Ctx) error {
+	downloadID := c.Params("id")
+
+	if downloadID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Download ID is required")
+	}
+
+	file, err := vc.VideoService.DownloadFile(downloadID, middleware.UserID(c))
+	if err != nil {
+		return fileErrorResponse(c, err, "Download not found or failed to get status")
+	}
+	return sendOutputFile(c, file)
+}
+
+func (vc *VideoController) TimeRangeDownloadFileHandler(c fiber.Ctx) error {
+	downloadID := c.Params("id")
+
+	if downloadID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Download ID is required")
+	}
+
+	file, err := vc.VideoService.TimeRangeDownloadFile(downloadID, middleware.UserID(c))
+	if err != nil {
+		return fileErrorResponse(c, err, "Time range download not found or failed to get status")
+	}
+	return sendOutputFile(c, file)
+}
+
+// ClipFileHandler serves the joined file of a concat clip job
+func (vc *VideoController) ClipFileHandler(c fiber.Ctx) error {
+	clipJobID := c.Params("id")
+
+	if clipJobID == "" {
+		return response.ErrorResponse(c, response.ErrDownloadIDRequired, "Clip job ID is required")
+	}
+
+	file, err := vc.VideoService.ClipFile(clipJobID, middleware.UserID(c))
+	if err != nil {
+		return fileErrorResponse(c, err, "Clip job not found or failed to get status")
+	}
+	return sendOutputFile(c, file)
+}
+
+func fileErrorResponse(c fiber.Ctx, err error, notFound string) error {
+	switch {
+	case errors.Is(err, service.ErrDownloadForbidden):
+		return response.ErrorResponse(c, response.ErrDownloadForbidden, "Access denied: can only download own files")
+	case errors.Is(err, service.ErrFileNotReady):
+		return response.ErrorResponse(c, response.ErrFileNotReady, "Download file is not ready")
+	case errors.Is(err, service.ErrFileGone):
+		return response.ErrorResponse(c, response.ErrFileNotFound, "Download file not found")
+	}
+	return response.ErrorResponse(c, response.ErrDownloadNotFound, notFound)
+}
+
+// sendOutputFile streams a finished file as an attachment with byte range support
+func sendOutputFile(c fiber.Ctx, file service.OutputFile) error {
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrFileNotFound, "Download file not found")
+	}
+
+	// fasthttp serves ranges but ignores If-Range, so drop the range when the client's copy is stale
+	if !ifRangeFresh(c.Get(fiber.HeaderIfRange), info.ModTime()) {
+		c.Request().Header.Del(fiber.HeaderRange)
+	}
+
+	if err := c.SendFile(file.Path, fiber.SendFile{ByteRange: true}); err != nil {
+		return err
+	}
+	// Set after SendFile, which guesses the type itself and gets audio containers wrong
+	c.Type(strings.TrimPrefix(filepath.Ext(file.Path), "."))
+	c.Set(fiber.HeaderContentDisposition, attachmentDisposition(file.Name))
+	return nil
+}
+
+// ifRangeFresh reports whether a Range request may be honoured. We send no ETags, so only an
+// If-Range date equal to the file's Last-Modified (second precision) can match.
+func ifRangeFresh(ifRange string, modTime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return modTime.UTC().Truncate(time.Second).Equal(t)
+}
+
+// attachmentDisposition builds a Content-Disposition header for a file name that may hold
+// non-ASCII titles: an ASCII fallback plus the RFC 5987 UTF-8 form
+func attachmentDisposition(name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	var encoded strings.Builder
+	for _, c := range []byte(name) {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, encoded.String())
+}
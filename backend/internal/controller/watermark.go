@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/verse91/ytb-clipy/backend/internal/middleware"
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+	"github.com/verse91/ytb-clipy/backend/internal/service"
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+	"github.com/verse91/ytb-clipy/backend/pkg/logger"
+	"github.com/verse91/ytb-clipy/backend/pkg/response"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+)
+
+// CreateWatermarkHandler stores a watermark from a multipart form: an "image" PNG file or a
+// "text" field, plus optional name, position, margin, opacity and scale fields
+func (vc *VideoController) CreateWatermarkHandler(c fiber.Ctx) error {
+	input := service.WatermarkInput{
+		Name:     c.FormValue("name"),
+		Text:     c.FormValue("text"),
+		Position: c.FormValue("position"),
+	}
+
+	// 0 is a valid margin, only a missing one takes the default
+	if value := c.FormValue("margin"); value != "" {
+		margin, err := strconv.Atoi(value)
+		if err != nil {
+			return response.ErrorResponse(c, response.ErrInvalidWatermark, "margin must be an integer")
+		}
+		input.Margin = &margin
+	}
+	var err error
+	if input.Opacity, err = formFloat(c, "opacity"); err != nil {
+		return response.ErrorResponse(c, response.ErrInvalidWatermark, err.Error())
+	}
+	if input.Scale, err = formFloat(c, "scale"); err != nil {
+		return response.ErrorResponse(c, response.ErrInvalidWatermark, err.Error())
+	}
+
+	if header, err := c.FormFile("image"); err == nil {
+		if header.Size > downloader.MaxWatermarkImageBytes {
+			return response.ErrorResponse(c, response.ErrInvalidWatermark, "Watermark image is too large")
+		}
+		file, err := header.Open()
+		if err != nil {
+			return response.ErrorResponse(c, response.ErrInvalidRequestBody, "Failed to read watermark image")
+		}
+		// One byte past the limit is enough to tell the service the upload is too large
+		input.Image, err = io.ReadAll(io.LimitReader(file, downloader.MaxWatermarkImageBytes+1))
+		file.Close()
+		if err != nil {
+			return response.ErrorResponse(c, response.ErrInvalidRequestBody, "Failed to read watermark image")
+		}
+	}
+
+	wm, err := vc.VideoService.CreateWatermark(middleware.UserID(c), input)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidWatermark) {
+			return response.ErrorResponse(c, response.ErrInvalidWatermark, err.Error())
+		}
+		logger.Log.Error("Failed to create watermark",
+			zap.Error(err),
+			zap.String("handler", "CreateWatermarkHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrWatermarkFailed, "Failed to create watermark: "+err.Error())
+	}
+
+	logger.Log.Info("Created watermark",
+		zap.String("watermark_id", wm.ID),
+		zap.String("kind", wm.Kind),
+		zap.String("handler", "CreateWatermarkHandler"),
+	)
+
+	prettyJSON, err := json.MarshalIndent(watermarkResponse(*wm), "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+// ListWatermarksHandler returns the caller's watermarks
+func (vc *VideoController) ListWatermarksHandler(c fiber.Ctx) error {
+	watermarks, err := vc.VideoService.ListWatermarks(middleware.UserID(c))
+	if err != nil {
+		logger.Log.Error("Failed to list watermarks",
+			zap.Error(err),
+			zap.String("handler", "ListWatermarksHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrWatermarkFailed, "Failed to list watermarks")
+	}
+
+	items := make([]fiber.Map, 0, len(watermarks))
+	for _, wm := range watermarks {
+		items = append(items, watermarkResponse(wm))
+	}
+
+	prettyJSON, err := json.MarshalIndent(fiber.Map{"watermarks": items}, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+// DeleteWatermarkHandler removes one of the caller's watermarks
+func (vc *VideoController) DeleteWatermarkHandler(c fiber.Ctx) error {
+	watermarkID := c.Params("id")
+
+	if err := vc.VideoService.DeleteWatermark(watermarkID, middleware.UserID(c)); err != nil {
+		if errors.Is(err, service.ErrWatermarkNotFound) {
+			return response.ErrorResponse(c, response.ErrWatermarkNotFound, "Watermark not found")
+		}
+		if errors.Is(err, service.ErrWatermarkInUse) {
+			return response.ErrorResponse(c, response.ErrWatermarkInUse, err.Error())
+		}
+		logger.Log.Error("Failed to delete watermark",
+			zap.Error(err),
+			zap.String("watermark_id", watermarkID),
+			zap.String("handler", "DeleteWatermarkHandler"),
+		)
+		return response.ErrorResponse(c, response.ErrWatermarkFailed, "Failed to delete watermark")
+	}
+
+	logger.Log.Info("Deleted watermark",
+		zap.String("watermark_id", watermarkID),
+		zap.String("handler", "DeleteWatermarkHandler"),
+	)
+
+	data := fiber.Map{
+		"watermark_id": watermarkID,
+		"message":      "Watermark deleted successfully",
+	}
+
+	prettyJSON, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return response.ErrorResponse(c, response.ErrSerializeResponse, "Failed to serialize response")
+	}
+
+	return c.Status(fiber.StatusOK).Send(prettyJSON)
+}
+
+// watermarkResponse is a stored watermark as clients see it, without the server side image path
+func watermarkResponse(wm model.Watermark) fiber.Map {
+	data := fiber.Map{
+		"watermark_id": wm.ID,
+		"name":         wm.Name,
+		"kind":         wm.Kind,
+		"position":     wm.Position,
+		"margin":       wm.Margin,
+		"opacity":      wm.Opacity,
+		"scale":        wm.Scale,
+	}
+	if wm.Text != "" {
+		data["text"] = wm.Text
+	}
+	if wm.CreatedAt != "" {
+		data["created_at"] = wm.CreatedAt
+	}
+	return data
+}
+
+// formFloat reads an optional number form field, 0 when missing
+func formFloat(c fiber.Ctx, key string) (float64, error) {
+	value := c.FormValue(key)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number", key)
+	}
+	return n, nil
+}
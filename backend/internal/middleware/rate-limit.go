@@ -186,3 +186,17 @@ func RateLimitMiddleware(c fiber.Ctx) error {
 	}
 	return c.Next()
 }
+
+// ClientThrottle returns a function that reserves one slot of the caller's rate limit and tells
+// how long the work behind it must wait for the slot, for work a single request fans out into
+// (playlist children). It is safe to call after the request has finished.
+func ClientThrottle(c fiber.Ctx) func() time.Duration {
+	return clientThrottle(getClientIP(c))
+}
+
+func clientThrottle(ip string) func() time.Duration {
+	limiter := getLimiter(ip)
+	return func() time.Duration {
+		return limiter.Reserve().Delay()
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientThrottle(t *testing.T) {
+	requestsPerSecond, burst = 5, 2
+	const slot = time.Second / 5
+
+	tests := []struct {
+		name     string
+		ip       string
+		requests int             // requests the client made through RateLimitMiddleware first
+		want     []time.Duration // how long each child waits, in order
+	}{
+		{name: "children within the burst", ip: "192.0.2.1", want: []time.Duration{0, 0}},
+		{name: "children past the burst wait a slot each", ip: "192.0.2.2", want: []time.Duration{0, 0, slot, 2 * slot, 3 * slot}},
+		{name: "requests share the limit with children", ip: "192.0.2.3", requests: 2, want: []time.Duration{slot, 2 * slot}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range tt.requests {
+				getLimiter(tt.ip).Allow()
+			}
+			throttle := clientThrottle(tt.ip)
+			for i, want := range tt.want {
+				// The limiter refills while the test runs, allow for that
+				if got := throttle(); got > want || got < want-slot/2 {
+					t.Errorf("child %d waits %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -50,23 +51,23 @@ func AdminAuthMiddleware(c fiber.Ctx) error {
 	return c.Next()
 }
 
-// UserAuthMiddleware validates user can only access their own data
-func UserAuthMiddleware(c fiber.Ctx) error {
-	userID := c.Params("userID")
-	if userID == "" {
-		return response.ErrorResponse(c, 400, "User ID is required")
-	}
+// UserIDLocal is the fiber.Locals key the authenticated user's ID is stored under
+const UserIDLocal = "userID"
+
+var errJWTSecretMissing = errors.New("JWT secret not configured")
 
+// bearerUserID validates the Bearer JWT on the request and returns its subject
+func bearerUserID(c fiber.Ctx) (string, error) {
 	// Get JWT token from Authorization header
 	authHeader := c.Get("Authorization")
 	if authHeader == "" {
-		return response.ErrorResponse(c, 401, "Authorization header required")
+		return "", errors.New("Authorization header required")
 	}
 
 	// Extract token from "Bearer <token>" format
 	tokenParts := strings.Split(authHeader, " ")
 	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-		return response.ErrorResponse(c, 401, "Invalid authorization header format")
+		return "", errors.New("Invalid authorization header format")
 	}
 
 	tokenString := tokenParts[1]
@@ -74,7 +75,7 @@ func UserAuthMiddleware(c fiber.Ctx) error {
 	// Get JWT secret from environment
 	jwtSecret := utils.GetEnv("JWT_SECRET", "")
 	if jwtSecret == "" {
-		return response.ErrorResponse(c, 500, "JWT secret not configured")
+		return "", errJWTSecretMissing
 	}
 
 	// Parse and validate JWT token
@@ -87,24 +88,70 @@ func UserAuthMiddleware(c fiber.Ctx) error {
 	})
 
 	if err != nil {
-		return response.ErrorResponse(c, 401, "Invalid token")
+		return "", errors.New("Invalid token")
 	}
 
 	// Extract claims
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// Extract user ID from claims
-		authUserID, ok := claims["sub"].(string)
-		if !ok {
-			return response.ErrorResponse(c, 401, "Invalid token claims")
-		}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", errors.New("Invalid token")
+	}
 
-		// User can only access their own data
-		if authUserID != userID {
-			return response.ErrorResponse(c, 403, "Access denied: can only access own data")
-		}
+	// Extract user ID from claims
+	authUserID, ok := claims["sub"].(string)
+	if !ok {
+		return "", errors.New("Invalid token claims")
+	}
+	return authUserID, nil
+}
+
+func tokenErrorResponse(c fiber.Ctx, err error) error {
+	if errors.Is(err, errJWTSecretMissing) {
+		return response.ErrorResponse(c, 500, err.Error())
+	}
+	return response.ErrorResponse(c, 401, err.Error())
+}
+
+// UserAuthMiddleware validates user can only access their own data
+func UserAuthMiddleware(c fiber.Ctx) error {
+	userID := c.Params("userID")
+	if userID == "" {
+		return response.ErrorResponse(c, 400, "User ID is required")
+	}
+
+	authUserID, err := bearerUserID(c)
+	if err != nil {
+		return tokenErrorResponse(c, err)
+	}
+
+	// User can only access their own data
+	if authUserID != userID {
+		return response.ErrorResponse(c, 403, "Access denied: can only access own data")
+	}
 
+	return c.Next()
+}
+
+// RequireUserMiddleware rejects requests without a valid user token and stores the user ID in Locals
+func RequireUserMiddleware(c fiber.Ctx) error {
+	authUserID, err := bearerUserID(c)
+	if err != nil {
+		return tokenErrorResponse(c, err)
+	}
+	c.Locals(UserIDLocal, authUserID)
+	return c.Next()
+}
+
+// OptionalUserMiddleware lets anonymous requests through, but a token that is sent must be valid
+func OptionalUserMiddleware(c fiber.Ctx) error {
+	if c.Get("Authorization") == "" {
 		return c.Next()
 	}
+	return RequireUserMiddleware(c)
+}
 
-	return response.ErrorResponse(c, 401, "Invalid token")
+// UserID returns the user ID stored by RequireUserMiddleware or OptionalUserMiddleware, empty when anonymous
+func UserID(c fiber.Ctx) string {
+	userID, _ := c.Locals(UserIDLocal).(string)
+	return userID
 }
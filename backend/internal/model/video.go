@@ -0,0 +1,140 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// JobOptions are the per-job settings persisted alongside a download row
+type JobOptions struct {
+	Quality                string   `json:"quality"`
+	AudioMode              string   `json:"audio_mode"`
+	AudioCodec             string   `json:"audio_codec,omitempty"`
+	AudioBitrate           int      `json:"audio_bitrate,omitempty"`
+	SponsorBlock           string   `json:"sponsorblock"`
+	SponsorBlockCategories []string `json:"sponsorblock_categories,omitempty"`
+	Subtitles              string   `json:"subtitles"`
+	SubtitleLanguages      []string `json:"subtitle_languages,omitempty"`
+	SubtitleAuto           bool     `json:"subtitle_auto,omitempty"`
+	SubtitleFormat         string   `json:"subtitle_format,omitempty"`
+	Thumbnail              bool     `json:"thumbnail"`
+	CutPrecision           string   `json:"cut_precision,omitempty"` // time range jobs only
+	OutputFormat           string   `json:"output_format,omitempty"` // gif or webp, time range jobs only
+	AnimationWidth         int      `json:"animation_width,omitempty"`
+	AnimationFPS           float64  `json:"animation_fps,omitempty"`
+	AnimationLoop          int      `json:"animation_loop,omitempty"`
+	AnimationSinglePass    bool     `json:"animation_single_pass,omitempty"`
+	AnimationMaxBytes      int64    `json:"animation_max_bytes,omitempty"`
+	Reframe                string   `json:"reframe,omitempty"`        // vertical layout, time range jobs only
+	ReframeCropX           *float64 `json:"reframe_crop_x,omitempty"` // crop layout only, 0 left to 1 right
+	WatermarkID            string   `json:"watermark_id,omitempty"`
+	VideoCodec             string   `json:"video_codec,omitempty"` // requested codec, empty keeps the source one
+	Container              string   `json:"container,omitempty"`
+	CRF                    int      `json:"crf,omitempty"`
+	EncoderPreset          string   `json:"encoder_preset,omitempty"`
+	Normalize              bool     `json:"normalize,omitempty"`
+	TargetLUFS             float64  `json:"target_lufs,omitempty"`
+	FadeIn                 float64  `json:"fade_in,omitempty"` // seconds
+	FadeOut                float64  `json:"fade_out,omitempty"`
+	Stereo                 bool     `json:"stereo,omitempty"` // downmixed to two channels
+}
+
+// Watermark is a logo or text a user stored to brand their clips with
+type Watermark struct {
+	ID        string  `json:"id"`
+	UserID    string  `json:"user_id"`
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"` // image or text
+	Text      string  `json:"text,omitempty"`
+	ImageFile string  `json:"image_file,omitempty"` // path of the stored PNG, never sent to clients
+	Position  string  `json:"position"`
+	Margin    int     `json:"margin"`
+	Opacity   float64 `json:"opacity"`
+	Scale     float64 `json:"scale"`
+	CreatedAt string  `json:"created_at,omitempty"`
+}
+
+// SponsorSegment is a SponsorBlock segment removed from or marked in the output, in source seconds
+type SponsorSegment struct {
+	Category string  `json:"category"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+}
+
+// JobResult is what a finished job reports back on its row
+type JobResult struct {
+	OutputFile      string           `json:"output_file,omitempty"`
+	OutputName      string           `json:"output_name,omitempty"` // title based name offered to clients
+	OutputSize      int64            `json:"output_size,omitempty"`
+	OutputWidth     int              `json:"output_width,omitempty"`
+	OutputHeight    int              `json:"output_height,omitempty"`
+	VideoCodec      string           `json:"output_video_codec,omitempty"`
+	AudioCodec      string           `json:"output_audio_codec,omitempty"`
+	Container       string           `json:"output_container,omitempty"`
+	SourceFormat    string           `json:"source_format,omitempty"` // yt-dlp format the quality preset resolved to
+	SourceHeight    int              `json:"source_height,omitempty"`
+	FinalDuration   float64          `json:"final_duration,omitempty"`
+	SponsorSegments []SponsorSegment `json:"sponsor_segments,omitempty"`
+	CutStart        float64          `json:"cut_start,omitempty"` // source seconds the clip really starts at
+	CutEnd          float64          `json:"cut_end,omitempty"`
+	OutputFPS       float64          `json:"output_fps,omitempty"` // animated outputs, after fitting the size limit
+	Loudness        *Loudness        `json:"loudness,omitempty"`   // normalized outputs only
+}
+
+// Loudness is the EBU R128 measurement of a normalized output, in LUFS, dBTP and LU
+type Loudness struct {
+	Target    float64 `json:"target_lufs"`
+	InputI    float64 `json:"input_i"`
+	InputTP   float64 `json:"input_tp"`
+	InputLRA  float64 `json:"input_lra"`
+	OutputI   float64 `json:"output_i"`
+	OutputTP  float64 `json:"output_tp"`
+	OutputLRA float64 `json:"output_lra"`
+}
+
+// StoryboardOptions are the frame interval and sprite layout a storyboard job was started with
+type StoryboardOptions struct {
+	Interval float64 `json:"interval"` // seconds between frames
+	Width    int     `json:"width"`    // of one frame
+	Columns  int     `json:"columns"`  // frames per sprite sheet row
+}
+
+// StoryboardResult is what a finished storyboard job reports back on its row
+type StoryboardResult struct {
+	VideoID    string   `json:"video_id"`
+	OutputDir  string   `json:"output_dir"` // holds the sprites and the WebVTT track
+	Sprites    []string `json:"sprites"`
+	FrameCount int      `json:"frame_count"`
+	TileWidth  int      `json:"tile_width"`
+	TileHeight int      `json:"tile_height"`
+	Cached     bool     `json:"cached"`
+}
+
+// JobProgress is the live progress of a running job, percent and bytes are for the current stream
+type JobProgress struct {
+	Phase           string  `json:"phase"`
+	Percent         float64 `json:"progress_percent"`
+	DownloadedBytes int64   `json:"downloaded_bytes"`
+	TotalBytes      int64   `json:"total_bytes"`
+	Speed           float64 `json:"speed_bps"`
+	ETA             int     `json:"eta_seconds"` // -1 when unknown
+}
+
+// QueuedJob is a job in the job queue: a full video or time range download, a playlist child, a
+// clip job or a storyboard. Payload is the request as the service needs it to rebuild the job's
+// options once a worker claims it.
+type QueuedJob struct {
+	ID              string          `json:"id"` // the same as the job's own row
+	Kind            string          `json:"kind"`
+	URL             string          `json:"url"`
+	UserID          string          `json:"user_id"`
+	Payload         json.RawMessage `json:"payload"`
+	Status          string          `json:"status"` // pending, running, done or cancelled
+	WorkerID        string          `json:"worker_id"`
+	EnqueuedAt      time.Time       `json:"enqueued_at"`
+	StartedAt       *time.Time      `json:"started_at"`
+	HeartbeatAt     *time.Time      `json:"heartbeat_at"`
+	FinishedAt      *time.Time      `json:"finished_at"`
+	CancelRequested bool            `json:"cancel_requested"` // the worker running the job, on any server, should stop it
+	NotBefore       *time.Time      `json:"not_before"`       // no worker claims the job earlier, set for rate limited playlist children
+}
@@ -0,0 +1,283 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/supabase-community/postgrest-go"
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+)
+
+// claimCandidates is how many of the oldest pending jobs a claim tries, other servers may
+// take some of them first
+const claimCandidates = 5
+
+// queueTime formats a timestamp for the job_queue columns
+func queueTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// EnqueueJob adds a job to the queue, enqueued_at is set by the database so all servers share a clock
+func (vr *VideoRepo) EnqueueJob(job model.QueuedJob) error {
+	data := map[string]interface{}{
+		"id":      job.ID,
+		"kind":    job.Kind,
+		"url":     job.URL,
+		"payload": job.Payload,
+		"status":  "pending",
+	}
+	if job.UserID != "" {
+		data["user_id"] = job.UserID
+	}
+	if job.NotBefore != nil {
+		data["not_before"] = queueTime(*job.NotBefore)
+	}
+
+	_, _, err := vr.client.From("job_queue").Insert(data, false, "", "", "").Execute()
+	if err != nil {
+		return fmt.Errorf("insert error: %s", err.Error())
+	}
+	return nil
+}
+
+// ClaimQueuedJob hands the oldest pending job to workerID, nil when nothing is waiting. Jobs
+// held back until a later not_before are skipped. The update only matches rows that are still
+// pending, so each job goes to exactly one worker.
+func (vr *VideoRepo) ClaimQueuedJob(workerID string) (*model.QueuedJob, error) {
+	now := queueTime(time.Now())
+	resp, _, err := vr.client.From("job_queue").
+		Select("id", "", false).
+		Eq("status", "pending").
+		Or("not_before.is.null,not_before.lte."+now, "").
+		Order("enqueued_at", &postgrest.OrderOpts{Ascending: true}).
+		Limit(claimCandidates, "").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var candidates []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &candidates); err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"status":       "running",
+		"worker_id":    workerID,
+		"started_at":   now,
+		"heartbeat_at": now,
+	}
+	for _, candidate := range candidates {
+		resp, _, err := vr.client.From("job_queue").
+			Update(data, "", "").
+			Eq("id", candidate.ID).
+			Eq("status", "pending").
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("update error: %w", err)
+		}
+		var claimed []model.QueuedJob
+		if err := json.Unmarshal(resp, &claimed); err != nil {
+			return nil, err
+		}
+		if len(claimed) == 1 {
+			return &claimed[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// HeartbeatQueuedJobs marks the jobs workerID is running as alive and returns them. A job
+// workerID claimed but that is not among them was queued again and belongs to another worker.
+func (vr *VideoRepo) HeartbeatQueuedJobs(workerID string) ([]model.QueuedJob, error) {
+	resp, _, err := vr.client.From("job_queue").
+		Update(map[string]interface{}{"heartbeat_at": queueTime(time.Now())}, "", "").
+		Eq("worker_id", workerID).
+		Eq("status", "running").
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("update error: %w", err)
+	}
+	var held []model.QueuedJob
+	if err := json.Unmarshal(resp, &held); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+// FinishQueuedJob takes a job workerID ran off the queue. A job requeued meanwhile belongs to
+// another worker and is left alone.
+func (vr *VideoRepo) FinishQueuedJob(id, workerID string) error {
+	data := map[string]interface{}{
+		"status":      "done",
+		"finished_at": queueTime(time.Now()),
+	}
+	_, _, err := vr.client.From("job_queue").
+		Update(data, "minimal", "").
+		Eq("id", id).
+		Eq("worker_id", workerID).
+		Eq("status", "running").
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// CancelQueuedJob takes a job off the queue before any worker claimed it, reporting false when
+// it is not waiting (running, finished or never queued)
+func (vr *VideoRepo) CancelQueuedJob(id string) (bool, error) {
+	data := map[string]interface{}{
+		"status":      "cancelled",
+		"finished_at": queueTime(time.Now()),
+	}
+	resp, _, err := vr.client.From("job_queue").
+		Update(data, "", "").
+		Eq("id", id).
+		Eq("status", "pending").
+		Execute()
+	if err != nil {
+		return false, fmt.Errorf("update error: %w", err)
+	}
+	var cancelled []model.QueuedJob
+	if err := json.Unmarshal(resp, &cancelled); err != nil {
+		return false, err
+	}
+	return len(cancelled) == 1, nil
+}
+
+// UpdateQueuedJobPayload replaces the payload of a job, for what a worker must remember should
+// the job be queued again
+func (vr *VideoRepo) UpdateQueuedJobPayload(id string, payload json.RawMessage) error {
+	_, _, err := vr.client.From("job_queue").
+		Update(map[string]interface{}{"payload": payload}, "minimal", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// RequestQueuedJobCancel flags a running job for its worker to stop, nil when the job is not
+// running (waiting, finished or never queued)
+func (vr *VideoRepo) RequestQueuedJobCancel(id string) (*model.QueuedJob, error) {
+	resp, _, err := vr.client.From("job_queue").
+		Update(map[string]interface{}{"cancel_requested": true}, "", "").
+		Eq("id", id).
+		Eq("status", "running").
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("update error: %w", err)
+	}
+	var flagged []model.QueuedJob
+	if err := json.Unmarshal(resp, &flagged); err != nil {
+		return nil, err
+	}
+	if len(flagged) == 0 {
+		return nil, nil
+	}
+	return &flagged[0], nil
+}
+
+// RequeueStaleJobs puts running jobs whose last heartbeat is older than before back in the
+// queue, their server stopped without finishing them. They keep their place in line.
+func (vr *VideoRepo) RequeueStaleJobs(before time.Time) ([]model.QueuedJob, error) {
+	data := map[string]interface{}{
+		"status":       "pending",
+		"worker_id":    nil,
+		"started_at":   nil,
+		"heartbeat_at": nil,
+	}
+	resp, _, err := vr.client.From("job_queue").
+		Update(data, "", "").
+		Eq("status", "running").
+		Lt("heartbeat_at", queueTime(before)).
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("update error: %w", err)
+	}
+	var requeued []model.QueuedJob
+	if err := json.Unmarshal(resp, &requeued); err != nil {
+		return nil, err
+	}
+	return requeued, nil
+}
+
+// PurgeFinishedJobs deletes the entries of jobs that were done or cancelled before before,
+// returning how many it deleted
+func (vr *VideoRepo) PurgeFinishedJobs(before time.Time) (int, error) {
+	_, count, err := vr.client.From("job_queue").
+		Delete("minimal", "exact").
+		In("status", []string{"done", "cancelled"}).
+		Lt("finished_at", queueTime(before)).
+		Execute()
+	if err != nil {
+		return 0, fmt.Errorf("delete error: %s", err.Error())
+	}
+	return int(count), nil
+}
+
+// GetQueuedJob returns the queue entry of a job
+func (vr *VideoRepo) GetQueuedJob(id string) (*model.QueuedJob, error) {
+	resp, _, err := vr.client.From("job_queue").
+		Select("*", "", false).
+		Eq("id", id).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var job model.QueuedJob
+	if err := json.Unmarshal(resp, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CountQueuedJobsBefore counts the pending jobs enqueued before t, the ones ahead in line
+func (vr *VideoRepo) CountQueuedJobsBefore(t time.Time) (int, error) {
+	_, count, err := vr.client.From("job_queue").
+		Select("id", "exact", true).
+		Eq("status", "pending").
+		Lt("enqueued_at", queueTime(t)).
+		Execute()
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// CountRunningJobs counts the jobs workers of every server are running
+func (vr *VideoRepo) CountRunningJobs() (int, error) {
+	_, count, err := vr.client.From("job_queue").
+		Select("id", "exact", true).
+		Eq("status", "running").
+		Execute()
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// RecentFinishedJobs returns the last limit jobs that ran to the end, newest first
+func (vr *VideoRepo) RecentFinishedJobs(limit int) ([]model.QueuedJob, error) {
+	resp, _, err := vr.client.From("job_queue").
+		Select("id,kind,started_at,finished_at", "", false).
+		Eq("status", "done").
+		Order("finished_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []model.QueuedJob
+	if err := json.Unmarshal(resp, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
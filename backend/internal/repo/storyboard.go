@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+)
+
+// CreateStoryboardRequest stores a new storyboard job
+func (vr *VideoRepo) CreateStoryboardRequest(id, videoURL, userID string, opts model.StoryboardOptions) error {
+	data := map[string]interface{}{
+		"id":             id,
+		"url":            videoURL,
+		"status":         "pending",
+		"frame_interval": opts.Interval,
+		"frame_width":    opts.Width,
+		"sheet_columns":  opts.Columns,
+	}
+	if userID != "" {
+		data["user_id"] = userID
+	}
+
+	_, _, err := vr.client.From("storyboards").Insert(data, false, "", "", "").Execute()
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("storyboard already exists")
+		}
+		return fmt.Errorf("insert error: %s", err.Error())
+	}
+	return nil
+}
+
+func (vr *VideoRepo) UpdateStoryboardStatus(id, status, message string) error {
+	data := map[string]interface{}{
+		"status":  status,
+		"message": message,
+	}
+	_, _, err := vr.client.From("storyboards").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// MarkStoryboardFailed fails a storyboard job with a machine readable reason code
+func (vr *VideoRepo) MarkStoryboardFailed(id, errorCode, message string) error {
+	data := map[string]interface{}{
+		"status":     "failed",
+		"error_code": errorCode,
+		"message":    message,
+	}
+	_, _, err := vr.client.From("storyboards").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// SaveStoryboardResult stores where a finished storyboard's files are and how they are laid out
+func (vr *VideoRepo) SaveStoryboardResult(id string, result model.StoryboardResult) error {
+	data := map[string]interface{}{
+		"video_id":    result.VideoID,
+		"output_dir":  result.OutputDir,
+		"sprites":     result.Sprites,
+		"frame_count": result.FrameCount,
+		"tile_width":  result.TileWidth,
+		"tile_height": result.TileHeight,
+		"cached":      result.Cached,
+	}
+	_, _, err := vr.client.From("storyboards").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+func (vr *VideoRepo) GetStoryboard(id string) (map[string]interface{}, error) {
+	resp, _, err := vr.client.From("storyboards").
+		Select("*", "", false).
+		Eq("id", id).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/supabase-community/supabase-go"
+	"github.com/verse91/ytb-clipy/backend/internal/model"
 )
 
 type VideoRepo struct {
@@ -22,16 +23,128 @@ func NewVideoRepo(supaClient *supabase.Client) *VideoRepo {
 	}
 }
 
-func (vr *VideoRepo) CreateDownloadRequest(id, videoURL string) error {
+// setOptionColumns copies job options onto the columns shared by both download tables
+func setOptionColumns(data map[string]interface{}, opts model.JobOptions) {
+	data["quality"] = opts.Quality
+	data["audio_mode"] = opts.AudioMode
+	if opts.AudioCodec != "" {
+		data["audio_codec"] = opts.AudioCodec
+	}
+	if opts.AudioBitrate > 0 {
+		data["audio_bitrate"] = opts.AudioBitrate
+	}
+	data["sponsorblock"] = opts.SponsorBlock
+	if len(opts.SponsorBlockCategories) > 0 {
+		data["sponsorblock_categories"] = opts.SponsorBlockCategories
+	}
+	data["subtitles"] = opts.Subtitles
+	if len(opts.SubtitleLanguages) > 0 {
+		data["subtitle_languages"] = opts.SubtitleLanguages
+		data["subtitle_auto"] = opts.SubtitleAuto
+		data["subtitle_format"] = opts.SubtitleFormat
+	}
+	data["thumbnail"] = opts.Thumbnail
+	if opts.CutPrecision != "" {
+		data["cut_precision"] = opts.CutPrecision
+	}
+	if opts.OutputFormat != "" {
+		data["output_format"] = opts.OutputFormat
+		data["animation_width"] = opts.AnimationWidth
+		data["animation_fps"] = opts.AnimationFPS
+		data["animation_loop"] = opts.AnimationLoop
+		data["animation_single_pass"] = opts.AnimationSinglePass
+		if opts.AnimationMaxBytes > 0 {
+			data["animation_max_bytes"] = opts.AnimationMaxBytes
+		}
+	}
+	if opts.WatermarkID != "" {
+		data["watermark_id"] = opts.WatermarkID
+	}
+	if opts.Container != "" {
+		data["container"] = opts.Container
+		if opts.VideoCodec != "" {
+			data["video_codec"] = opts.VideoCodec
+			data["crf"] = opts.CRF
+			data["encoder_preset"] = opts.EncoderPreset
+		}
+	}
+	if opts.Normalize {
+		data["normalize"] = true
+		data["target_lufs"] = opts.TargetLUFS
+	}
+	if opts.FadeIn > 0 {
+		data["fade_in"] = opts.FadeIn
+	}
+	if opts.FadeOut > 0 {
+		data["fade_out"] = opts.FadeOut
+	}
+	if opts.Stereo {
+		data["stereo"] = true
+	}
+	if opts.Reframe != "" {
+		data["reframe"] = opts.Reframe
+		if opts.ReframeCropX != nil {
+			data["reframe_crop_x"] = *opts.ReframeCropX
+		}
+	}
+}
+
+// resultColumns maps a finished job's result onto its row
+func resultColumns(result model.JobResult) map[string]interface{} {
+	data := map[string]interface{}{}
+	if result.OutputFile != "" {
+		data["output_file"] = result.OutputFile
+		data["output_name"] = result.OutputName
+		data["output_size"] = result.OutputSize
+		data["output_container"] = result.Container
+	}
+	if result.OutputWidth > 0 && result.OutputHeight > 0 {
+		data["output_width"] = result.OutputWidth
+		data["output_height"] = result.OutputHeight
+	}
+	if result.VideoCodec != "" {
+		data["output_video_codec"] = result.VideoCodec
+	}
+	if result.AudioCodec != "" {
+		data["output_audio_codec"] = result.AudioCodec
+	}
+	if result.SourceFormat != "" {
+		data["source_format"] = result.SourceFormat
+	}
+	if result.SourceHeight > 0 {
+		data["source_height"] = result.SourceHeight
+	}
+	if result.SponsorSegments != nil {
+		data["sponsor_segments"] = result.SponsorSegments
+	}
+	if result.FinalDuration > 0 {
+		data["final_duration"] = result.FinalDuration
+	}
+	if result.CutEnd > 0 {
+		data["cut_start"] = result.CutStart
+		data["cut_end"] = result.CutEnd
+	}
+	if result.OutputFPS > 0 {
+		data["output_fps"] = result.OutputFPS
+	}
+	if result.Loudness != nil {
+		data["loudness"] = result.Loudness
+	}
+	return data
+}
+
+func (vr *VideoRepo) CreateDownloadRequest(id, videoURL, userID string, opts model.JobOptions) error {
 	data := map[string]interface{}{
+		"id":     id,
 		"url":    videoURL,
-		"status": "processing",
+		"status": "pending", // until a queue worker claims it
 	}
+	if userID != "" {
+		data["user_id"] = userID
+	}
+	setOptionColumns(data, opts)
 
-	// Debug logging
-	fmt.Printf("Inserting data: %+v\n", data)
-
-	result, count, err := vr.client.From("downloads").Insert(data, false, "", "", "").Execute()
+	_, _, err := vr.client.From("downloads").Insert(data, false, "", "", "").Execute()
 	if err != nil {
 		// Detailed error logging
 		// fmt.Printf("CreateDownloadRequest detailed error: %+v\n", err)
@@ -43,8 +156,6 @@ func (vr *VideoRepo) CreateDownloadRequest(id, videoURL string) error {
 		}
 		return fmt.Errorf("insert error: %s", err.Error())
 	}
-
-	fmt.Printf("Insert successful - Result: %s, Count: %d\n", string(result), count)
 	return nil
 }
 
@@ -64,28 +175,100 @@ func (vr *VideoRepo) UpdateDownloadStatus(id, status, message string) error {
 	return nil
 }
 
-func (vr *VideoRepo) GetStatus(id string) (string, error) {
+// MarkDownloadFailed fails a full video download with a machine readable reason code
+func (vr *VideoRepo) MarkDownloadFailed(id, errorCode, message string) error {
+	data := map[string]interface{}{
+		"status":     "failed",
+		"error_code": errorCode,
+		"message":    message,
+	}
+	_, _, err := vr.client.From("downloads").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// progressColumns maps live progress onto the columns shared by both download tables
+func progressColumns(progress model.JobProgress) map[string]interface{} {
+	data := map[string]interface{}{
+		"phase":            progress.Phase,
+		"progress_percent": progress.Percent,
+		"downloaded_bytes": progress.DownloadedBytes,
+		"total_bytes":      progress.TotalBytes,
+		"speed_bps":        progress.Speed,
+		"eta_seconds":      nil,
+	}
+	if progress.ETA >= 0 {
+		data["eta_seconds"] = progress.ETA
+	}
+	return data
+}
+
+// UpdateDownloadProgress stores the latest progress of a running full video download
+func (vr *VideoRepo) UpdateDownloadProgress(id string, progress model.JobProgress) error {
+	_, _, err := vr.client.From("downloads").
+		Update(progressColumns(progress), "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// UpdateTimeRangeDownloadProgress stores the latest progress of a running time range download
+func (vr *VideoRepo) UpdateTimeRangeDownloadProgress(id string, progress model.JobProgress) error {
+	_, _, err := vr.client.From("time_range_downloads").
+		Update(progressColumns(progress), "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// SaveDownloadResult stores what a finished full video download produced
+func (vr *VideoRepo) SaveDownloadResult(id string, result model.JobResult) error {
+	data := resultColumns(result)
+	if len(data) == 0 {
+		return nil
+	}
+	_, _, err := vr.client.From("downloads").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+func (vr *VideoRepo) GetStatus(id string) (map[string]interface{}, error) {
 	resp, _, err := vr.client.From("downloads").
-		Select("status", "", false).
+		Select("*", "", false).
 		Eq("id", id).
 		Single().
 		Execute()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	var result map[string]interface{}
 	if err := json.Unmarshal(resp, &result); err != nil {
-		return "", err
+		return nil, err
 	}
-	status, ok := result["status"].(string)
-	if !ok {
-		return "", fmt.Errorf("status not found or not a string")
+	if _, ok := result["status"].(string); !ok {
+		return nil, fmt.Errorf("status not found or not a string")
 	}
-	return status, nil
+	return result, nil
 }
 
 // Time Range Download Methods
-func (vr *VideoRepo) CreateTimeRangeDownloadRequest(id, videoURL string, startTime, endTime int) error {
+func (vr *VideoRepo) CreateTimeRangeDownloadRequest(id, videoURL, userID string, startTime, endTime float64, opts model.JobOptions) error {
 	// Validate time range parameters
 	if startTime < 0 || endTime < 0 {
 		return fmt.Errorf("start time and end time must be non-negative")
@@ -95,11 +278,16 @@ func (vr *VideoRepo) CreateTimeRangeDownloadRequest(id, videoURL string, startTi
 	}
 
 	data := map[string]interface{}{
+		"id":         id,
 		"url":        videoURL,
 		"start_time": startTime,
 		"end_time":   endTime,
-		"status":     "processing",
+		"status":     "pending", // until a queue worker claims it
 	}
+	if userID != "" {
+		data["user_id"] = userID
+	}
+	setOptionColumns(data, opts)
 
 	_, _, err := vr.client.From("time_range_downloads").Insert(data, false, "", "", "").Execute()
 	if err != nil {
@@ -131,6 +319,39 @@ func (vr *VideoRepo) UpdateTimeRangeDownloadStatus(id, status, message, outputFi
 	return nil
 }
 
+// MarkTimeRangeDownloadFailed fails a time range download with a machine readable reason code
+func (vr *VideoRepo) MarkTimeRangeDownloadFailed(id, errorCode, message string) error {
+	data := map[string]interface{}{
+		"status":     "failed",
+		"error_code": errorCode,
+		"message":    message,
+	}
+	_, _, err := vr.client.From("time_range_downloads").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// SaveTimeRangeDownloadResult stores what a finished time range download produced
+func (vr *VideoRepo) SaveTimeRangeDownloadResult(id string, result model.JobResult) error {
+	data := resultColumns(result)
+	if len(data) == 0 {
+		return nil
+	}
+	_, _, err := vr.client.From("time_range_downloads").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
 func (vr *VideoRepo) GetTimeRangeDownloadStatus(id string) (map[string]interface{}, error) {
 	resp, _, err := vr.client.From("time_range_downloads").
 		Select("*", "", false).
@@ -148,3 +369,211 @@ func (vr *VideoRepo) GetTimeRangeDownloadStatus(id string) (map[string]interface
 
 	return result, nil
 }
+
+// Playlist Download Methods
+func (vr *VideoRepo) CreatePlaylistRequest(id, playlistURL, userID, title string, entryCount int) error {
+	data := map[string]interface{}{
+		"id":          id,
+		"url":         playlistURL,
+		"user_id":     userID,
+		"title":       title,
+		"entry_count": entryCount,
+	}
+
+	_, _, err := vr.client.From("playlist_downloads").Insert(data, false, "", "", "").Execute()
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("playlist download already exists")
+		}
+		return fmt.Errorf("insert error: %s", err.Error())
+	}
+	return nil
+}
+
+// CreatePlaylistEntryRequest stores a playlist child as a pending full video download
+func (vr *VideoRepo) CreatePlaylistEntryRequest(id, videoURL, userID, playlistID string, index int, opts model.JobOptions) error {
+	data := map[string]interface{}{
+		"id":             id,
+		"url":            videoURL,
+		"status":         "pending",
+		"user_id":        userID,
+		"playlist_id":    playlistID,
+		"playlist_index": index,
+	}
+	setOptionColumns(data, opts)
+
+	_, _, err := vr.client.From("downloads").Insert(data, false, "", "", "").Execute()
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("download already exists")
+		}
+		return fmt.Errorf("insert error: %s", err.Error())
+	}
+	return nil
+}
+
+func (vr *VideoRepo) GetPlaylist(id string) (map[string]interface{}, error) {
+	resp, _, err := vr.client.From("playlist_downloads").
+		Select("*", "", false).
+		Eq("id", id).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetPlaylistEntries returns the download rows of a playlist's children
+func (vr *VideoRepo) GetPlaylistEntries(playlistID string) ([]map[string]interface{}, error) {
+	resp, _, err := vr.client.From("downloads").
+		Select("*", "", false).
+		Eq("playlist_id", playlistID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]interface{}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Clip Download Methods
+func (vr *VideoRepo) CreateClipRequest(id, videoURL, userID, output string, segmentCount int) error {
+	data := map[string]interface{}{
+		"id":            id,
+		"url":           videoURL,
+		"status":        "pending",
+		"output_mode":   output,
+		"segment_count": segmentCount,
+	}
+	if userID != "" {
+		data["user_id"] = userID
+	}
+
+	_, _, err := vr.client.From("clip_downloads").Insert(data, false, "", "", "").Execute()
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("clip download already exists")
+		}
+		return fmt.Errorf("insert error: %s", err.Error())
+	}
+	return nil
+}
+
+// CreateClipSegmentRequest stores a clip segment as a pending time range download
+func (vr *VideoRepo) CreateClipSegmentRequest(id, videoURL, userID, clipJobID string, index int, name string, startTime, endTime int, opts model.JobOptions) error {
+	data := map[string]interface{}{
+		"id":            id,
+		"url":           videoURL,
+		"start_time":    startTime,
+		"end_time":      endTime,
+		"status":        "pending",
+		"clip_job_id":   clipJobID,
+		"segment_index": index,
+		"segment_name":  name,
+	}
+	if userID != "" {
+		data["user_id"] = userID
+	}
+	setOptionColumns(data, opts)
+
+	_, _, err := vr.client.From("time_range_downloads").Insert(data, false, "", "", "").Execute()
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("time range download already exists")
+		}
+		return fmt.Errorf("insert error: %s", err.Error())
+	}
+	return nil
+}
+
+func (vr *VideoRepo) UpdateClipStatus(id, status, message string) error {
+	data := map[string]interface{}{
+		"status":  status,
+		"message": message,
+	}
+	_, _, err := vr.client.From("clip_downloads").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// MarkClipFailed fails a clip job with a machine readable reason code
+func (vr *VideoRepo) MarkClipFailed(id, errorCode, message string) error {
+	data := map[string]interface{}{
+		"status":     "failed",
+		"error_code": errorCode,
+		"message":    message,
+	}
+	_, _, err := vr.client.From("clip_downloads").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+// SaveClipResult stores the joined file of a finished concat clip job
+func (vr *VideoRepo) SaveClipResult(id string, result model.JobResult) error {
+	data := resultColumns(result)
+	if len(data) == 0 {
+		return nil
+	}
+	_, _, err := vr.client.From("clip_downloads").
+		Update(data, "", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	return nil
+}
+
+func (vr *VideoRepo) GetClip(id string) (map[string]interface{}, error) {
+	resp, _, err := vr.client.From("clip_downloads").
+		Select("*", "", false).
+		Eq("id", id).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetClipSegments returns the time range download rows of a clip job's segments
+func (vr *VideoRepo) GetClipSegments(clipJobID string) ([]map[string]interface{}, error) {
+	resp, _, err := vr.client.From("time_range_downloads").
+		Select("*", "", false).
+		Eq("clip_job_id", clipJobID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]interface{}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+)
+
+// CreateWatermark stores a user's watermark
+func (vr *VideoRepo) CreateWatermark(wm model.Watermark) error {
+	data := map[string]interface{}{
+		"id":       wm.ID,
+		"user_id":  wm.UserID,
+		"name":     wm.Name,
+		"kind":     wm.Kind,
+		"position": wm.Position,
+		"margin":   wm.Margin,
+		"opacity":  wm.Opacity,
+		"scale":    wm.Scale,
+	}
+	if wm.Text != "" {
+		data["text"] = wm.Text
+	}
+	if wm.ImageFile != "" {
+		data["image_file"] = wm.ImageFile
+	}
+
+	_, _, err := vr.client.From("watermarks").Insert(data, false, "", "", "").Execute()
+	if err != nil {
+		return fmt.Errorf("insert error: %s", err.Error())
+	}
+	return nil
+}
+
+// GetWatermark returns the watermark stored under id
+func (vr *VideoRepo) GetWatermark(id string) (*model.Watermark, error) {
+	resp, _, err := vr.client.From("watermarks").
+		Select("*", "", false).
+		Eq("id", id).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var wm model.Watermark
+	if err := json.Unmarshal(resp, &wm); err != nil {
+		return nil, err
+	}
+	return &wm, nil
+}
+
+// ListWatermarks returns the watermarks a user stored
+func (vr *VideoRepo) ListWatermarks(userID string) ([]model.Watermark, error) {
+	resp, _, err := vr.client.From("watermarks").
+		Select("*", "", false).
+		Eq("user_id", userID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []model.Watermark
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CountUnfinishedWatermarkJobs counts the downloads using a watermark that are still queued or running
+func (vr *VideoRepo) CountUnfinishedWatermarkJobs(id string) (int, error) {
+	total := 0
+	for _, table := range []string{"downloads", "time_range_downloads"} {
+		_, count, err := vr.client.From(table).
+			Select("id", "exact", true).
+			Eq("watermark_id", id).
+			In("status", []string{"pending", "processing"}).
+			Execute()
+		if err != nil {
+			return 0, err
+		}
+		total += int(count)
+	}
+	return total, nil
+}
+
+// DeleteWatermark removes a watermark, finished jobs that used it keep their rows without the reference
+func (vr *VideoRepo) DeleteWatermark(id string) error {
+	_, _, err := vr.client.From("watermarks").Delete("", "").Eq("id", id).Execute()
+	if err != nil {
+		return fmt.Errorf("delete error: %s", err.Error())
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package router
 
 import (
+	"context"
+
 	"github.com/gofiber/fiber/v3"
 	"github.com/supabase-community/supabase-go"
 	"github.com/verse91/ytb-clipy/backend/internal/config"
@@ -8,10 +10,18 @@ import (
 	"github.com/verse91/ytb-clipy/backend/internal/middleware"
 )
 
-func SetupRoutes(router fiber.Router, supabaseClient *supabase.Client, config *config.Config) {
+// SetupRoutes registers the API routes and starts the download queue workers, which run until ctx is
+// cancelled. The returned channel is closed once the workers stopped and their running jobs finished.
+func SetupRoutes(ctx context.Context, router fiber.Router, supabaseClient *supabase.Client, config *config.Config) <-chan struct{} {
 	userController := controller.NewUserController(supabaseClient, config)
 	videoController := controller.NewVideoController(supabaseClient)
 
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		videoController.VideoService.RunQueue(ctx)
+	}()
+
 	router.Get("/", homepageHandler)
 
 	router.Get("/user/:userID/credits", middleware.UserAuthMiddleware, func(c fiber.Ctx) error {
@@ -26,7 +36,7 @@ func SetupRoutes(router fiber.Router, supabaseClient *supabase.Client, config *c
 		return userController.AddUserCredits(c)
 	})
 
-	router.Post("/video/download", func(c fiber.Ctx) error {
+	router.Post("/video/download", middleware.OptionalUserMiddleware, func(c fiber.Ctx) error {
 		return videoController.DownloadHandler(c)
 	})
 
@@ -34,7 +44,63 @@ func SetupRoutes(router fiber.Router, supabaseClient *supabase.Client, config *c
 		return videoController.GetDownloadStatus(c)
 	})
 
-	router.Post("/video/download/time-range", func(c fiber.Ctx) error {
+	router.Delete("/video/download/:id", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.CancelDownloadHandler(c)
+	})
+
+	router.Get("/video/download/:id/file", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.DownloadFileHandler(c)
+	})
+
+	router.Get("/video/download/:id/snapshot", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.DownloadSnapshotHandler(c)
+	})
+
+	router.Get("/video/download/:id/waveform", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.DownloadWaveformHandler(c)
+	})
+
+	router.Post("/video/playlist", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.DownloadPlaylistHandler(c)
+	})
+
+	router.Get("/video/playlist/:id", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.GetPlaylistStatusHandler(c)
+	})
+
+	router.Delete("/video/playlist/:id", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.CancelPlaylistHandler(c)
+	})
+
+	router.Post("/video/probe", func(c fiber.Ctx) error {
+		return videoController.ProbeHandler(c)
+	})
+
+	router.Get("/video/snapshot", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.SourceSnapshotHandler(c)
+	})
+
+	router.Get("/video/waveform", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.SourceWaveformHandler(c)
+	})
+
+	router.Post("/video/storyboard", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.CreateStoryboardHandler(c)
+	})
+
+	router.Get("/video/storyboard/:id", func(c fiber.Ctx) error {
+		return videoController.GetStoryboardStatusHandler(c)
+	})
+
+	router.Delete("/video/storyboard/:id", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.CancelStoryboardHandler(c)
+	})
+
+	router.Get("/video/storyboard/:id/:file", func(c fiber.Ctx) error {
+		return videoController.StoryboardFileHandler(c)
+	})
+
+	router.Post("/video/download/time-range", middleware.OptionalUserMiddleware, func(c fiber.Ctx) error {
 		return videoController.DownloadTimeRangeHandler(c)
 	})
 
@@ -42,6 +108,50 @@ func SetupRoutes(router fiber.Router, supabaseClient *supabase.Client, config *c
 		return videoController.GetTimeRangeDownloadStatusHandler(c)
 	})
 
+	router.Delete("/video/download/time-range/:id", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.CancelTimeRangeDownloadHandler(c)
+	})
+
+	router.Get("/video/download/time-range/:id/file", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.TimeRangeDownloadFileHandler(c)
+	})
+
+	router.Get("/video/download/time-range/:id/snapshot", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.TimeRangeSnapshotHandler(c)
+	})
+
+	router.Get("/video/download/time-range/:id/waveform", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.TimeRangeWaveformHandler(c)
+	})
+
+	router.Post("/video/download/clips", middleware.OptionalUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.DownloadClipsHandler(c)
+	})
+
+	router.Get("/video/download/clips/:id", func(c fiber.Ctx) error {
+		return videoController.GetClipStatusHandler(c)
+	})
+
+	router.Delete("/video/download/clips/:id", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.CancelClipsHandler(c)
+	})
+
+	router.Get("/video/download/clips/:id/file", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.ClipFileHandler(c)
+	})
+
+	router.Post("/video/watermarks", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.CreateWatermarkHandler(c)
+	})
+
+	router.Get("/video/watermarks", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.ListWatermarksHandler(c)
+	})
+
+	router.Delete("/video/watermarks/:id", middleware.RequireUserMiddleware, func(c fiber.Ctx) error {
+		return videoController.DeleteWatermarkHandler(c)
+	})
+
 	router.Get("/user/info", func(c fiber.Ctx) error {
 		return userController.GetUserById(c)
 	})
@@ -49,6 +159,8 @@ func SetupRoutes(router fiber.Router, supabaseClient *supabase.Client, config *c
 	router.Get("/user/profile", func(c fiber.Ctx) error {
 		return userController.UserHandler(c)
 	})
+
+	return queueDone
 }
 
 func homepageHandler(c fiber.Ctx) error {
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+)
+
+// ErrNoChapters is returned when a time range is asked for by chapter but the video has none
+var ErrNoChapters = errors.New("video has no chapters")
+
+// ChapterSelector picks a chapter by its 1-based index or by a case-insensitive part of its title
+type ChapterSelector struct {
+	Index int
+	Title string
+}
+
+func (s ChapterSelector) String() string {
+	if s.Title != "" {
+		return fmt.Sprintf("%q", s.Title)
+	}
+	return fmt.Sprintf("#%d", s.Index)
+}
+
+// chapterTimeRange resolves chapter selectors against the probed chapters. Several chapters
+// make one range from the start of the first to the end of the last, so they must follow each
+// other without a gap.
+func chapterTimeRange(info *downloader.VideoInfo, input TimeRangeInput) (TimeRange, []downloader.Chapter, error) {
+	var rng TimeRange
+	if strings.TrimSpace(input.Start) != "" || strings.TrimSpace(input.End) != "" || strings.TrimSpace(input.Duration) != "" {
+		return rng, nil, fmt.Errorf("%w: give either chapter or start_time, end_time and duration, not both", ErrInvalidTimeRange)
+	}
+	if len(info.Chapters) == 0 {
+		return rng, nil, fmt.Errorf("%w: select the range with start_time and end_time instead", ErrNoChapters)
+	}
+
+	var picked []int
+	for _, sel := range input.Chapters {
+		index, err := findChapter(info.Chapters, sel)
+		if err != nil {
+			return rng, nil, err
+		}
+		if !slices.Contains(picked, index) {
+			picked = append(picked, index)
+		}
+	}
+	slices.Sort(picked)
+	for i := 1; i < len(picked); i++ {
+		if picked[i] != picked[i-1]+1 {
+			return rng, nil, fmt.Errorf("%w: chapters %d and %d are not adjacent, pick the chapters between them too or cut them as separate clips", ErrInvalidTimeRange, picked[i-1]+1, picked[i]+1)
+		}
+	}
+
+	first, last := info.Chapters[picked[0]], info.Chapters[picked[len(picked)-1]]
+	end := last.End
+	if info.Duration > 0 {
+		// The last chapter may end a rounding error past the video
+		end = math.Min(end, info.Duration)
+	}
+	rng = TimeRange{StartMs: int64(math.Round(first.Start * 1000)), EndMs: int64(math.Round(end * 1000))}
+	if err := rng.validate(); err != nil {
+		return rng, nil, err
+	}
+
+	chapters := make([]downloader.Chapter, len(picked))
+	for i, index := range picked {
+		chapters[i] = info.Chapters[index]
+	}
+	return rng, chapters, nil
+}
+
+// findChapter returns the position of the one chapter sel matches. A title matching a chapter
+// exactly wins over titles that only contain it.
+func findChapter(chapters []downloader.Chapter, sel ChapterSelector) (int, error) {
+	if sel.Title == "" {
+		if sel.Index < 1 || sel.Index > len(chapters) {
+			return 0, fmt.Errorf("%w: chapter %d does not exist, the video has %d chapters", ErrInvalidTimeRange, sel.Index, len(chapters))
+		}
+		return sel.Index - 1, nil
+	}
+
+	query := strings.ToLower(strings.TrimSpace(sel.Title))
+	var matches []int
+	for i, ch := range chapters {
+		title := strings.ToLower(strings.TrimSpace(ch.Title))
+		if title == query {
+			return i, nil
+		}
+		if strings.Contains(title, query) {
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("%w: no chapter title contains %s", ErrInvalidTimeRange, sel)
+	case 1:
+		return matches[0], nil
+	}
+	titles := make([]string, len(matches))
+	for i, index := range matches {
+		titles[i] = fmt.Sprintf("%d %q", index+1, chapters[index].Title)
+	}
+	return 0, fmt.Errorf("%w: %s matches %d chapters (%s), use a longer title or the index", ErrInvalidTimeRange, sel, len(matches), strings.Join(titles, ", "))
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+)
+
+func TestChapterTimeRange(t *testing.T) {
+	chapters := []downloader.Chapter{
+		{Title: "Intro", Start: 0, End: 30.5},
+		{Title: "Setup", Start: 30.5, End: 120},
+		{Title: "Setup part two", Start: 120, End: 300},
+		{Title: "Demo", Start: 300, End: 900},
+		{Title: "Outro", Start: 900, End: 960.0004},
+	}
+	info := &downloader.VideoInfo{Duration: 960, Chapters: chapters}
+	pick := func(sels ...ChapterSelector) TimeRangeInput { return TimeRangeInput{Chapters: sels} }
+
+	tests := []struct {
+		name     string
+		info     *downloader.VideoInfo
+		input    TimeRangeInput
+		want     TimeRange
+		chapters []string // titles of the chapters the range was selected by
+		wantErr  error
+	}{
+		{
+			name:     "by index",
+			input:    pick(ChapterSelector{Index: 2}),
+			want:     TimeRange{StartMs: 30500, EndMs: 120000},
+			chapters: []string{"Setup"},
+		},
+		{
+			name:     "exact title wins over longer titles",
+			input:    pick(ChapterSelector{Title: " setup "}),
+			want:     TimeRange{StartMs: 30500, EndMs: 120000},
+			chapters: []string{"Setup"},
+		},
+		{
+			name:     "unique part of a title",
+			input:    pick(ChapterSelector{Title: "DEM"}),
+			want:     TimeRange{StartMs: 300000, EndMs: 900000},
+			chapters: []string{"Demo"},
+		},
+		{
+			name:     "adjacent chapters in any order",
+			input:    pick(ChapterSelector{Index: 4}, ChapterSelector{Title: "part two"}, ChapterSelector{Index: 2}),
+			want:     TimeRange{StartMs: 30500, EndMs: 900000},
+			chapters: []string{"Setup", "Setup part two", "Demo"},
+		},
+		{
+			name:     "repeated chapter counts once",
+			input:    pick(ChapterSelector{Index: 1}, ChapterSelector{Title: "intro"}),
+			want:     TimeRange{StartMs: 0, EndMs: 30500},
+			chapters: []string{"Intro"},
+		},
+		{
+			name:     "last chapter is clamped to the video",
+			input:    pick(ChapterSelector{Index: 5}),
+			want:     TimeRange{StartMs: 900000, EndMs: 960000},
+			chapters: []string{"Outro"},
+		},
+		{
+			name:     "unknown duration keeps the chapter end",
+			info:     &downloader.VideoInfo{Chapters: chapters},
+			input:    pick(ChapterSelector{Index: 5}),
+			want:     TimeRange{StartMs: 900000, EndMs: 960000},
+			chapters: []string{"Outro"},
+		},
+		{name: "chapters with a gap", input: pick(ChapterSelector{Index: 1}, ChapterSelector{Index: 5}), wantErr: ErrInvalidTimeRange},
+		{name: "gap after adjacent chapters", input: pick(ChapterSelector{Index: 1}, ChapterSelector{Index: 2}, ChapterSelector{Index: 4}), wantErr: ErrInvalidTimeRange},
+		{name: "ambiguous title", input: pick(ChapterSelector{Title: "o"}), wantErr: ErrInvalidTimeRange},
+		{name: "no title matches", input: pick(ChapterSelector{Title: "credits"}), wantErr: ErrInvalidTimeRange},
+		{name: "index past the last chapter", input: pick(ChapterSelector{Index: 6}), wantErr: ErrInvalidTimeRange},
+		{name: "index below one", input: pick(ChapterSelector{Index: 0}), wantErr: ErrInvalidTimeRange},
+		{
+			name:    "chapter and start time together",
+			input:   TimeRangeInput{Start: "10", Chapters: []ChapterSelector{{Index: 1}}},
+			wantErr: ErrInvalidTimeRange,
+		},
+		{name: "video without chapters", info: &downloader.VideoInfo{Duration: 960}, input: pick(ChapterSelector{Index: 1}), wantErr: ErrNoChapters},
+		{
+			name:    "range longer than a clip may be",
+			info:    &downloader.VideoInfo{Chapters: []downloader.Chapter{{Title: "Everything", Start: 0, End: MaxClipDurationSeconds + 1}}},
+			input:   pick(ChapterSelector{Index: 1}),
+			wantErr: ErrInvalidTimeRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := tt.info
+			if in == nil {
+				in = info
+			}
+			got, picked, err := chapterTimeRange(in, tt.input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("chapterTimeRange() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("chapterTimeRange() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("chapterTimeRange() = %+v, want %+v", got, tt.want)
+			}
+			var titles []string
+			for _, ch := range picked {
+				titles = append(titles, ch.Title)
+			}
+			if !slices.Equal(titles, tt.chapters) {
+				t.Errorf("chapters = %q, want %q", titles, tt.chapters)
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+)
+
+// Clip output modes
+const (
+	ClipOutputSeparate = "separate" // one file per segment
+	ClipOutputConcat   = "concat"   // the segments joined into one file, in request order
+)
+
+const (
+	// MaxClipSegments caps how many segments one clip job may cut
+	MaxClipSegments = 20
+	// maxSegmentNameLength keeps segment names usable in file names
+	maxSegmentNameLength = 100
+)
+
+// ErrInvalidClips is returned for multi-range requests whose segments or output mode are unusable
+var ErrInvalidClips = errors.New("invalid clip request")
+
+// ClipSegment is one requested range of a clip job, in source seconds
+type ClipSegment struct {
+	Name      string
+	StartTime int
+	EndTime   int
+}
+
+// ClipJob is a started multi-range job and the child created for each segment
+type ClipJob struct {
+	ID       string           `json:"clip_job_id"`
+	Output   string           `json:"output"`
+	Segments []ClipSegmentJob `json:"segments"`
+}
+
+// ClipSegmentJob is one segment of a clip job, tracked as a time range download row
+type ClipSegmentJob struct {
+	DownloadID string `json:"download_id"`
+	Index      int    `json:"segment_index"`
+	Name       string `json:"name"`
+	StartTime  int    `json:"start_time"`
+	EndTime    int    `json:"end_time"`
+}
+
+// DownloadClips cuts several named segments from one video. The source is fetched once and the
+// segments are saved one file each, or joined into one file when output is ClipOutputConcat.
+func (vs *VideoService) DownloadClips(videoURL, userID string, segments []ClipSegment, output string, rawOpts DownloadOptions) (*ClipJob, error) {
+	validatedURL, err := vs.validateURL(videoURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid video URL: %w", err)
+	}
+
+	if output == "" {
+		output = ClipOutputSeparate
+	}
+	if output != ClipOutputSeparate && output != ClipOutputConcat {
+		return nil, fmt.Errorf("%w: output must be %q or %q", ErrInvalidClips, ClipOutputSeparate, ClipOutputConcat)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("%w: at least one segment is required", ErrInvalidClips)
+	}
+	if len(segments) > MaxClipSegments {
+		return nil, fmt.Errorf("%w: at most %d segments per job", ErrInvalidClips, MaxClipSegments)
+	}
+	for i := range segments {
+		seg := &segments[i]
+		seg.Name = strings.TrimSpace(seg.Name)
+		if seg.Name == "" {
+			seg.Name = fmt.Sprintf("Segment %d", i+1)
+		}
+		if len(seg.Name) > maxSegmentNameLength || strings.ContainsAny(seg.Name, "/\\\x00") {
+			return nil, fmt.Errorf("%w: segment %d name must be at most %d characters without slashes", ErrInvalidClips, i+1, maxSegmentNameLength)
+		}
+		if seg.StartTime < 0 || seg.EndTime <= seg.StartTime {
+			return nil, fmt.Errorf("%w: segment %q must have start_time >= 0 and end_time > start_time", ErrInvalidClips, seg.Name)
+		}
+		if seg.EndTime-seg.StartTime > MaxClipDurationSeconds {
+			return nil, fmt.Errorf("%w: segment %q cannot exceed %d seconds", ErrInvalidClips, seg.Name, MaxClipDurationSeconds)
+		}
+	}
+
+	_, record, err := vs.clipOptions(rawOpts, userID, output)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the ranges against the real video rather than failing inside yt-dlp
+	info, err := vs.ProbeVideo(validatedURL)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsLive && info.Duration > 0 {
+		for _, seg := range segments {
+			if float64(seg.EndTime) > info.Duration {
+				return nil, fmt.Errorf("%w: segment %q ends at %d but the video is %.0f seconds long", ErrEndPastDuration, seg.Name, seg.EndTime, info.Duration)
+			}
+		}
+	}
+
+	job := &ClipJob{ID: uuid.New().String(), Output: output}
+	if err := vs.VideoRepo.CreateClipRequest(job.ID, validatedURL, userID, output, len(segments)); err != nil {
+		log.Printf("DownloadClips - CreateClipRequest error: %v", err)
+		return nil, fmt.Errorf("failed to create clip request: %w", err)
+	}
+	for i, seg := range segments {
+		child := ClipSegmentJob{
+			DownloadID: uuid.New().String(),
+			Index:      i + 1,
+			Name:       seg.Name,
+			StartTime:  seg.StartTime,
+			EndTime:    seg.EndTime,
+		}
+		if err := vs.VideoRepo.CreateClipSegmentRequest(child.DownloadID, validatedURL, userID, job.ID, child.Index, seg.Name, seg.StartTime, seg.EndTime, record); err != nil {
+			log.Printf("DownloadClips - CreateClipSegmentRequest error: %v", err)
+			vs.failClips(job, err)
+			return nil, fmt.Errorf("failed to create clip segment request: %w", err)
+		}
+		job.Segments = append(job.Segments, child)
+	}
+
+	// A worker cuts the segments once one is free
+	payload := queuePayload{Options: rawOpts, Output: output, Segments: job.Segments}
+	if err := vs.enqueue(queueKindClips, job.ID, validatedURL, userID, payload); err != nil {
+		vs.failClips(job, err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// clipOptions validates the options of a clip job. It runs when the job is queued and again
+// when a worker claims it, the stored watermark may be gone by then.
+func (vs *VideoService) clipOptions(rawOpts DownloadOptions, userID, output string) (downloader.Options, model.JobOptions, error) {
+	if rawOpts.hasAnimation() {
+		return downloader.Options{}, model.JobOptions{}, fmt.Errorf("%w: animated output is not supported for multi-range jobs", ErrInvalidAnimation)
+	}
+	opts, record, err := vs.validateOptions(rawOpts)
+	if err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	if err := rejectReframe(rawOpts, opts); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	if err := vs.withWatermark(rawOpts.WatermarkID, userID, &opts, &record); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	// SponsorBlock reports segments once per download, they cannot be split between the clips
+	if opts.SponsorBlock.Enabled() {
+		return downloader.Options{}, model.JobOptions{}, fmt.Errorf("%w: sponsorblock is not supported for multi-range jobs", ErrInvalidSponsorBlock)
+	}
+	if err := withCutPrecision(rawOpts.CutPrecision, &opts, &record); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	// Accurate cuts trim a padded download, multi-range runs fetch the segments unpadded
+	if opts.Cut == downloader.CutAccurate {
+		return downloader.Options{}, model.JobOptions{}, fmt.Errorf("%w: multi-range jobs support %q and %q cuts", ErrInvalidCutPrecision, downloader.CutFast, downloader.CutReencode)
+	}
+	// Sidecar files are timed to their own segment and would not match the joined file
+	if output == ClipOutputConcat && opts.Subtitles.Mode == downloader.SubtitlesSidecar {
+		return downloader.Options{}, model.JobOptions{}, fmt.Errorf("%w: joined clips only support embedded or burned-in subtitles", ErrInvalidSubtitles)
+	}
+	opts.Timeouts = clipTimeouts()
+	return opts, record, nil
+}
+
+// runClips runs a clip job to the end and records how the job and each of its segments finished
+func (vs *VideoService) runClips(ctx context.Context, job *ClipJob, videoURL string, opts downloader.Options) {
+	defer vs.jobs.finish(job.ID)
+
+	segments := make([]downloader.Segment, len(job.Segments))
+	for i, child := range job.Segments {
+		segments[i] = downloader.Segment{Name: child.Name, Begin: child.StartTime, End: child.EndTime}
+	}
+
+	// yt-dlp works through the segments in order, progress lines say which one they are for
+	started := make([]bool, len(job.Segments))
+	onProgress := func(p downloader.Progress) {
+		if p.Section < 1 || p.Section > len(job.Segments) {
+			return
+		}
+		child := job.Segments[p.Section-1]
+		if !started[p.Section-1] {
+			started[p.Section-1] = true
+			if err := vs.VideoRepo.UpdateTimeRangeDownloadStatus(child.DownloadID, StatusProcessing, "", ""); err != nil {
+				log.Printf("DownloadClips - UpdateTimeRangeDownloadStatus error: %v", err)
+			}
+		}
+		if err := vs.VideoRepo.UpdateTimeRangeDownloadProgress(child.DownloadID, jobProgressRecord(p)); err != nil {
+			log.Printf("DownloadClips - UpdateTimeRangeDownloadProgress error: %v", err)
+		}
+	}
+	result, err := downloader.MultiRangeFHD(ctx, videoURL, job.ID, segments, job.Output == ClipOutputConcat, opts, onProgress)
+	if claimLost(ctx) {
+		// The server that took the job over cuts the segments again and records them
+		if result != nil {
+			for _, seg := range result.Segments {
+				if seg != nil {
+					os.Remove(seg.Path)
+				}
+			}
+			if result.Joined != nil {
+				os.Remove(result.Joined.Path)
+			}
+		}
+		return
+	}
+	if !errors.Is(err, context.Canceled) && !vs.jobs.seal(job.ID) {
+		// Cancelled while the last step was finishing
+		err = context.Canceled
+	}
+	if errors.Is(err, context.Canceled) {
+		vs.clipsCancelled(job, result)
+		return
+	}
+	if result == nil {
+		vs.failClips(job, err)
+		return
+	}
+
+	completed := 0
+	for i, child := range job.Segments {
+		if segErr := result.Errors[i]; segErr != nil {
+			if updateErr := vs.VideoRepo.MarkTimeRangeDownloadFailed(child.DownloadID, failureCode(segErr), segErr.Error()); updateErr != nil {
+				log.Printf("DownloadClips - MarkTimeRangeDownloadFailed error: %v", updateErr)
+			}
+			continue
+		}
+		message := result.Segments[i].Notice
+		if result.Joined != nil {
+			message = "Included in the joined clip file"
+		}
+		vs.completeClipSegment(child, result.Segments[i], message)
+		completed++
+	}
+
+	switch {
+	case result.Joined != nil:
+		if saveErr := vs.VideoRepo.SaveClipResult(job.ID, jobResultRecord(result.Joined)); saveErr != nil {
+			log.Printf("DownloadClips - SaveClipResult error: %v", saveErr)
+		}
+		if updateErr := vs.VideoRepo.UpdateClipStatus(job.ID, StatusCompleted, ""); updateErr != nil {
+			log.Printf("DownloadClips - UpdateClipStatus error: %v", updateErr)
+		}
+	case job.Output == ClipOutputConcat || completed == 0:
+		if err == nil {
+			err = fmt.Errorf("no segment could be cut")
+		}
+		if updateErr := vs.VideoRepo.MarkClipFailed(job.ID, failureCode(err), err.Error()); updateErr != nil {
+			log.Printf("DownloadClips - MarkClipFailed error: %v", updateErr)
+		}
+	case completed < len(job.Segments):
+		if updateErr := vs.VideoRepo.UpdateClipStatus(job.ID, StatusPartial, fmt.Sprintf("%d of %d segments failed", len(job.Segments)-completed, len(job.Segments))); updateErr != nil {
+			log.Printf("DownloadClips - UpdateClipStatus error: %v", updateErr)
+		}
+	default:
+		if updateErr := vs.VideoRepo.UpdateClipStatus(job.ID, StatusCompleted, ""); updateErr != nil {
+			log.Printf("DownloadClips - UpdateClipStatus error: %v", updateErr)
+		}
+	}
+}
+
+// clipsCancelled records how a cancelled clip job ended. Segments cut before the cancel are kept,
+// the job is then partial rather than cancelled.
+func (vs *VideoService) clipsCancelled(job *ClipJob, result *downloader.MultiRangeResult) {
+	if result != nil && result.Joined != nil {
+		// Only the joined file was left and the cancel came in time to drop it
+		os.Remove(result.Joined.Path)
+		result = nil
+	}
+
+	kept := 0
+	for i, child := range job.Segments {
+		var segErr error
+		if result != nil {
+			segErr = result.Errors[i]
+		}
+		switch {
+		case result != nil && result.Segments[i] != nil:
+			vs.completeClipSegment(child, result.Segments[i], "Cut before the clip job was cancelled")
+			kept++
+		case segErr != nil && !errors.Is(segErr, context.Canceled):
+			if updateErr := vs.VideoRepo.MarkTimeRangeDownloadFailed(child.DownloadID, failureCode(segErr), segErr.Error()); updateErr != nil {
+				log.Printf("DownloadClips - MarkTimeRangeDownloadFailed error: %v", updateErr)
+			}
+		default:
+			if updateErr := vs.VideoRepo.UpdateTimeRangeDownloadStatus(child.DownloadID, StatusCancelled, "Clip job cancelled", ""); updateErr != nil {
+				log.Printf("DownloadClips - UpdateTimeRangeDownloadStatus error: %v", updateErr)
+			}
+		}
+	}
+
+	status, message := StatusCancelled, "Clip job cancelled"
+	if kept > 0 {
+		status, message = StatusPartial, fmt.Sprintf("Clip job cancelled, %d of %d segments were kept", kept, len(job.Segments))
+	}
+	if updateErr := vs.VideoRepo.UpdateClipStatus(job.ID, status, message); updateErr != nil {
+		log.Printf("DownloadClips - UpdateClipStatus error: %v", updateErr)
+	}
+}
+
+// failClips fails a clip job and all of its segments
+func (vs *VideoService) failClips(job *ClipJob, err error) {
+	for _, child := range job.Segments {
+		if updateErr := vs.VideoRepo.MarkTimeRangeDownloadFailed(child.DownloadID, failureCode(err), err.Error()); updateErr != nil {
+			log.Printf("DownloadClips - MarkTimeRangeDownloadFailed error: %v", updateErr)
+		}
+	}
+	if updateErr := vs.VideoRepo.MarkClipFailed(job.ID, failureCode(err), err.Error()); updateErr != nil {
+		log.Printf("DownloadClips - MarkClipFailed error: %v", updateErr)
+	}
+}
+
+// clipSegments reads the segments of a clip job back from their rows
+func (vs *VideoService) clipSegments(clipJobID string) ([]ClipSegmentJob, error) {
+	rows, err := vs.VideoRepo.GetClipSegments(clipJobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clip segments: %w", err)
+	}
+	segments := make([]ClipSegmentJob, len(rows))
+	for i, row := range rows {
+		id, _ := row["id"].(string)
+		name, _ := row["segment_name"].(string)
+		segments[i] = ClipSegmentJob{DownloadID: id, Index: int(rowNumber(row, "segment_index")), Name: name}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Index < segments[j].Index })
+	return segments, nil
+}
+
+// completeClipSegment records the result of a segment that was cut
+func (vs *VideoService) completeClipSegment(child ClipSegmentJob, result *downloader.Result, message string) {
+	if saveErr := vs.VideoRepo.SaveTimeRangeDownloadResult(child.DownloadID, jobResultRecord(result)); saveErr != nil {
+		log.Printf("DownloadClips - SaveTimeRangeDownloadResult error: %v", saveErr)
+	}
+	if updateErr := vs.VideoRepo.UpdateTimeRangeDownloadStatus(child.DownloadID, StatusCompleted, message, result.Path); updateErr != nil {
+		log.Printf("DownloadClips - UpdateTimeRangeDownloadStatus error: %v", updateErr)
+	}
+}
+
+// GetClipStatus returns a clip job row with its segments and their aggregated progress
+func (vs *VideoService) GetClipStatus(clipJobID string) (map[string]interface{}, error) {
+	if clipJobID == "" {
+		return nil, fmt.Errorf("clip job ID cannot be empty")
+	}
+
+	status, err := vs.VideoRepo.GetClip(clipJobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clip job status: %w", err)
+	}
+	children, err := vs.VideoRepo.GetClipSegments(clipJobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clip segments: %w", err)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return rowNumber(children[i], "segment_index") < rowNumber(children[j], "segment_index")
+	})
+
+	// The job's own status is stored rather than aggregated, it also covers the join
+	summary := summarizeChildren(children, "segment_index")
+	publicStatus(status)
+	for _, child := range children {
+		publicStatus(child)
+	}
+	status["progress_percent"] = summary.percent
+	status["counts"] = summary.counts
+	status["failures"] = summary.failures
+	status["segments"] = children
+	vs.withQueueStatus(clipJobID, status)
+	return status, nil
+}
+
+// CancelClips takes a queued clip job owned by userID off the queue or stops it while it runs, on
+// whichever server runs it. Segments already cut are kept and the job ends partial, see
+// clipsCancelled.
+func (vs *VideoService) CancelClips(clipJobID, userID string) error {
+	if clipJobID == "" {
+		return fmt.Errorf("clip job ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetClip(clipJobID)
+	if err != nil {
+		return fmt.Errorf("failed to get clip job status: %w", err)
+	}
+	if err := checkOwner(row, userID); err != nil {
+		return err
+	}
+	dequeued, err := vs.cancelQueued(clipJobID)
+	if err != nil || !dequeued {
+		return err
+	}
+	segments, err := vs.clipSegments(clipJobID)
+	if err != nil {
+		log.Printf("CancelClips - clipSegments error: %v", err)
+	}
+	vs.clipsCancelled(&ClipJob{ID: clipJobID, Segments: segments}, nil)
+	return nil
+}
+
+// ClipFile returns the joined file of a completed concat clip job owned by userID.
+// Separate segments are fetched through their own time range download IDs.
+func (vs *VideoService) ClipFile(clipJobID, userID string) (OutputFile, error) {
+	if clipJobID == "" {
+		return OutputFile{}, fmt.Errorf("clip job ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetClip(clipJobID)
+	if err != nil {
+		return OutputFile{}, fmt.Errorf("failed to get clip job status: %w", err)
+	}
+	return ownedOutputFile(row, userID)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errClaimLost stops a queued job whose queue entry another server took over, that server runs
+// the job again and records how it ends
+var errClaimLost = errors.New("job was taken over by another server")
+
+// claimLost reports whether ctx belongs to a job that was stopped because it lost its queue
+// claim. Such a job must leave its rows alone.
+func claimLost(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errClaimLost)
+}
+
+// jobRegistry tracks the running jobs of this process so they can be cancelled
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*registeredJob
+}
+
+type registeredJob struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	sealed bool // the job is recording its result and can no longer be cancelled
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{jobs: make(map[string]*registeredJob)}
+}
+
+// start registers a job and returns the context its downloader must run under
+func (r *jobRegistry) start(id string) context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	r.mu.Lock()
+	r.jobs[id] = &registeredJob{ctx: ctx, cancel: cancel}
+	r.mu.Unlock()
+	return ctx
+}
+
+// finish releases a job once its goroutine is done
+func (r *jobRegistry) finish(id string) {
+	r.mu.Lock()
+	job, ok := r.jobs[id]
+	delete(r.jobs, id)
+	r.mu.Unlock()
+	if ok {
+		job.cancel(nil)
+	}
+}
+
+// cancel stops a running job, reporting false when no such job is running or it is already
+// recording its result
+func (r *jobRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	job, ok := r.jobs[id]
+	ok = ok && !job.sealed
+	r.mu.Unlock()
+	if ok {
+		job.cancel(nil)
+	}
+	return ok
+}
+
+// finishing reports whether a job is registered and already recording its result
+func (r *jobRegistry) finishing(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	return ok && job.sealed
+}
+
+// abandon stops a job that lost its queue claim, see claimLost. A job already recording its
+// result is left to finish.
+func (r *jobRegistry) abandon(id string) {
+	r.mu.Lock()
+	job, ok := r.jobs[id]
+	ok = ok && !job.sealed
+	r.mu.Unlock()
+	if ok {
+		job.cancel(errClaimLost)
+	}
+}
+
+// seal is called once a job's work is done, before its result is recorded. It reports false
+// when the job was cancelled in the meantime, the result must then be dropped; after it
+// returns true cancel no longer stops the job.
+func (r *jobRegistry) seal(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok || job.ctx.Err() != nil {
+		return false
+	}
+	job.sealed = true
+	return true
+}
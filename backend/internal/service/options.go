@@ -0,0 +1,358 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+)
+
+// Option validation errors, wrapped with the underlying reason
+var (
+	ErrInvalidQuality      = errors.New("invalid quality")
+	ErrInvalidAudio        = errors.New("invalid audio options")
+	ErrInvalidSponsorBlock = errors.New("invalid sponsorblock options")
+	ErrInvalidSubtitles    = errors.New("invalid subtitle options")
+	ErrInvalidCutPrecision = errors.New("invalid cut precision")
+	ErrInvalidAnimation    = errors.New("invalid animation options")
+	ErrInvalidReframe      = errors.New("invalid reframe options")
+	ErrInvalidEncoding     = errors.New("invalid codec or container")
+)
+
+// animationQuality is the source preset animated exports download when no quality is asked for,
+// the frames are scaled far below it anyway
+const animationQuality = "720p"
+
+// DownloadOptions carries the raw per-job options from the API layer
+type DownloadOptions struct {
+	Quality      string
+	Audio        string
+	AudioCodec   string
+	AudioBitrate int
+
+	SponsorBlock           string
+	SponsorBlockCategories []string
+
+	Subtitles         string
+	SubtitleLanguages []string
+	SubtitleAuto      bool
+	SubtitleFormat    string
+
+	Thumbnail bool
+
+	CutPrecision string // time range and clip jobs only
+
+	// Animated image export, time range jobs only
+	OutputFormat        string
+	AnimationWidth      int
+	AnimationFPS        float64
+	AnimationLoop       int
+	AnimationSinglePass bool
+	AnimationMaxBytes   int64
+
+	// Vertical 9:16 layout, time range jobs only. Vertical quality presets bring their own.
+	Reframe      string
+	ReframeCropX *float64
+
+	WatermarkID string // one of the user's stored watermarks
+
+	// Output codec and container, the default is the quality preset's mp4
+	VideoCodec    string
+	Container     string
+	CRF           int
+	EncoderPreset string
+
+	// Audio post-processing, video and audio-only outputs alike
+	Normalize  bool
+	TargetLUFS float64
+	FadeIn     float64
+	FadeOut    float64
+	Stereo     bool
+}
+
+// hasAnimation reports whether any animated export option was given
+func (o DownloadOptions) hasAnimation() bool {
+	return o.OutputFormat != "" || o.AnimationWidth != 0 || o.AnimationFPS != 0 || o.AnimationLoop != 0 ||
+		o.AnimationSinglePass || o.AnimationMaxBytes != 0
+}
+
+// validateOptions turns raw request options into downloader settings and the record stored on the job row
+func (vs *VideoService) validateOptions(raw DownloadOptions) (downloader.Options, model.JobOptions, error) {
+	var opts downloader.Options
+
+	preset, err := vs.validateQuality(raw.Quality)
+	if err != nil {
+		return opts, model.JobOptions{}, err
+	}
+	opts.Quality = preset
+
+	audioMode, err := downloader.ParseAudioMode(raw.Audio)
+	if err != nil {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: %v", ErrInvalidAudio, err)
+	}
+	opts.AudioMode = audioMode
+
+	if audioMode == downloader.AudioModeOnly {
+		format, err := downloader.NewAudioFormat(raw.AudioCodec, raw.AudioBitrate)
+		if err != nil {
+			return opts, model.JobOptions{}, fmt.Errorf("%w: %v", ErrInvalidAudio, err)
+		}
+		opts.Audio = format
+	} else if raw.AudioCodec != "" || raw.AudioBitrate != 0 {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: audio_codec and audio_bitrate require audio mode %q", ErrInvalidAudio, downloader.AudioModeOnly)
+	}
+
+	sponsorBlock, err := downloader.NewSponsorBlock(raw.SponsorBlock, raw.SponsorBlockCategories)
+	if err != nil {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: %v", ErrInvalidSponsorBlock, err)
+	}
+	opts.SponsorBlock = sponsorBlock
+
+	subtitles, err := downloader.NewSubtitles(raw.Subtitles, raw.SubtitleLanguages, raw.SubtitleAuto, raw.SubtitleFormat)
+	if err != nil {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: %v", ErrInvalidSubtitles, err)
+	}
+	// Audio-only output has no video or container track to carry the subtitles
+	if audioMode == downloader.AudioModeOnly && (subtitles.Mode == downloader.SubtitlesEmbed || subtitles.Mode == downloader.SubtitlesBurn) {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: audio-only jobs only support sidecar subtitles", ErrInvalidSubtitles)
+	}
+	// The cues keep the source timing, they would drift after every removed segment
+	if subtitles.Enabled() && sponsorBlock.Mode == downloader.SponsorBlockRemove {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: subtitles cannot be combined with sponsorblock remove, use sponsorblock mark", ErrInvalidSubtitles)
+	}
+	opts.Subtitles = subtitles
+
+	if raw.Thumbnail && audioMode == downloader.AudioModeOnly && opts.Audio.Codec == "wav" {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: wav output cannot carry a thumbnail", ErrInvalidAudio)
+	}
+	opts.Thumbnail = raw.Thumbnail
+
+	encoding, err := downloader.NewEncoding(raw.VideoCodec, raw.Container, raw.CRF, raw.EncoderPreset)
+	if err != nil {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	if encoding.Enabled() {
+		if audioMode == downloader.AudioModeOnly {
+			return opts, model.JobOptions{}, fmt.Errorf("%w: audio-only jobs pick their format with audio_codec", ErrInvalidEncoding)
+		}
+		// Cover art is dropped when streams are remuxed into another container
+		if raw.Thumbnail {
+			return opts, model.JobOptions{}, fmt.Errorf("%w: thumbnails are only embedded in the default mp4 output", ErrInvalidEncoding)
+		}
+	}
+	opts.Encoding = encoding
+
+	audioProcessing, err := downloader.NewAudioProcessing(raw.Normalize, raw.TargetLUFS, raw.FadeIn, raw.FadeOut, raw.Stereo)
+	if err != nil {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: %v", ErrInvalidAudio, err)
+	}
+	if audioProcessing.Enabled() && audioMode == downloader.AudioModeMute {
+		return opts, model.JobOptions{}, fmt.Errorf("%w: muted jobs have no audio to process", ErrInvalidAudio)
+	}
+	opts.AudioProcessing = audioProcessing
+
+	return opts, jobOptionsRecord(opts), nil
+}
+
+// withCutPrecision applies the cut precision of a job that cuts ranges out of the source
+func withCutPrecision(precision string, opts *downloader.Options, record *model.JobOptions) error {
+	cut, err := downloader.ParseCutPrecision(precision)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCutPrecision, err)
+	}
+	// Removed segments would shift the padded download under the cut points
+	if cut == downloader.CutAccurate && opts.SponsorBlock.Mode == downloader.SponsorBlockRemove {
+		return fmt.Errorf("%w: accurate cuts cannot be combined with sponsorblock remove", ErrInvalidCutPrecision)
+	}
+	opts.Cut = cut
+	record.CutPrecision = string(cut)
+	return nil
+}
+
+// withAnimation applies the animated export of a time range job, duration being the clip length in seconds.
+// Animations carry no audio, so the audio stream is not downloaded at all.
+func (vs *VideoService) withAnimation(raw DownloadOptions, duration float64, opts *downloader.Options, record *model.JobOptions) error {
+	animation, err := downloader.NewAnimation(raw.OutputFormat, raw.AnimationWidth, raw.AnimationFPS, raw.AnimationLoop, raw.AnimationSinglePass, raw.AnimationMaxBytes)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAnimation, err)
+	}
+	if !animation.Enabled() {
+		return nil
+	}
+	if duration > downloader.MaxAnimationSeconds {
+		return fmt.Errorf("%w: animated clips cannot exceed %d seconds", ErrInvalidAnimation, downloader.MaxAnimationSeconds)
+	}
+	if opts.AudioMode == downloader.AudioModeOnly {
+		return fmt.Errorf("%w: %s output has no audio, it cannot be combined with audio mode %q", ErrInvalidAnimation, animation.Format, downloader.AudioModeOnly)
+	}
+	if opts.Thumbnail {
+		return fmt.Errorf("%w: %s output cannot carry a thumbnail", ErrInvalidAnimation, animation.Format)
+	}
+	if opts.Encoding.Enabled() {
+		return fmt.Errorf("%w: %s output cannot be combined with a video codec or container", ErrInvalidAnimation, animation.Format)
+	}
+	if opts.AudioProcessing.Enabled() {
+		return fmt.Errorf("%w: %s output has no audio to process", ErrInvalidAnimation, animation.Format)
+	}
+	// Only burned-in subtitles survive the conversion to frames
+	if opts.Subtitles.Enabled() && opts.Subtitles.Mode != downloader.SubtitlesBurn {
+		return fmt.Errorf("%w: %s output only supports burned-in subtitles", ErrInvalidAnimation, animation.Format)
+	}
+
+	if strings.TrimSpace(raw.Quality) == "" {
+		preset, err := vs.validateQuality(animationQuality)
+		if err != nil {
+			return err
+		}
+		opts.Quality = preset
+		record.Quality = preset.Name
+	}
+	opts.AudioMode = downloader.AudioModeMute
+	record.AudioMode = string(downloader.AudioModeMute)
+	opts.Animation = animation
+	record.OutputFormat = string(animation.Format)
+	record.AnimationWidth = animation.Width
+	record.AnimationFPS = animation.FPS
+	record.AnimationLoop = animation.Loop
+	record.AnimationSinglePass = animation.SinglePass
+	record.AnimationMaxBytes = animation.MaxBytes
+	return nil
+}
+
+// withReframe applies the vertical layout of a time range job, falling back to the one of its quality preset
+func withReframe(raw DownloadOptions, opts *downloader.Options, record *model.JobOptions) error {
+	reframe, err := downloader.NewReframe(raw.Reframe, raw.ReframeCropX)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidReframe, err)
+	}
+	if !reframe.Enabled() && opts.Quality.Layout != "" {
+		reframe = downloader.Reframe{Layout: opts.Quality.Layout, CropX: 0.5}
+	}
+	if !reframe.Enabled() {
+		return nil
+	}
+	if opts.AudioMode == downloader.AudioModeOnly {
+		return fmt.Errorf("%w: audio-only jobs have no video to reframe", ErrInvalidReframe)
+	}
+	// The re-encode keeps the main video only, cover art would be dropped
+	if opts.Thumbnail {
+		return fmt.Errorf("%w: reframed clips cannot carry a thumbnail", ErrInvalidReframe)
+	}
+	opts.Reframe = reframe
+	record.Reframe = string(reframe.Layout)
+	if reframe.Layout == downloader.ReframeCrop {
+		record.ReframeCropX = &reframe.CropX
+	}
+	return nil
+}
+
+// rejectReframe fails jobs other than time range jobs that ask for a vertical layout
+func rejectReframe(raw DownloadOptions, opts downloader.Options) error {
+	if raw.Reframe != "" || raw.ReframeCropX != nil || opts.Quality.Layout != "" {
+		return fmt.Errorf("%w: vertical reframing only applies to time range jobs", ErrInvalidReframe)
+	}
+	return nil
+}
+
+// validateQuality resolves a requested preset name, falling back to the default when empty
+func (vs *VideoService) validateQuality(quality string) (downloader.QualityPreset, error) {
+	if strings.TrimSpace(quality) == "" {
+		quality = downloader.DefaultQuality
+	}
+
+	preset, err := downloader.LookupQuality(quality)
+	if err != nil {
+		return downloader.QualityPreset{}, fmt.Errorf("%w: %v", ErrInvalidQuality, err)
+	}
+	return preset, nil
+}
+
+// jobOptionsRecord is what gets persisted on the job row, so status responses show what was produced
+func jobOptionsRecord(opts downloader.Options) model.JobOptions {
+	record := model.JobOptions{
+		Quality:   opts.Quality.Name,
+		AudioMode: string(opts.AudioMode),
+	}
+	if opts.AudioMode == downloader.AudioModeOnly {
+		record.AudioCodec = opts.Audio.Codec
+		record.AudioBitrate = opts.Audio.Bitrate
+	}
+	record.SponsorBlock = string(opts.SponsorBlock.Mode)
+	record.SponsorBlockCategories = opts.SponsorBlock.Categories
+	record.Subtitles = string(opts.Subtitles.Mode)
+	if opts.Subtitles.Enabled() {
+		record.SubtitleLanguages = opts.Subtitles.Languages
+		record.SubtitleAuto = opts.Subtitles.Auto
+		record.SubtitleFormat = opts.Subtitles.Format
+	}
+	record.Thumbnail = opts.Thumbnail
+	if opts.Encoding.Enabled() {
+		record.VideoCodec = string(opts.Encoding.Codec)
+		record.Container = string(opts.Encoding.Container)
+		record.CRF = opts.Encoding.CRF
+		record.EncoderPreset = opts.Encoding.Preset
+	}
+	if opts.AudioProcessing.Normalize {
+		record.Normalize = true
+		record.TargetLUFS = opts.AudioProcessing.TargetLUFS
+	}
+	record.FadeIn = opts.AudioProcessing.FadeIn
+	record.FadeOut = opts.AudioProcessing.FadeOut
+	record.Stereo = opts.AudioProcessing.Stereo
+	return record
+}
+
+// jobResultRecord converts a downloader result into the fields stored on the job row
+func jobResultRecord(result *downloader.Result) model.JobResult {
+	var record model.JobResult
+	if result == nil {
+		return record
+	}
+	for _, seg := range result.SponsorSegments {
+		record.SponsorSegments = append(record.SponsorSegments, model.SponsorSegment{
+			Category: seg.Category,
+			Start:    seg.Start,
+			End:      seg.End,
+		})
+	}
+	record.OutputFile = result.Path
+	record.OutputName = result.FileName
+	record.OutputSize = result.Size
+	record.OutputWidth = result.Width
+	record.OutputHeight = result.Height
+	record.VideoCodec = result.VideoCodec
+	record.AudioCodec = result.AudioCodec
+	record.Container = result.Container
+	record.SourceFormat = result.SourceFormat
+	record.SourceHeight = result.SourceHeight
+	record.FinalDuration = result.Duration
+	record.CutStart = result.CutStart
+	record.CutEnd = result.CutEnd
+	record.OutputFPS = result.FPS
+	if l := result.Loudness; l != nil {
+		record.Loudness = &model.Loudness{
+			Target:    l.Target,
+			InputI:    l.InputI,
+			InputTP:   l.InputTP,
+			InputLRA:  l.InputLRA,
+			OutputI:   l.OutputI,
+			OutputTP:  l.OutputTP,
+			OutputLRA: l.OutputLRA,
+		}
+	}
+	return record
+}
+
+// jobProgressRecord converts a downloader progress update into the fields stored on the job row
+func jobProgressRecord(p downloader.Progress) model.JobProgress {
+	return model.JobProgress{
+		Phase:           p.Phase,
+		Percent:         p.Percent,
+		DownloadedBytes: p.DownloadedBytes,
+		TotalBytes:      p.TotalBytes,
+		Speed:           p.Speed,
+		ETA:             p.ETA,
+	}
+}
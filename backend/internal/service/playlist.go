@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+)
+
+const (
+	// MaxPlaylistItems caps how many child jobs one playlist request may create
+	MaxPlaylistItems = 50
+	// creditsPerJob is what each playlist child costs its owner
+	creditsPerJob = 1
+)
+
+// ErrInvalidPlaylist is returned for playlist requests that cannot be expanded into jobs
+var ErrInvalidPlaylist = errors.New("invalid playlist request")
+
+// PlaylistRange selects playlist entries by 1-based position, see downloader.PlaylistRange
+type PlaylistRange = downloader.PlaylistRange
+
+// CreditStore checks, charges and refunds user balances for the jobs they start
+type CreditStore interface {
+	GetUserCredits(userID string) (int, error)
+	SpendUserCredits(userID string, credits int) error
+	AddUserCredits(userID string, credits int) error
+}
+
+// PlaylistJob is a started playlist and the child download created for each entry
+type PlaylistJob struct {
+	ID       string          `json:"playlist_id"`
+	Title    string          `json:"title"`
+	Children []PlaylistChild `json:"children"`
+}
+
+// PlaylistChild is one entry of a playlist job, downloaded as a regular full video job
+type PlaylistChild struct {
+	DownloadID string `json:"download_id"`
+	Index      int    `json:"playlist_index"`
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+}
+
+// DownloadPlaylist expands a playlist or channel tab and queues one full video job per entry.
+// Each child takes a slot of the caller's rate limit through throttle (may be nil), which tells
+// how long the child is held back, and is charged creditsPerJob when a worker starts it.
+func (vs *VideoService) DownloadPlaylist(playlistURL, userID string, rng PlaylistRange, rawOpts DownloadOptions, throttle func() time.Duration) (*PlaylistJob, error) {
+	validatedURL, err := vs.validateURL(playlistURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid video URL: %w", err)
+	}
+	if userID == "" {
+		return nil, fmt.Errorf("%w: playlists are charged per video and need a signed in user", ErrInvalidPlaylist)
+	}
+
+	if rng.Start < 0 || rng.End < 0 || rng.MaxItems < 0 {
+		return nil, fmt.Errorf("%w: playlist_start, playlist_end and max_items cannot be negative", ErrInvalidPlaylist)
+	}
+	if rng.End > 0 && rng.End < rng.Start {
+		return nil, fmt.Errorf("%w: playlist_end must be >= playlist_start", ErrInvalidPlaylist)
+	}
+	if rng.MaxItems == 0 || rng.MaxItems > MaxPlaylistItems {
+		rng.MaxItems = MaxPlaylistItems
+	}
+
+	_, record, err := vs.fullVideoOptions(rawOpts, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(context.Background(), probeTimeout())
+	defer cancel()
+	playlist, err := downloader.ExpandPlaylist(ctx, validatedURL, rng)
+	if errors.Is(err, downloader.ErrNotPlaylist) {
+		return nil, fmt.Errorf("%w: url points at a single video, use /video/download", ErrInvalidPlaylist)
+	}
+	if err != nil {
+		return nil, probeError(err)
+	}
+	if len(playlist.Entries) == 0 {
+		return nil, fmt.Errorf("%w: no downloadable videos in the selected range", ErrInvalidPlaylist)
+	}
+
+	// Refuse up front rather than failing most children later
+	credits, err := vs.Credits.GetUserCredits(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user credits: %w", err)
+	}
+	if cost := len(playlist.Entries) * creditsPerJob; credits < cost {
+		return nil, fmt.Errorf("%w: %d videos need %d credits, %d available", ErrInsufficientCredits, len(playlist.Entries), cost, credits)
+	}
+
+	job := &PlaylistJob{ID: uuid.New().String(), Title: playlist.Title}
+	if err := vs.VideoRepo.CreatePlaylistRequest(job.ID, validatedURL, userID, playlist.Title, len(playlist.Entries)); err != nil {
+		log.Printf("DownloadPlaylist - CreatePlaylistRequest error: %v", err)
+		return nil, fmt.Errorf("failed to create playlist request: %w", err)
+	}
+	for _, entry := range playlist.Entries {
+		child := PlaylistChild{DownloadID: uuid.New().String(), Index: entry.Index, URL: entry.URL, Title: entry.Title}
+		if err := vs.VideoRepo.CreatePlaylistEntryRequest(child.DownloadID, entry.URL, userID, job.ID, entry.Index, record); err != nil {
+			log.Printf("DownloadPlaylist - CreatePlaylistEntryRequest error: %v", err)
+			// The children created so far would stay pending forever, nothing is going to start them
+			for _, created := range job.Children {
+				if updateErr := vs.VideoRepo.MarkDownloadFailed(created.DownloadID, FailureDownload, "Playlist could not be created"); updateErr != nil {
+					log.Printf("DownloadPlaylist - MarkDownloadFailed error: %v", updateErr)
+				}
+			}
+			return nil, fmt.Errorf("failed to create playlist entry request: %w", err)
+		}
+		job.Children = append(job.Children, child)
+	}
+
+	vs.queuePlaylist(job, userID, rawOpts, throttle)
+	return job, nil
+}
+
+// queuePlaylist queues the children of a playlist in playlist order, each held back until its
+// slot of the caller's rate limit comes up
+func (vs *VideoService) queuePlaylist(job *PlaylistJob, userID string, rawOpts DownloadOptions, throttle func() time.Duration) {
+	for i, child := range job.Children {
+		var notBefore time.Time
+		if throttle != nil {
+			if wait := throttle(); wait > 0 {
+				notBefore = time.Now().Add(wait)
+			}
+		}
+		if err := vs.enqueueAt(queueKindPlaylistEntry, child.DownloadID, child.URL, userID, queuePayload{Options: rawOpts}, notBefore); err != nil {
+			// Children already queued run anyway, the playlist status reports the rest as failed
+			for _, rest := range job.Children[i:] {
+				if updateErr := vs.VideoRepo.MarkDownloadFailed(rest.DownloadID, FailureDownload, err.Error()); updateErr != nil {
+					log.Printf("queuePlaylist - MarkDownloadFailed error: %v", updateErr)
+				}
+			}
+			return
+		}
+	}
+}
+
+// GetPlaylistStatus returns a playlist row owned by userID with its children and their
+// aggregated status and progress
+func (vs *VideoService) GetPlaylistStatus(playlistID, userID string) (map[string]interface{}, error) {
+	if playlistID == "" {
+		return nil, fmt.Errorf("playlist ID cannot be empty")
+	}
+
+	status, err := vs.VideoRepo.GetPlaylist(playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playlist status: %w", err)
+	}
+	if err := checkOwner(status, userID); err != nil {
+		return nil, err
+	}
+	children, err := vs.VideoRepo.GetPlaylistEntries(playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playlist entries: %w", err)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return rowNumber(children[i], "playlist_index") < rowNumber(children[j], "playlist_index")
+	})
+
+	summary := summarizeChildren(children, "playlist_index")
+	status["status"] = summary.status
+	status["progress_percent"] = summary.percent
+	status["counts"] = summary.counts
+	status["failures"] = summary.failures
+	status["children"] = children
+	// Children were queued in playlist order, the first one waiting tells when the playlist moves on
+	for _, child := range children {
+		if s, _ := child["status"].(string); s == StatusPending {
+			id, _ := child["id"].(string)
+			vs.withQueueStatus(id, child)
+			if position, ok := child["queue_position"]; ok {
+				status["queue_position"] = position
+				status["estimated_start"] = child["estimated_start"]
+			}
+			break
+		}
+	}
+	return status, nil
+}
+
+// CancelPlaylist cancels the children of a playlist owned by userID that are still waiting or
+// running, on whichever server runs them
+func (vs *VideoService) CancelPlaylist(playlistID, userID string) error {
+	if playlistID == "" {
+		return fmt.Errorf("playlist ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetPlaylist(playlistID)
+	if err != nil {
+		return fmt.Errorf("failed to get playlist status: %w", err)
+	}
+	if err := checkOwner(row, userID); err != nil {
+		return err
+	}
+	children, err := vs.VideoRepo.GetPlaylistEntries(playlistID)
+	if err != nil {
+		return fmt.Errorf("failed to get playlist entries: %w", err)
+	}
+
+	cancelled := 0
+	for _, child := range children {
+		id, _ := child["id"].(string)
+		if s, _ := child["status"].(string); s != StatusPending && s != StatusProcessing {
+			continue
+		}
+		dequeued, err := vs.cancelQueued(id)
+		if errors.Is(err, ErrDownloadNotRunning) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if dequeued {
+			if updateErr := vs.VideoRepo.UpdateDownloadStatus(id, StatusCancelled, "Playlist cancelled"); updateErr != nil {
+				log.Printf("CancelPlaylist - UpdateDownloadStatus error: %v", updateErr)
+			}
+		}
+		cancelled++
+	}
+	if cancelled == 0 {
+		return ErrDownloadNotRunning
+	}
+	return nil
+}
+
+type childSummary struct {
+	status   string
+	percent  float64
+	counts   map[string]int
+	failures []map[string]interface{}
+}
+
+// summarizeChildren aggregates the child rows of a playlist or clip job: finished children count
+// as 100% towards progress, the parent is processing until every child has finished.
+// indexColumn is the child column failures are reported with.
+func summarizeChildren(children []map[string]interface{}, indexColumn string) childSummary {
+	summary := childSummary{
+		counts:   map[string]int{StatusPending: 0, StatusProcessing: 0, StatusCompleted: 0, StatusFailed: 0, StatusCancelled: 0},
+		failures: []map[string]interface{}{},
+	}
+	if len(children) == 0 {
+		summary.status = StatusCompleted
+		return summary
+	}
+
+	var total float64
+	for _, child := range children {
+		status, _ := child["status"].(string)
+		summary.counts[status]++
+		switch status {
+		case StatusCompleted, StatusFailed, StatusCancelled:
+			total += 100
+		case StatusProcessing:
+			total += rowNumber(child, "progress_percent")
+		}
+		if status == StatusFailed {
+			summary.failures = append(summary.failures, map[string]interface{}{
+				"download_id": child["id"],
+				indexColumn:   child[indexColumn],
+				"error_code":  child["error_code"],
+				"message":     child["message"],
+			})
+		}
+	}
+	summary.percent = total / float64(len(children))
+
+	finished := summary.counts[StatusCompleted] + summary.counts[StatusFailed] + summary.counts[StatusCancelled]
+	switch {
+	case finished < len(children):
+		summary.status = StatusProcessing
+	case summary.counts[StatusCompleted] == len(children):
+		summary.status = StatusCompleted
+	case summary.counts[StatusCancelled] == len(children):
+		summary.status = StatusCancelled
+	case summary.counts[StatusCompleted] == 0:
+		summary.status = StatusFailed
+	default:
+		summary.status = StatusPartial
+	}
+	return summary
+}
+
+// rowNumber reads a numeric column from a row decoded from JSON, 0 when missing
+func rowNumber(row map[string]interface{}, column string) float64 {
+	n, _ := row[column].(float64)
+	return n
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+)
+
+func TestSummarizeChildren(t *testing.T) {
+	child := func(id, status string, progress float64) map[string]interface{} {
+		return map[string]interface{}{
+			"id":               id,
+			"status":           status,
+			"progress_percent": progress,
+			"playlist_index":   float64(len(id)),
+			"error_code":       "",
+			"message":          "",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		children []map[string]interface{}
+		status   string
+		percent  float64
+		failed   []interface{} // download IDs reported as failures
+	}{
+		{
+			name:    "no children",
+			status:  StatusCompleted,
+			percent: 0,
+		},
+		{
+			name:     "all pending",
+			children: []map[string]interface{}{child("a", StatusPending, 0), child("b", StatusPending, 0)},
+			status:   StatusProcessing,
+			percent:  0,
+		},
+		{
+			name:     "running child counts its own progress",
+			children: []map[string]interface{}{child("a", StatusCompleted, 100), child("b", StatusProcessing, 50)},
+			status:   StatusProcessing,
+			percent:  75,
+		},
+		{
+			name:     "pending progress is ignored",
+			children: []map[string]interface{}{child("a", StatusPending, 80), child("b", StatusCompleted, 100)},
+			status:   StatusProcessing,
+			percent:  50,
+		},
+		{
+			name:     "all completed",
+			children: []map[string]interface{}{child("a", StatusCompleted, 100), child("b", StatusCompleted, 100)},
+			status:   StatusCompleted,
+			percent:  100,
+		},
+		{
+			name:     "all cancelled",
+			children: []map[string]interface{}{child("a", StatusCancelled, 10), child("b", StatusCancelled, 0)},
+			status:   StatusCancelled,
+			percent:  100,
+		},
+		{
+			name:     "nothing completed",
+			children: []map[string]interface{}{child("a", StatusFailed, 0), child("b", StatusCancelled, 0)},
+			status:   StatusFailed,
+			percent:  100,
+			failed:   []interface{}{"a"},
+		},
+		{
+			name:     "some completed",
+			children: []map[string]interface{}{child("a", StatusCompleted, 100), child("bb", StatusFailed, 30), child("ccc", StatusFailed, 0)},
+			status:   StatusPartial,
+			percent:  100,
+			failed:   []interface{}{"bb", "ccc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarizeChildren(tt.children, "playlist_index")
+			if got.status != tt.status {
+				t.Errorf("status = %q, want %q", got.status, tt.status)
+			}
+			if got.percent != tt.percent {
+				t.Errorf("percent = %v, want %v", got.percent, tt.percent)
+			}
+
+			var failed []interface{}
+			for _, f := range got.failures {
+				failed = append(failed, f["download_id"])
+				if f["playlist_index"] == nil {
+					t.Errorf("failure %v has no playlist_index", f["download_id"])
+				}
+			}
+			if !reflect.DeepEqual(failed, tt.failed) {
+				t.Errorf("failures = %v, want %v", failed, tt.failed)
+			}
+
+			total := 0
+			for _, n := range got.counts {
+				total += n
+			}
+			if total != len(tt.children) {
+				t.Errorf("counts add up to %d, want %d", total, len(tt.children))
+			}
+		})
+	}
+}
+
+// enqueueRecorder keeps the jobs queued through it, the other repo methods are not used
+type enqueueRecorder struct {
+	VideoRepository
+	queued []model.QueuedJob
+}
+
+func (r *enqueueRecorder) EnqueueJob(job model.QueuedJob) error {
+	r.queued = append(r.queued, job)
+	return nil
+}
+
+func TestQueuePlaylistThrottle(t *testing.T) {
+	tests := []struct {
+		name  string
+		waits []time.Duration // what the throttle returns for each child in turn, nil for no throttle
+		held  []bool          // whether each child is queued with a not_before
+	}{
+		{name: "no throttle", held: []bool{false, false, false}},
+		{name: "within the burst", waits: []time.Duration{0, 0, 0}, held: []bool{false, false, false}},
+		{name: "past the burst", waits: []time.Duration{0, 200 * time.Millisecond, 400 * time.Millisecond}, held: []bool{false, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &enqueueRecorder{}
+			vs := &VideoService{VideoRepo: repo, queue: &jobQueue{wake: make(chan struct{}, 1)}}
+			job := &PlaylistJob{ID: "playlist"}
+			for i := range tt.held {
+				job.Children = append(job.Children, PlaylistChild{DownloadID: fmt.Sprint("child-", i), Index: i + 1})
+			}
+
+			var throttle func() time.Duration
+			calls := 0
+			if tt.waits != nil {
+				throttle = func() time.Duration {
+					calls++
+					return tt.waits[calls-1]
+				}
+			}
+			start := time.Now()
+			vs.queuePlaylist(job, "user", DownloadOptions{}, throttle)
+
+			if tt.waits != nil && calls != len(job.Children) {
+				t.Errorf("throttle called %d times, want once per child (%d)", calls, len(job.Children))
+			}
+			if len(repo.queued) != len(job.Children) {
+				t.Fatalf("queued %d jobs, want %d", len(repo.queued), len(job.Children))
+			}
+			for i, queued := range repo.queued {
+				if held := queued.NotBefore != nil; held != tt.held[i] {
+					t.Errorf("child %d held = %v, want %v", i, held, tt.held[i])
+					continue
+				}
+				if queued.NotBefore != nil && queued.NotBefore.Before(start.Add(tt.waits[i])) {
+					t.Errorf("child %d not_before = %v, want at least %v after %v", i, queued.NotBefore, tt.waits[i], start)
+				}
+			}
+		})
+	}
+}
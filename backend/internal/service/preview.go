@@ -0,0 +1,347 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+	"github.com/verse91/ytb-clipy/backend/pkg/utils"
+)
+
+var (
+	// ErrInvalidSnapshot is returned for snapshot requests with a bad timestamp, format or width
+	ErrInvalidSnapshot = errors.New("invalid snapshot request")
+	// ErrInvalidStoryboard is returned for storyboard requests with unusable options
+	ErrInvalidStoryboard = errors.New("invalid storyboard request")
+	// ErrPreviewFailed is returned when a frame or storyboard could not be produced
+	ErrPreviewFailed = errors.New("failed to generate preview")
+	// ErrPreviewBusy is returned when every preview slot of the server is taken
+	ErrPreviewBusy = errors.New("too many previews are being generated, try again shortly")
+)
+
+const (
+	// previewPruneInterval is the least time between two passes over the preview cache
+	previewPruneInterval = time.Minute
+	// previewMinAge keeps freshly written previews, a storyboard may still be filling its dir
+	previewMinAge = 10 * time.Minute
+)
+
+// previewLimiter bounds the previews a server computes while requests wait on them, and keeps
+// their cache under its size limit
+type previewLimiter struct {
+	slots     chan struct{}
+	maxBytes  int64
+	lastPrune atomic.Int64 // unix seconds
+}
+
+// newPreviewLimiter reads PREVIEW_WORKERS and PREVIEW_CACHE_MB, NewVideoService runs after main
+// loaded the .env file
+func newPreviewLimiter() *previewLimiter {
+	return &previewLimiter{
+		slots:    make(chan struct{}, max(1, utils.GetEnvAsInt("PREVIEW_WORKERS", 2))),
+		maxBytes: int64(max(1, utils.GetEnvAsInt("PREVIEW_CACHE_MB", 2048))) << 20,
+	}
+}
+
+// acquire takes a preview slot without waiting, the caller must call release once done
+func (p *previewLimiter) acquire() (release func(), err error) {
+	select {
+	case p.slots <- struct{}{}:
+		return func() { <-p.slots }, nil
+	default:
+		return nil, ErrPreviewBusy
+	}
+}
+
+// prune trims the preview cache in the background, at most once per previewPruneInterval
+func (p *previewLimiter) prune() {
+	now := time.Now().Unix()
+	last := p.lastPrune.Load()
+	if now-last < int64(previewPruneInterval/time.Second) || !p.lastPrune.CompareAndSwap(last, now) {
+		return
+	}
+	go func() {
+		freed, err := downloader.PrunePreviews(p.maxBytes, previewMinAge)
+		if err != nil {
+			log.Printf("prune - PrunePreviews error: %v", err)
+		}
+		if freed > 0 {
+			log.Printf("prune - removed %d MB of cached previews", freed>>20)
+		}
+	}()
+}
+
+// SnapshotInput is a single frame request as the client sent it
+type SnapshotInput struct {
+	Time   string // any notation parseTimestamp accepts
+	Format string // jpg or png
+	Width  int    // 0 keeps the source width
+}
+
+// StoryboardInput holds the raw storyboard options, zero values take the defaults
+type StoryboardInput struct {
+	Interval float64
+	Width    int
+	Columns  int
+}
+
+// validateSnapshot turns a snapshot request into downloader settings
+func validateSnapshot(input SnapshotInput) (downloader.Snapshot, error) {
+	var ms int64
+	if input.Time != "" {
+		var err error
+		if ms, err = parseTimestamp(input.Time); err != nil {
+			return downloader.Snapshot{}, fmt.Errorf("%w: time: %v", ErrInvalidSnapshot, err)
+		}
+	}
+	snap, err := downloader.NewSnapshot(float64(ms)/1000, input.Format, input.Width)
+	if err != nil {
+		return downloader.Snapshot{}, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	return snap, nil
+}
+
+// snapshotError sorts frame failures into client mistakes and our own
+func snapshotError(err error) error {
+	switch {
+	case errors.Is(err, downloader.ErrPastEnd), errors.Is(err, downloader.ErrNoVideoTrack):
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: timed out", ErrPreviewFailed)
+	}
+	return fmt.Errorf("%w: %v", ErrPreviewFailed, err)
+}
+
+// SourceSnapshot returns a cached or freshly grabbed frame of the source video
+func (vs *VideoService) SourceSnapshot(videoURL string, input SnapshotInput) (string, error) {
+	validatedURL, err := vs.validateURL(videoURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid video URL: %w", err)
+	}
+	snap, err := validateSnapshot(input)
+	if err != nil {
+		return "", err
+	}
+	release, err := vs.previews.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	ctx, cancel := withTimeout(context.Background(), snapshotTimeout())
+	defer cancel()
+	path, err := downloader.SourceSnapshot(ctx, validatedURL, snap)
+	if err != nil {
+		return "", snapshotError(err)
+	}
+	vs.previews.prune()
+	return path, nil
+}
+
+// DownloadSnapshot returns a frame of the file of a completed full video download owned by userID
+func (vs *VideoService) DownloadSnapshot(downloadID, userID string, input SnapshotInput) (string, error) {
+	file, err := vs.DownloadFile(downloadID, userID)
+	if err != nil {
+		return "", err
+	}
+	return vs.outputSnapshot(downloadID, file, input)
+}
+
+// TimeRangeSnapshot returns a frame of the clip of a completed time range download owned by userID
+func (vs *VideoService) TimeRangeSnapshot(downloadID, userID string, input SnapshotInput) (string, error) {
+	file, err := vs.TimeRangeDownloadFile(downloadID, userID)
+	if err != nil {
+		return "", err
+	}
+	return vs.outputSnapshot(downloadID, file, input)
+}
+
+// outputSnapshot grabs a frame of a finished job's file, cached under the job ID
+func (vs *VideoService) outputSnapshot(jobID string, file OutputFile, input SnapshotInput) (string, error) {
+	snap, err := validateSnapshot(input)
+	if err != nil {
+		return "", err
+	}
+	release, err := vs.previews.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	ctx, cancel := withTimeout(context.Background(), snapshotTimeout())
+	defer cancel()
+	path, err := downloader.FileSnapshot(ctx, jobID, file.Path, snap)
+	if err != nil {
+		return "", snapshotError(err)
+	}
+	vs.previews.prune()
+	return path, nil
+}
+
+// CreateStoryboard queues a storyboard job. Storyboards already generated for the same video
+// and options are reused, the job then completes as soon as a worker claims it.
+func (vs *VideoService) CreateStoryboard(videoURL, userID string, input StoryboardInput) (string, error) {
+	validatedURL, err := vs.validateURL(videoURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid video URL: %w", err)
+	}
+	opts, err := storyboardOptions(input)
+	if err != nil {
+		return "", err
+	}
+
+	storyboardID := uuid.New().String()
+	record := model.StoryboardOptions{Interval: opts.Interval, Width: opts.Width, Columns: opts.Columns}
+	if err := vs.VideoRepo.CreateStoryboardRequest(storyboardID, validatedURL, userID, record); err != nil {
+		log.Printf("CreateStoryboard - CreateStoryboardRequest error: %v", err)
+		return "", fmt.Errorf("failed to create storyboard request: %w", err)
+	}
+
+	// A worker generates the storyboard once one is free
+	if err := vs.enqueue(queueKindStoryboard, storyboardID, validatedURL, userID, queuePayload{Storyboard: &input}); err != nil {
+		if updateErr := vs.VideoRepo.MarkStoryboardFailed(storyboardID, FailureDownload, err.Error()); updateErr != nil {
+			log.Printf("CreateStoryboard - MarkStoryboardFailed error: %v", updateErr)
+		}
+		return "", err
+	}
+
+	return storyboardID, nil
+}
+
+// storyboardOptions validates a storyboard request. It runs when the job is queued and again
+// when a worker claims it.
+func storyboardOptions(input StoryboardInput) (downloader.StoryboardOptions, error) {
+	opts, err := downloader.NewStoryboardOptions(input.Interval, input.Width, input.Columns)
+	if err != nil {
+		return downloader.StoryboardOptions{}, fmt.Errorf("%w: %v", ErrInvalidStoryboard, err)
+	}
+	return opts, nil
+}
+
+// runStoryboard runs a storyboard job to the end and records how it finished
+func (vs *VideoService) runStoryboard(ctx context.Context, storyboardID, videoURL string, opts downloader.StoryboardOptions) {
+	defer vs.jobs.finish(storyboardID)
+
+	sb, err := downloader.GenerateStoryboard(ctx, videoURL, opts, storyboardTimeouts())
+	if claimLost(ctx) {
+		// The server that took the job over records it, the sprites stay in the shared cache
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		if updateErr := vs.VideoRepo.UpdateStoryboardStatus(storyboardID, StatusCancelled, "Storyboard cancelled"); updateErr != nil {
+			log.Printf("CreateStoryboard - UpdateStoryboardStatus error: %v", updateErr)
+		}
+		return
+	}
+	if err != nil {
+		if updateErr := vs.VideoRepo.MarkStoryboardFailed(storyboardID, failureCode(err), err.Error()); updateErr != nil {
+			log.Printf("CreateStoryboard - MarkStoryboardFailed error: %v", updateErr)
+		}
+		return
+	}
+
+	result := model.StoryboardResult{
+		VideoID:    sb.VideoID,
+		OutputDir:  sb.Dir,
+		Sprites:    sb.Sprites,
+		FrameCount: sb.Frames,
+		TileWidth:  sb.TileWidth,
+		TileHeight: sb.TileHeight,
+		Cached:     sb.Cached,
+	}
+	if saveErr := vs.VideoRepo.SaveStoryboardResult(storyboardID, result); saveErr != nil {
+		log.Printf("CreateStoryboard - SaveStoryboardResult error: %v", saveErr)
+	}
+	if updateErr := vs.VideoRepo.UpdateStoryboardStatus(storyboardID, StatusCompleted, ""); updateErr != nil {
+		log.Printf("CreateStoryboard - UpdateStoryboardStatus error: %v", updateErr)
+	}
+	vs.previews.prune()
+}
+
+func (vs *VideoService) GetStoryboardStatus(storyboardID string) (map[string]interface{}, error) {
+	if storyboardID == "" {
+		return nil, fmt.Errorf("storyboard ID cannot be empty")
+	}
+
+	status, err := vs.VideoRepo.GetStoryboard(storyboardID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storyboard status: %w", err)
+	}
+	// Clients fetch the files by name, the server side dir is none of their business
+	publicStatus(status)
+	vs.withQueueStatus(storyboardID, status)
+	return status, nil
+}
+
+// CancelStoryboard takes a queued storyboard job owned by userID off the queue or stops it while it
+// runs, on whichever server runs it; the job marks running ones cancelled
+func (vs *VideoService) CancelStoryboard(storyboardID, userID string) error {
+	if storyboardID == "" {
+		return fmt.Errorf("storyboard ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetStoryboard(storyboardID)
+	if err != nil {
+		return fmt.Errorf("failed to get storyboard status: %w", err)
+	}
+	if err := checkOwner(row, userID); err != nil {
+		return err
+	}
+	dequeued, err := vs.cancelQueued(storyboardID)
+	if err != nil {
+		return err
+	}
+	if dequeued {
+		if updateErr := vs.VideoRepo.UpdateStoryboardStatus(storyboardID, StatusCancelled, "Storyboard cancelled"); updateErr != nil {
+			log.Printf("CancelStoryboard - UpdateStoryboardStatus error: %v", updateErr)
+		}
+	}
+	return nil
+}
+
+// StoryboardFile returns the WebVTT track or one of the sprite sheets of a completed storyboard.
+// Previews carry nothing private, so unlike downloads they are not tied to their owner.
+func (vs *VideoService) StoryboardFile(storyboardID, name string) (OutputFile, error) {
+	if storyboardID == "" {
+		return OutputFile{}, fmt.Errorf("storyboard ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetStoryboard(storyboardID)
+	if err != nil {
+		return OutputFile{}, fmt.Errorf("failed to get storyboard status: %w", err)
+	}
+	status, _ := row["status"].(string)
+	dir, _ := row["output_dir"].(string)
+	if status != StatusCompleted || dir == "" {
+		return OutputFile{}, ErrFileNotReady
+	}
+
+	// Only names the job wrote are served, anything else could walk out of the dir
+	var sprites []string
+	if list, ok := row["sprites"].([]interface{}); ok {
+		for _, s := range list {
+			if sprite, ok := s.(string); ok {
+				sprites = append(sprites, sprite)
+			}
+		}
+	}
+	if name != downloader.StoryboardVTTName && !slices.Contains(sprites, name) {
+		return OutputFile{}, fmt.Errorf("storyboard has no file %q", name)
+	}
+
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return OutputFile{}, ErrFileGone
+	}
+	return OutputFile{Path: path, Name: name}, nil
+}
@@ -0,0 +1,577 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+	"github.com/verse91/ytb-clipy/backend/pkg/utils"
+)
+
+// Kinds of queued jobs
+const (
+	queueKindFullVideo     = "full_video"
+	queueKindTimeRange     = "time_range"
+	queueKindPlaylistEntry = "playlist_entry" // a full video job charged to its owner when it starts
+	queueKindClips         = "clips"
+	queueKindStoryboard    = "storyboard"
+)
+
+// job_queue states of jobs waiting for a worker and jobs a worker claimed
+const (
+	queueStatusPending = "pending"
+	queueStatusRunning = "running"
+)
+
+const (
+	// Jobs silent for queueStaleBeats heartbeats belonged to a server that stopped and are queued again
+	queueStaleBeats = 3
+	// queueEstimateSample is how many recent jobs the average job time is taken over
+	queueEstimateSample = 20
+	// queuePurgeInterval is how often a server deletes old entries of finished jobs
+	queuePurgeInterval = time.Hour
+)
+
+// jobQueue is this server's share of the job queue
+type jobQueue struct {
+	workerID string
+	wake     chan struct{}
+	// workers is how many queued jobs one server runs at a time
+	workers int
+	// pollInterval is how often idle workers look for jobs queued on other servers, jobs
+	// queued on this one wake them right away
+	pollInterval time.Duration
+	// heartbeat is how often a server marks its running jobs alive
+	heartbeat time.Duration
+	// defaultJobTime stands in for the average job when no job has finished yet
+	defaultJobTime time.Duration
+	// retention is how long entries of finished jobs are kept, recent ones feed the wait estimate
+	retention time.Duration
+
+	mu      sync.Mutex
+	claimed map[string]time.Time // the jobs this server's workers run, by when they were claimed
+	// lastBeat is when the database last took this server's heartbeat, only RunQueue touches it
+	lastBeat time.Time
+}
+
+// newJobQueue reads the QUEUE_* settings, NewVideoService runs after main loaded the .env file
+func newJobQueue() *jobQueue {
+	host, _ := os.Hostname()
+	workers := max(1, utils.GetEnvAsInt("QUEUE_WORKERS", 4))
+	return &jobQueue{
+		workerID:       fmt.Sprintf("%s-%s", host, uuid.New().String()[:8]),
+		wake:           make(chan struct{}, workers),
+		workers:        workers,
+		pollInterval:   max(time.Second, envSeconds("QUEUE_POLL_INTERVAL", 5)),
+		heartbeat:      max(time.Second, envSeconds("QUEUE_HEARTBEAT_INTERVAL", 30)),
+		defaultJobTime: envSeconds("QUEUE_DEFAULT_JOB_SECONDS", 120),
+		retention:      time.Duration(max(1, utils.GetEnvAsInt("QUEUE_RETENTION_HOURS", 168))) * time.Hour,
+		claimed:        make(map[string]time.Time),
+	}
+}
+
+// hold records a job one of this server's workers claimed
+func (q *jobQueue) hold(id string) {
+	q.mu.Lock()
+	q.claimed[id] = time.Now()
+	q.mu.Unlock()
+}
+
+// release forgets a job once its worker is done with it
+func (q *jobQueue) release(id string) {
+	q.mu.Lock()
+	delete(q.claimed, id)
+	q.mu.Unlock()
+}
+
+// claimedBefore returns the jobs held since before t, a heartbeat that started at t covers them
+func (q *jobQueue) claimedBefore(t time.Time) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var ids []string
+	for id, at := range q.claimed {
+		if at.Before(t) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// notify wakes an idle worker, if there is one
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queuePayload is what a worker needs to run a queued job. The raw options are stored rather
+// than the validated ones, the worker validates them again.
+type queuePayload struct {
+	Options  DownloadOptions `json:"options"`
+	StartSec float64         `json:"start_sec,omitempty"` // time range jobs only
+	EndSec   float64         `json:"end_sec,omitempty"`
+	CheckEnd bool            `json:"check_end,omitempty"` // the range still has to be checked against the video
+	Charged  bool            `json:"charged,omitempty"`   // a playlist child's credits were spent, it is not charged again when queued again
+
+	Output     string           `json:"output,omitempty"` // clip jobs only
+	Segments   []ClipSegmentJob `json:"segments,omitempty"`
+	Storyboard *StoryboardInput `json:"storyboard,omitempty"`
+}
+
+// QueueStatus is where a waiting job stands in the queue
+type QueueStatus struct {
+	Position       int       // 1 is next in line
+	EstimatedStart time.Time // a guess from the average time of recent jobs
+}
+
+// enqueue adds a job whose row was just created to the queue
+func (vs *VideoService) enqueue(kind, id, videoURL, userID string, payload queuePayload) error {
+	return vs.enqueueAt(kind, id, videoURL, userID, payload, time.Time{})
+}
+
+// enqueueAt queues a job no worker may claim before notBefore, the zero time for right away
+func (vs *VideoService) enqueueAt(kind, id, videoURL, userID string, payload queuePayload, notBefore time.Time) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode queued job: %w", err)
+	}
+	job := model.QueuedJob{ID: id, Kind: kind, URL: videoURL, UserID: userID, Payload: data}
+	if !notBefore.IsZero() {
+		job.NotBefore = &notBefore
+	}
+	if err := vs.VideoRepo.EnqueueJob(job); err != nil {
+		log.Printf("enqueue - EnqueueJob error: %v", err)
+		return fmt.Errorf("failed to queue job: %w", err)
+	}
+	vs.queue.notify()
+	return nil
+}
+
+// RunQueue runs this server's queue workers until ctx is cancelled. Jobs already running then
+// run to the end and RunQueue returns once they did, waiting jobs stay queued for the next start
+// or for other servers.
+func (vs *VideoService) RunQueue(ctx context.Context) {
+	log.Printf("RunQueue - starting %d workers as %s", vs.queue.workers, vs.queue.workerID)
+	vs.queue.lastBeat = time.Now()
+	vs.requeueStaleJobs()
+	vs.purgeFinishedJobs()
+
+	var wg sync.WaitGroup
+	for i := 0; i < vs.queue.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vs.queueWorker(ctx)
+		}()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	ticker := time.NewTicker(vs.queue.heartbeat)
+	defer ticker.Stop()
+	lastPurge := time.Now()
+	stopping := ctx.Done()
+	for {
+		select {
+		case <-stopping:
+			log.Printf("RunQueue - stopping, waiting for running jobs to finish")
+			stopping = nil
+		case <-stopped:
+			log.Printf("RunQueue - all workers stopped")
+			return
+		case <-ticker.C:
+			// Running jobs keep their heartbeat while the server shuts down, or another
+			// server would queue them again
+			vs.heartbeat()
+			if ctx.Err() != nil {
+				continue
+			}
+			vs.requeueStaleJobs()
+			if time.Since(lastPurge) >= queuePurgeInterval {
+				vs.purgeFinishedJobs()
+				lastPurge = time.Now()
+			}
+		}
+	}
+}
+
+// heartbeat marks this server's running jobs alive and applies what happened to their entries
+// meanwhile: jobs flagged for cancelling are cancelled, jobs another server queued again are
+// abandoned. When heartbeats keep failing the jobs are abandoned too, before other servers may
+// take them over.
+func (vs *VideoService) heartbeat() {
+	beat := time.Now()
+	held, err := vs.VideoRepo.HeartbeatQueuedJobs(vs.queue.workerID)
+	if err != nil {
+		log.Printf("heartbeat - HeartbeatQueuedJobs error: %v", err)
+		if time.Since(vs.queue.lastBeat) >= (queueStaleBeats-1)*vs.queue.heartbeat {
+			for _, id := range vs.queue.claimedBefore(beat) {
+				log.Printf("heartbeat - abandoning job %s, its claim could not be renewed", id)
+				vs.jobs.abandon(id)
+			}
+		}
+		return
+	}
+	vs.queue.lastBeat = beat
+
+	alive := make(map[string]bool, len(held))
+	for _, job := range held {
+		alive[job.ID] = true
+		if job.CancelRequested && vs.jobs.cancel(job.ID) {
+			log.Printf("heartbeat - cancelled job %s on request", job.ID)
+		}
+	}
+	for _, id := range vs.queue.claimedBefore(beat) {
+		if !alive[id] {
+			log.Printf("heartbeat - abandoning job %s, another server took it over", id)
+			vs.jobs.abandon(id)
+		}
+	}
+}
+
+// purgeFinishedJobs deletes queue entries of jobs finished longer than the retention ago
+func (vs *VideoService) purgeFinishedJobs() {
+	purged, err := vs.VideoRepo.PurgeFinishedJobs(time.Now().Add(-vs.queue.retention))
+	if err != nil {
+		log.Printf("purgeFinishedJobs - PurgeFinishedJobs error: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("purgeFinishedJobs - deleted %d finished queue entries", purged)
+	}
+}
+
+// queueWorker runs queued jobs one at a time, draining the queue before it waits again
+func (vs *VideoService) queueWorker(ctx context.Context) {
+	ticker := time.NewTicker(vs.queue.pollInterval)
+	defer ticker.Stop()
+	for {
+		for ctx.Err() == nil {
+			job, err := vs.VideoRepo.ClaimQueuedJob(vs.queue.workerID)
+			if err != nil {
+				log.Printf("queueWorker - ClaimQueuedJob error: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			vs.runQueuedJob(job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-vs.queue.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runQueuedJob runs a claimed job to the end and takes it off the queue
+func (vs *VideoService) runQueuedJob(job *model.QueuedJob) {
+	vs.queue.hold(job.ID)
+	defer func() {
+		if err := vs.VideoRepo.FinishQueuedJob(job.ID, vs.queue.workerID); err != nil {
+			log.Printf("runQueuedJob - FinishQueuedJob error: %v", err)
+		}
+		vs.queue.release(job.ID)
+	}()
+
+	var payload queuePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		vs.failQueuedJob(job, fmt.Errorf("failed to decode queued job: %w", err))
+		return
+	}
+
+	switch job.Kind {
+	case queueKindFullVideo:
+		opts, _, err := vs.fullVideoOptions(payload.Options, job.UserID)
+		if err != nil {
+			vs.failQueuedJob(job, err)
+			return
+		}
+		ctx, ok := vs.startQueuedJob(job.ID)
+		if !ok {
+			return
+		}
+		if updateErr := vs.VideoRepo.UpdateDownloadStatus(job.ID, StatusProcessing, ""); updateErr != nil {
+			log.Printf("runQueuedJob - UpdateDownloadStatus error: %v", updateErr)
+		}
+		vs.runFullVideo(ctx, job.ID, job.URL, opts)
+	case queueKindTimeRange:
+		opts, _, err := vs.timeRangeOptions(payload.Options, job.UserID, payload.StartSec, payload.EndSec)
+		if err != nil {
+			vs.failQueuedJob(job, err)
+			return
+		}
+		ctx, ok := vs.startQueuedJob(job.ID)
+		if !ok {
+			return
+		}
+		if updateErr := vs.VideoRepo.UpdateTimeRangeDownloadStatus(job.ID, StatusProcessing, "", ""); updateErr != nil {
+			log.Printf("runQueuedJob - UpdateTimeRangeDownloadStatus error: %v", updateErr)
+		}
+		vs.runTimeRange(ctx, job.ID, job.URL, payload.StartSec, payload.EndSec, payload.CheckEnd, opts)
+	case queueKindPlaylistEntry:
+		opts, _, err := vs.fullVideoOptions(payload.Options, job.UserID)
+		if err != nil {
+			vs.failQueuedJob(job, err)
+			return
+		}
+		ctx, ok := vs.startQueuedJob(job.ID)
+		if !ok {
+			return
+		}
+		// Charged only once the job is this worker's to run, a cancelled child is never charged
+		if ctx.Err() == nil && !payload.Charged {
+			if err := vs.chargeQueuedJob(job, payload); err != nil {
+				vs.jobs.finish(job.ID)
+				vs.failQueuedJob(job, err)
+				return
+			}
+		}
+		if updateErr := vs.VideoRepo.UpdateDownloadStatus(job.ID, StatusProcessing, ""); updateErr != nil {
+			log.Printf("runQueuedJob - UpdateDownloadStatus error: %v", updateErr)
+		}
+		vs.runFullVideo(ctx, job.ID, job.URL, opts)
+	case queueKindClips:
+		opts, _, err := vs.clipOptions(payload.Options, job.UserID, payload.Output)
+		if err != nil {
+			vs.failQueuedJob(job, err)
+			return
+		}
+		ctx, ok := vs.startQueuedJob(job.ID)
+		if !ok {
+			return
+		}
+		if updateErr := vs.VideoRepo.UpdateClipStatus(job.ID, StatusProcessing, ""); updateErr != nil {
+			log.Printf("runQueuedJob - UpdateClipStatus error: %v", updateErr)
+		}
+		vs.runClips(ctx, &ClipJob{ID: job.ID, Output: payload.Output, Segments: payload.Segments}, job.URL, opts)
+	case queueKindStoryboard:
+		if payload.Storyboard == nil {
+			vs.failQueuedJob(job, fmt.Errorf("%w: queued without options", ErrInvalidStoryboard))
+			return
+		}
+		opts, err := storyboardOptions(*payload.Storyboard)
+		if err != nil {
+			vs.failQueuedJob(job, err)
+			return
+		}
+		ctx, ok := vs.startQueuedJob(job.ID)
+		if !ok {
+			return
+		}
+		if updateErr := vs.VideoRepo.UpdateStoryboardStatus(job.ID, StatusProcessing, ""); updateErr != nil {
+			log.Printf("runQueuedJob - UpdateStoryboardStatus error: %v", updateErr)
+		}
+		vs.runStoryboard(ctx, job.ID, job.URL, opts)
+	default:
+		log.Printf("runQueuedJob - unknown job kind %q of %s", job.Kind, job.ID)
+	}
+}
+
+// startQueuedJob registers a claimed job and reads its entry again: a cancel that came in
+// between the claim and the registration could not reach the job. It reports false, with the
+// job released again, when the entry is no longer this worker's to run.
+func (vs *VideoService) startQueuedJob(id string) (context.Context, bool) {
+	ctx := vs.jobs.start(id)
+	job, err := vs.VideoRepo.GetQueuedJob(id)
+	if err != nil {
+		// The heartbeat still catches a lost claim or a cancel request
+		log.Printf("startQueuedJob - GetQueuedJob error: %v", err)
+		return ctx, true
+	}
+	if job.Status != queueStatusRunning || job.WorkerID != vs.queue.workerID {
+		vs.jobs.finish(id)
+		return nil, false
+	}
+	if job.CancelRequested {
+		vs.jobs.cancel(id)
+	}
+	return ctx, true
+}
+
+// chargeQueuedJob spends a playlist child's credits and remembers it in the queue entry, so a
+// child queued again after its server stopped is not charged twice. The credits are given back
+// when the charge cannot be remembered.
+func (vs *VideoService) chargeQueuedJob(job *model.QueuedJob, payload queuePayload) error {
+	if err := vs.Credits.SpendUserCredits(job.UserID, creditsPerJob); err != nil {
+		return err
+	}
+	payload.Charged = true
+	data, err := json.Marshal(payload)
+	if err == nil {
+		err = vs.VideoRepo.UpdateQueuedJobPayload(job.ID, data)
+	}
+	if err != nil {
+		log.Printf("chargeQueuedJob - UpdateQueuedJobPayload error: %v", err)
+		if refundErr := vs.Credits.AddUserCredits(job.UserID, creditsPerJob); refundErr != nil {
+			log.Printf("chargeQueuedJob - AddUserCredits error: %v", refundErr)
+		}
+		return fmt.Errorf("failed to record playlist charge: %w", err)
+	}
+	return nil
+}
+
+// failQueuedJob fails the row of a queued job that could not be started
+func (vs *VideoService) failQueuedJob(job *model.QueuedJob, err error) {
+	var updateErr error
+	switch job.Kind {
+	case queueKindFullVideo, queueKindPlaylistEntry:
+		updateErr = vs.VideoRepo.MarkDownloadFailed(job.ID, failureCode(err), err.Error())
+	case queueKindTimeRange:
+		updateErr = vs.VideoRepo.MarkTimeRangeDownloadFailed(job.ID, failureCode(err), err.Error())
+	case queueKindClips:
+		segments, getErr := vs.clipSegments(job.ID)
+		if getErr != nil {
+			log.Printf("failQueuedJob - clipSegments error: %v", getErr)
+		}
+		vs.failClips(&ClipJob{ID: job.ID, Segments: segments}, err)
+	case queueKindStoryboard:
+		updateErr = vs.VideoRepo.MarkStoryboardFailed(job.ID, failureCode(err), err.Error())
+	}
+	if updateErr != nil {
+		log.Printf("failQueuedJob - mark failed error: %v", updateErr)
+	}
+}
+
+// requeueStaleJobs puts the jobs of servers that stopped mid-job back in line
+func (vs *VideoService) requeueStaleJobs() {
+	jobs, err := vs.VideoRepo.RequeueStaleJobs(time.Now().Add(-queueStaleBeats * vs.queue.heartbeat))
+	if err != nil {
+		log.Printf("requeueStaleJobs - RequeueStaleJobs error: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		log.Printf("requeueStaleJobs - %s job %s lost its worker, queued again", job.Kind, job.ID)
+		const message = "Queued again, the server running it stopped"
+		var updateErr error
+		switch job.Kind {
+		case queueKindFullVideo, queueKindPlaylistEntry:
+			updateErr = vs.VideoRepo.UpdateDownloadStatus(job.ID, StatusPending, message)
+		case queueKindTimeRange:
+			updateErr = vs.VideoRepo.UpdateTimeRangeDownloadStatus(job.ID, StatusPending, message, "")
+		case queueKindClips:
+			updateErr = vs.VideoRepo.UpdateClipStatus(job.ID, StatusPending, message)
+		case queueKindStoryboard:
+			updateErr = vs.VideoRepo.UpdateStoryboardStatus(job.ID, StatusPending, message)
+		}
+		if updateErr != nil {
+			log.Printf("requeueStaleJobs - UpdateStatus error: %v", updateErr)
+		}
+	}
+	if len(jobs) > 0 {
+		vs.queue.notify()
+	}
+}
+
+// cancelQueued cancels a queued job wherever it stands. A waiting job is taken off the queue and
+// dequeued is true, the caller marks its row cancelled. A running job is flagged for the worker
+// running it, possibly on another server, and stopped right away when it runs on this one; the
+// job then marks its row. ErrDownloadNotRunning when the job is neither waiting nor running or
+// is already recording its result.
+func (vs *VideoService) cancelQueued(id string) (dequeued bool, err error) {
+	dequeued, err = vs.VideoRepo.CancelQueuedJob(id)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel queued job: %w", err)
+	}
+	if dequeued {
+		return true, nil
+	}
+
+	job, err := vs.VideoRepo.RequestQueuedJobCancel(id)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel queued job: %w", err)
+	}
+	if job == nil {
+		return false, ErrDownloadNotRunning
+	}
+	// A job claimed here but not registered yet sees the flag in startQueuedJob
+	if job.WorkerID == vs.queue.workerID && !vs.jobs.cancel(id) && vs.jobs.finishing(id) {
+		return false, ErrDownloadNotRunning
+	}
+	return false, nil
+}
+
+// queueStatus returns where a job waits in the queue, nil when it is not waiting
+func (vs *VideoService) queueStatus(id string) (*QueueStatus, error) {
+	job, err := vs.VideoRepo.GetQueuedJob(id)
+	if err != nil || job.Status != queueStatusPending {
+		// Not waiting, or a clip segment, which waits with its clip job
+		return nil, nil
+	}
+	ahead, err := vs.VideoRepo.CountQueuedJobsBefore(job.EnqueuedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count queued jobs: %w", err)
+	}
+	running, err := vs.VideoRepo.CountRunningJobs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count running jobs: %w", err)
+	}
+	return &QueueStatus{
+		Position:       ahead + 1,
+		EstimatedStart: time.Now().Add(estimatedWait(ahead, running, vs.queue.workers, vs.averageJobTime())),
+	}, nil
+}
+
+// estimatedWait guesses how long a job with ahead jobs before it waits. Every other server may
+// run workers too, running jobs tell at least how many slots there are besides this server's
+// workers. Slots free up after an average job, each round starts one job per slot.
+func estimatedWait(ahead, running, workers int, average time.Duration) time.Duration {
+	slots := max(workers, running)
+	free := slots - running
+	if ahead < free {
+		// A worker is about to claim it
+		return 0
+	}
+	rounds := (ahead-free)/slots + 1
+	return time.Duration(rounds) * average
+}
+
+// averageJobTime is how long recent jobs ran, the configured default before any finished
+func (vs *VideoService) averageJobTime() time.Duration {
+	jobs, err := vs.VideoRepo.RecentFinishedJobs(queueEstimateSample)
+	if err != nil {
+		log.Printf("averageJobTime - RecentFinishedJobs error: %v", err)
+		return vs.queue.defaultJobTime
+	}
+	var total time.Duration
+	var n int
+	for _, job := range jobs {
+		if job.StartedAt != nil && job.FinishedAt != nil && job.FinishedAt.After(*job.StartedAt) {
+			total += job.FinishedAt.Sub(*job.StartedAt)
+			n++
+		}
+	}
+	if n == 0 {
+		return vs.queue.defaultJobTime
+	}
+	return total / time.Duration(n)
+}
+
+// withQueueStatus adds the queue position and estimated start to the status of a waiting job
+func (vs *VideoService) withQueueStatus(id string, status map[string]interface{}) {
+	if s, _ := status["status"].(string); s != StatusPending {
+		return
+	}
+	qs, err := vs.queueStatus(id)
+	if err != nil {
+		log.Printf("withQueueStatus - queueStatus error: %v", err)
+		return
+	}
+	if qs == nil {
+		return
+	}
+	status["queue_position"] = qs.Position
+	status["estimated_start"] = qs.EstimatedStart.UTC().Format(time.RFC3339)
+}
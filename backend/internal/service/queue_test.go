@@ -0,0 +1,38 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimatedWait(t *testing.T) {
+	const average = 2 * time.Minute
+	tests := []struct {
+		name    string
+		ahead   int
+		running int
+		workers int
+		want    time.Duration
+	}{
+		{name: "idle queue starts right away", ahead: 0, running: 0, workers: 4, want: 0},
+		{name: "free workers take the jobs ahead too", ahead: 3, running: 0, workers: 4, want: 0},
+		{name: "one more than free workers waits a round", ahead: 4, running: 0, workers: 4, want: average},
+		{name: "busy workers, next in line", ahead: 0, running: 4, workers: 4, want: average},
+		{name: "busy workers, second round", ahead: 4, running: 4, workers: 4, want: 2 * average},
+		{name: "some workers free", ahead: 1, running: 2, workers: 4, want: 0},
+		{name: "behind the free workers", ahead: 2, running: 2, workers: 4, want: average},
+		{name: "round filled after the free workers", ahead: 5, running: 2, workers: 4, want: average},
+		{name: "next round after the free workers", ahead: 6, running: 2, workers: 4, want: 2 * average},
+		{name: "other servers add slots", ahead: 9, running: 10, workers: 4, want: average},
+		{name: "other servers' slots fill too", ahead: 10, running: 10, workers: 4, want: 2 * average},
+		{name: "single worker", ahead: 2, running: 1, workers: 1, want: 3 * average},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimatedWait(tt.ahead, tt.running, tt.workers, average); got != tt.want {
+				t.Errorf("estimatedWait(%d, %d, %d) = %v, want %v", tt.ahead, tt.running, tt.workers, got, tt.want)
+			}
+		})
+	}
+}
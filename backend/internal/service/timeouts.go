@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+	"github.com/verse91/ytb-clipy/backend/pkg/utils"
+)
+
+// Per job type limits, in seconds. 0 disables a limit. They are read when a job starts rather
+// than at package init, which runs before main loads the .env file.
+
+func fullVideoTimeouts() downloader.Timeouts {
+	return downloader.Timeouts{
+		Total: envSeconds("FULL_VIDEO_TIMEOUT", 2*60*60),
+		Stall: envSeconds("FULL_VIDEO_STALL_TIMEOUT", 5*60),
+	}
+}
+
+func timeRangeTimeouts() downloader.Timeouts {
+	return downloader.Timeouts{
+		Total: envSeconds("TIME_RANGE_TIMEOUT", 30*60),
+		Stall: envSeconds("TIME_RANGE_STALL_TIMEOUT", 5*60),
+	}
+}
+
+// Multi-range jobs fetch every segment in one run, so they get a longer overall limit
+func clipTimeouts() downloader.Timeouts {
+	return downloader.Timeouts{
+		Total: envSeconds("CLIP_TIMEOUT", 60*60),
+		Stall: envSeconds("CLIP_STALL_TIMEOUT", 5*60),
+	}
+}
+
+// Storyboards decode the whole source, but only a small stream of it
+func storyboardTimeouts() downloader.Timeouts {
+	return downloader.Timeouts{
+		Total: envSeconds("STORYBOARD_TIMEOUT", 30*60),
+	}
+}
+
+// probeTimeout bounds the metadata lookups requests wait on
+func probeTimeout() time.Duration {
+	return envSeconds("PROBE_TIMEOUT", 60)
+}
+
+// snapshotTimeout bounds a single frame grab, requests wait on it as well
+func snapshotTimeout() time.Duration {
+	return envSeconds("SNAPSHOT_TIMEOUT", 60)
+}
+
+// waveformTimeout bounds decoding the audio for waveform peaks, requests wait on it too
+func waveformTimeout() time.Duration {
+	return envSeconds("WAVEFORM_TIMEOUT", 5*60)
+}
+
+// withTimeout bounds ctx by timeout, a timeout of 0 leaves it unbounded
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func envSeconds(key string, defaultSeconds int) time.Duration {
+	return time.Duration(utils.GetEnvAsInt(key, defaultSeconds)) * time.Second
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidTimeRange is returned for time ranges that cannot be parsed or are out of bounds
+var ErrInvalidTimeRange = errors.New("invalid time range")
+
+// maxTimestampSeconds bounds parsed timestamps well past any real video, so they fit in milliseconds
+const maxTimestampSeconds = 1_000_000
+
+// TimeRangeInput is a time range as the client typed it, every field may be empty.
+// Start falls back to the t parameter of the video URL and then to 0, the end can be given
+// as End or as Duration from the start. Accepted forms: "1:02:03.250", "02:03", "123.25",
+// "1h2m3s" and plain seconds. Chapters replaces all three, the range is then taken from the
+// video's chapter markers.
+type TimeRangeInput struct {
+	Start    string
+	End      string
+	Duration string
+	Chapters []ChapterSelector
+}
+
+// TimeRange is a normalized time range, in milliseconds
+type TimeRange struct {
+	StartMs int64
+	EndMs   int64
+}
+
+// StartSeconds returns the start in seconds, keeping the millisecond fraction
+func (r TimeRange) StartSeconds() float64 {
+	return float64(r.StartMs) / 1000
+}
+
+// EndSeconds returns the end in seconds, keeping the millisecond fraction
+func (r TimeRange) EndSeconds() float64 {
+	return float64(r.EndMs) / 1000
+}
+
+var (
+	decimalSecondsPattern = regexp.MustCompile(`^\d+(?:\.\d+)?$`)
+	unitTimestampPattern  = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+(?:\.\d+)?)s)?$`)
+)
+
+// normalizeTimeRange resolves a requested range against the video URL it was pasted with
+func normalizeTimeRange(videoURL string, input TimeRangeInput) (TimeRange, error) {
+	var rng TimeRange
+
+	start, field := strings.TrimSpace(input.Start), "start_time"
+	if start == "" {
+		start, field = urlStartTime(videoURL), "url t parameter"
+	}
+	if start != "" {
+		ms, err := parseTimestamp(start)
+		if err != nil {
+			return rng, fmt.Errorf("%w: %s: %v", ErrInvalidTimeRange, field, err)
+		}
+		rng.StartMs = ms
+	}
+
+	end, duration := strings.TrimSpace(input.End), strings.TrimSpace(input.Duration)
+	switch {
+	case end != "" && duration != "":
+		return rng, fmt.Errorf("%w: give either end_time or duration, not both", ErrInvalidTimeRange)
+	case end != "":
+		ms, err := parseTimestamp(end)
+		if err != nil {
+			return rng, fmt.Errorf("%w: end_time: %v", ErrInvalidTimeRange, err)
+		}
+		rng.EndMs = ms
+	case duration != "":
+		ms, err := parseTimestamp(duration)
+		if err != nil {
+			return rng, fmt.Errorf("%w: duration: %v", ErrInvalidTimeRange, err)
+		}
+		rng.EndMs = rng.StartMs + ms
+	default:
+		return rng, fmt.Errorf("%w: end_time or duration is required", ErrInvalidTimeRange)
+	}
+
+	return rng, rng.validate()
+}
+
+// validate checks the bounds every time range must keep, however it was given
+func (r TimeRange) validate() error {
+	if r.EndMs <= r.StartMs {
+		return fmt.Errorf("%w: end_time must be after start_time", ErrInvalidTimeRange)
+	}
+	if r.EndMs-r.StartMs > MaxClipDurationSeconds*1000 {
+		return fmt.Errorf("%w: clip duration cannot exceed %d seconds", ErrInvalidTimeRange, MaxClipDurationSeconds)
+	}
+	return nil
+}
+
+// parseTimestamp reads a timestamp in one of the TimeRangeInput forms, rounded to milliseconds
+func parseTimestamp(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	var seconds float64
+	switch {
+	case strings.Contains(value, ":"):
+		parts := strings.Split(value, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("%q has too many fields, expected HH:MM:SS.mmm", value)
+		}
+		for i, part := range parts {
+			last := i == len(parts)-1
+			if part == "" || (!last && strings.Contains(part, ".")) || !decimalSecondsPattern.MatchString(part) {
+				return 0, fmt.Errorf("%q is not a valid HH:MM:SS.mmm timestamp", value)
+			}
+			n, _ := strconv.ParseFloat(part, 64)
+			// Only the leading field may run past 59
+			if i > 0 && n >= 60 {
+				return 0, fmt.Errorf("%q has a field of 60 or more", value)
+			}
+			seconds = seconds*60 + n
+		}
+	case decimalSecondsPattern.MatchString(value):
+		seconds, _ = strconv.ParseFloat(value, 64)
+	default:
+		m := unitTimestampPattern.FindStringSubmatch(value)
+		if m == nil || value == "" {
+			return 0, fmt.Errorf("%q is not a valid timestamp, use HH:MM:SS.mmm, seconds or 1h2m3s", value)
+		}
+		for i, unit := range []float64{3600, 60, 1} {
+			if m[i+1] != "" {
+				n, _ := strconv.ParseFloat(m[i+1], 64)
+				seconds += n * unit
+			}
+		}
+	}
+
+	if seconds > maxTimestampSeconds {
+		return 0, fmt.Errorf("%q is too large", value)
+	}
+	return int64(math.Round(seconds * 1000)), nil
+}
+
+// urlStartTime returns the t parameter of a pasted video URL ("?t=1h2m3s", "&t=90", "#t=1m30s"),
+// empty when it has none
+func urlStartTime(videoURL string) string {
+	parsed, err := url.Parse(videoURL)
+	if err != nil {
+		return ""
+	}
+	if t := parsed.Query().Get("t"); t != "" {
+		return t
+	}
+	if fragment, err := url.ParseQuery(parsed.Fragment); err == nil {
+		return fragment.Get("t")
+	}
+	return ""
+}
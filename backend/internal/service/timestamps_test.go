@@ -0,0 +1,56 @@
+package service
+
+import "testing"
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "0", want: 0},
+		{value: "123", want: 123000},
+		{value: "123.25", want: 123250},
+		{value: "0.0005", want: 1},
+		{value: " 42 ", want: 42000},
+		{value: "02:03", want: 123000},
+		{value: "1:02:03.250", want: 3723250},
+		{value: "1:02:03,250", wantErr: true},
+		{value: "90:00", want: 5400000},
+		{value: "00:00:00.001", want: 1},
+		{value: "1h2m3s", want: 3723000},
+		{value: "1H2M3S", want: 3723000},
+		{value: "2m30.5s", want: 150500},
+		{value: "45s", want: 45000},
+		{value: "1h", want: 3600000},
+		{value: "", wantErr: true},
+		{value: "abc", wantErr: true},
+		{value: "-5", wantErr: true},
+		{value: "1:60", wantErr: true},
+		{value: "1:60:00", wantErr: true},
+		{value: "1:2:3:4", wantErr: true},
+		{value: "1.5:00", wantErr: true},
+		{value: "1::00", wantErr: true},
+		{value: "1m2h", wantErr: true},
+		{value: "1000000", want: 1000000000},
+		{value: "1000001", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseTimestamp(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTimestamp(%q) = %d, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTimestamp(%q) error: %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseTimestamp(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/supabase-community/supabase-go"
@@ -14,6 +15,8 @@ import (
 var (
 	ErrInvalidArgument = errors.New("invalid argument")
 	ErrUserNotFound    = errors.New("user not found")
+	// ErrInsufficientCredits is returned when a user's balance cannot pay for a job
+	ErrInsufficientCredits = errors.New("insufficient credits")
 )
 
 type UserService struct {
@@ -112,6 +115,51 @@ func (us *UserService) AddUserCredits(userID string, credits int) error {
 	return nil
 }
 
+// SpendUserCredits takes credits from a user's balance, failing with ErrInsufficientCredits when it is too low.
+// The update only applies while the balance is unchanged, so concurrent spends cannot both use the same credits.
+func (us *UserService) SpendUserCredits(userID string, credits int) error {
+	if us.supabaseClient == nil {
+		return fmt.Errorf("supabase client not initialized")
+	}
+
+	if userID == "" {
+		return fmt.Errorf("%w: userID cannot be empty", ErrInvalidArgument)
+	}
+
+	if credits < 0 {
+		return fmt.Errorf("invalid credit value: credits cannot be negative")
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		currentCredits, err := us.GetUserCredits(userID)
+		if err != nil {
+			return fmt.Errorf("failed to get current credits: %w", err)
+		}
+		if currentCredits < credits {
+			return fmt.Errorf("%w: %d needed, %d available", ErrInsufficientCredits, credits, currentCredits)
+		}
+
+		updateData := map[string]interface{}{
+			"credits": currentCredits - credits,
+		}
+		resp, _, err := us.supabaseClient.From("profiles").
+			Update(updateData, "representation", "").
+			Eq("id", userID).
+			Eq("credits", strconv.Itoa(currentCredits)).
+			Execute()
+		if err != nil {
+			return fmt.Errorf("failed to spend user credits: %w", err)
+		}
+
+		// No row back means the balance changed since it was read, try again
+		var updated []json.RawMessage
+		if err := json.Unmarshal(resp, &updated); err == nil && len(updated) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to spend user credits: balance kept changing")
+}
+
 // createUserProfile creates a new profile for a user with 0 credits
 func (us *UserService) createUserProfile(userID string) error {
 	data := map[string]interface{}{
@@ -1,20 +1,56 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/verse91/ytb-clipy/backend/internal/model"
 	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
 )
 
 // Status constants for download operations
 const (
-	StatusPending   = "pending"
-	StatusCompleted = "completed"
-	StatusFailed    = "failed"
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+	StatusPartial    = "partial" // playlists and clip jobs whose children finished with mixed results
+)
+
+// Failure codes stored in error_code so clients can tell timeouts apart from extractor errors
+const (
+	FailureTimeout      = "timeout"       // the job ran past its overall limit
+	FailureStallTimeout = "stall_timeout" // yt-dlp stopped reporting anything
+	FailureDownload     = "download_error"
+	FailureCredits      = "insufficient_credits" // a playlist child the user could not pay for
+)
+
+var (
+	// ErrDownloadNotRunning is returned when cancelling a job that already finished
+	ErrDownloadNotRunning = errors.New("download is not running")
+	// ErrDownloadForbidden is returned when a user asks for another user's (or an anonymous) job's file
+	ErrDownloadForbidden = errors.New("download belongs to another user")
+	// ErrFileNotReady is returned while a job has not produced its file
+	ErrFileNotReady = errors.New("download file is not ready")
+	// ErrFileGone is returned when a completed job's file is no longer on disk
+	ErrFileGone = errors.New("download file no longer exists")
+	// ErrProbeFailed is returned when yt-dlp could not be run to read the video's metadata
+	ErrProbeFailed = errors.New("failed to read video metadata")
+	// ErrVideoUnavailable is returned when yt-dlp ran but could not read the url
+	ErrVideoUnavailable = errors.New("video unavailable")
+	// ErrEndPastDuration is returned when a time range ends after the video does
+	ErrEndPastDuration = errors.New("end time is past the end of the video")
 )
 
 // Validation constants
@@ -24,24 +60,75 @@ const (
 
 // VideoRepository interface defines the contract for video repository operations
 type VideoRepository interface {
-	CreateDownloadRequest(id, url string) error
+	CreateDownloadRequest(id, url, userID string, opts model.JobOptions) error
 	UpdateDownloadStatus(id, status, errorMsg string) error
-	GetStatus(id string) (string, error)
-	CreateTimeRangeDownloadRequest(id, url string, startSec, endSec int) error
+	MarkDownloadFailed(id, errorCode, errorMsg string) error
+	UpdateDownloadProgress(id string, progress model.JobProgress) error
+	SaveDownloadResult(id string, result model.JobResult) error
+	GetStatus(id string) (map[string]interface{}, error)
+	CreateTimeRangeDownloadRequest(id, url, userID string, startSec, endSec float64, opts model.JobOptions) error
 	UpdateTimeRangeDownloadStatus(id, status, errorMsg, outputPath string) error
+	MarkTimeRangeDownloadFailed(id, errorCode, errorMsg string) error
+	UpdateTimeRangeDownloadProgress(id string, progress model.JobProgress) error
+	SaveTimeRangeDownloadResult(id string, result model.JobResult) error
 	GetTimeRangeDownloadStatus(id string) (map[string]interface{}, error)
+	CreatePlaylistRequest(id, url, userID, title string, entryCount int) error
+	CreatePlaylistEntryRequest(id, url, userID, playlistID string, index int, opts model.JobOptions) error
+	GetPlaylist(id string) (map[string]interface{}, error)
+	GetPlaylistEntries(playlistID string) ([]map[string]interface{}, error)
+	CreateClipRequest(id, url, userID, output string, segmentCount int) error
+	CreateClipSegmentRequest(id, url, userID, clipJobID string, index int, name string, startSec, endSec int, opts model.JobOptions) error
+	UpdateClipStatus(id, status, errorMsg string) error
+	MarkClipFailed(id, errorCode, errorMsg string) error
+	SaveClipResult(id string, result model.JobResult) error
+	GetClip(id string) (map[string]interface{}, error)
+	GetClipSegments(clipJobID string) ([]map[string]interface{}, error)
+	CreateWatermark(wm model.Watermark) error
+	GetWatermark(id string) (*model.Watermark, error)
+	ListWatermarks(userID string) ([]model.Watermark, error)
+	DeleteWatermark(id string) error
+	CountUnfinishedWatermarkJobs(id string) (int, error)
+	CreateStoryboardRequest(id, url, userID string, opts model.StoryboardOptions) error
+	UpdateStoryboardStatus(id, status, errorMsg string) error
+	MarkStoryboardFailed(id, errorCode, errorMsg string) error
+	SaveStoryboardResult(id string, result model.StoryboardResult) error
+	GetStoryboard(id string) (map[string]interface{}, error)
+	EnqueueJob(job model.QueuedJob) error
+	ClaimQueuedJob(workerID string) (*model.QueuedJob, error)
+	HeartbeatQueuedJobs(workerID string) ([]model.QueuedJob, error)
+	FinishQueuedJob(id, workerID string) error
+	CancelQueuedJob(id string) (bool, error)
+	RequestQueuedJobCancel(id string) (*model.QueuedJob, error)
+	UpdateQueuedJobPayload(id string, payload json.RawMessage) error
+	RequeueStaleJobs(before time.Time) ([]model.QueuedJob, error)
+	GetQueuedJob(id string) (*model.QueuedJob, error)
+	CountQueuedJobsBefore(t time.Time) (int, error)
+	CountRunningJobs() (int, error)
+	RecentFinishedJobs(limit int) ([]model.QueuedJob, error)
+	PurgeFinishedJobs(before time.Time) (int, error)
 }
 
 type VideoService struct {
 	VideoRepo VideoRepository
+	Credits   CreditStore
+	jobs      *jobRegistry
+	queue     *jobQueue
+	previews  *previewLimiter
 }
 
-func NewVideoService(videoRepo VideoRepository) *VideoService {
+func NewVideoService(videoRepo VideoRepository, credits CreditStore) *VideoService {
 	if videoRepo == nil {
 		log.Fatal("VideoRepository cannot be nil")
 	}
+	if credits == nil {
+		log.Fatal("CreditStore cannot be nil")
+	}
 	return &VideoService{
 		VideoRepo: videoRepo,
+		Credits:   credits,
+		jobs:      newJobRegistry(),
+		queue:     newJobQueue(),
+		previews:  newPreviewLimiter(),
 	}
 }
 
@@ -79,93 +166,308 @@ func (vs *VideoService) validateURL(videoURL string) (string, error) {
 	return parsedURL.String(), nil
 }
 
-func (vs *VideoService) DownloadFullVideo(videoURL string) (string, error) {
+// DownloadFullVideo queues a full video job, userID is the owner and may be empty for anonymous requests
+func (vs *VideoService) DownloadFullVideo(videoURL, userID string, rawOpts DownloadOptions) (string, error) {
 	validatedURL, err := vs.validateURL(videoURL)
 	if err != nil {
 		return "", fmt.Errorf("invalid video URL: %w", err)
 	}
 
-	// Generate a temporary ID for tracking - the database will generate the real UUID
+	_, record, err := vs.fullVideoOptions(rawOpts, userID)
+	if err != nil {
+		return "", err
+	}
+
+	// Generate the ID up front so the row and the queued job share it
 	tempID := uuid.New().String()
 
 	// Store the download request in repository
-	if err := vs.VideoRepo.CreateDownloadRequest(tempID, validatedURL); err != nil {
+	if err := vs.VideoRepo.CreateDownloadRequest(tempID, validatedURL, userID, record); err != nil {
 		log.Printf("DownloadFullVideo - CreateDownloadRequest error: %v", err)
 		return "", fmt.Errorf("failed to create download request: %w", err)
 	}
 
-	// Start async download
-	go func() {
-		if err := downloader.FullVideoFHD(validatedURL); err != nil {
-			// Update status in repository with error logging
-			if updateErr := vs.VideoRepo.UpdateDownloadStatus(tempID, StatusFailed, err.Error()); updateErr != nil {
-				log.Printf("DownloadFullVideo - UpdateDownloadStatus error: %v", updateErr)
-			}
-			return
+	// A worker starts the download once one is free
+	if err := vs.enqueue(queueKindFullVideo, tempID, validatedURL, userID, queuePayload{Options: rawOpts}); err != nil {
+		if updateErr := vs.VideoRepo.MarkDownloadFailed(tempID, FailureDownload, err.Error()); updateErr != nil {
+			log.Printf("DownloadFullVideo - MarkDownloadFailed error: %v", updateErr)
+		}
+		return "", err
+	}
+
+	return tempID, nil
+}
+
+// fullVideoOptions validates the options of a full video job. It runs when the job is queued
+// and again when a worker claims it, the stored watermark may be gone by then.
+func (vs *VideoService) fullVideoOptions(rawOpts DownloadOptions, userID string) (downloader.Options, model.JobOptions, error) {
+	if rawOpts.CutPrecision != "" {
+		return downloader.Options{}, model.JobOptions{}, fmt.Errorf("%w: cut_precision only applies to time range jobs", ErrInvalidCutPrecision)
+	}
+	if rawOpts.hasAnimation() {
+		return downloader.Options{}, model.JobOptions{}, fmt.Errorf("%w: animated output only applies to time range jobs", ErrInvalidAnimation)
+	}
+	opts, record, err := vs.validateOptions(rawOpts)
+	if err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	if err := rejectReframe(rawOpts, opts); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	if err := vs.withWatermark(rawOpts.WatermarkID, userID, &opts, &record); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	opts.Timeouts = fullVideoTimeouts()
+	return opts, record, nil
+}
+
+// runFullVideo runs a full video job to the end and records how it finished
+func (vs *VideoService) runFullVideo(ctx context.Context, downloadID, videoURL string, opts downloader.Options) {
+	defer vs.jobs.finish(downloadID)
+
+	onProgress := func(p downloader.Progress) {
+		if err := vs.VideoRepo.UpdateDownloadProgress(downloadID, jobProgressRecord(p)); err != nil {
+			log.Printf("DownloadFullVideo - UpdateDownloadProgress error: %v", err)
 		}
-		if updateErr := vs.VideoRepo.UpdateDownloadStatus(tempID, StatusCompleted, ""); updateErr != nil {
+	}
+	result, err := downloader.FullVideoFHD(ctx, videoURL, downloadID, opts, onProgress)
+	if claimLost(ctx) {
+		// The server that took the job over records it, a finished file is dropped with the rest
+		if err == nil {
+			os.Remove(result.Path)
+		}
+		return
+	}
+	if err == nil && !vs.jobs.seal(downloadID) {
+		// Cancelled while the last step was finishing, drop the file like any cancelled job's
+		os.Remove(result.Path)
+		err = context.Canceled
+	}
+	if errors.Is(err, context.Canceled) {
+		if updateErr := vs.VideoRepo.UpdateDownloadStatus(downloadID, StatusCancelled, "Download cancelled"); updateErr != nil {
 			log.Printf("DownloadFullVideo - UpdateDownloadStatus error: %v", updateErr)
 		}
-	}()
+		return
+	}
+	if err != nil {
+		// Update status in repository with error logging
+		if updateErr := vs.VideoRepo.MarkDownloadFailed(downloadID, failureCode(err), err.Error()); updateErr != nil {
+			log.Printf("DownloadFullVideo - MarkDownloadFailed error: %v", updateErr)
+		}
+		return
+	}
+	if saveErr := vs.VideoRepo.SaveDownloadResult(downloadID, jobResultRecord(result)); saveErr != nil {
+		log.Printf("DownloadFullVideo - SaveDownloadResult error: %v", saveErr)
+	}
+	if updateErr := vs.VideoRepo.UpdateDownloadStatus(downloadID, StatusCompleted, result.Notice); updateErr != nil {
+		log.Printf("DownloadFullVideo - UpdateDownloadStatus error: %v", updateErr)
+	}
+}
 
-	return tempID, nil
+// ProbeVideo returns a video's metadata and available formats without downloading it
+func (vs *VideoService) ProbeVideo(videoURL string) (*downloader.VideoInfo, error) {
+	validatedURL, err := vs.validateURL(videoURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid video URL: %w", err)
+	}
+
+	ctx, cancel := withTimeout(context.Background(), probeTimeout())
+	defer cancel()
+	info, err := downloader.ProbeVideo(ctx, validatedURL)
+	if err != nil {
+		return nil, probeError(err)
+	}
+	return info, nil
+}
+
+// probeError tells a url yt-dlp could not read, the client's mistake, from a probe that failed
+// on our side or timed out
+func probeError(err error) error {
+	if errors.Is(err, downloader.ErrUnavailable) {
+		return fmt.Errorf("%w: %v", ErrVideoUnavailable, err)
+	}
+	return fmt.Errorf("%w: %v", ErrProbeFailed, err)
 }
 
-func (vs *VideoService) GetDownloadStatus(downloadID string) (string, error) {
+func (vs *VideoService) GetDownloadStatus(downloadID string) (map[string]interface{}, error) {
 	if downloadID == "" {
-		return "", fmt.Errorf("download ID cannot be empty")
+		return nil, fmt.Errorf("download ID cannot be empty")
 	}
 
 	status, err := vs.VideoRepo.GetStatus(downloadID)
 	if err != nil {
-		return "", fmt.Errorf("failed to get download status: %w", err)
+		return nil, fmt.Errorf("failed to get download status: %w", err)
 	}
+	publicStatus(status)
+	vs.withQueueStatus(downloadID, status)
 
 	return status, nil
 }
 
+// TimeRangeJob is a started time range download and the range it was normalized to
+type TimeRangeJob struct {
+	ID       string
+	Range    TimeRange
+	Chapters []downloader.Chapter // the chapters the range was selected by, if any
+}
+
 // Time Range Download Methods
-func (vs *VideoService) DownloadVideoTimeRange(videoURL string, startSec, endSec int) (string, error) {
+func (vs *VideoService) DownloadVideoTimeRange(videoURL, userID string, input TimeRangeInput, rawOpts DownloadOptions) (*TimeRangeJob, error) {
 	validatedURL, err := vs.validateURL(videoURL)
 	if err != nil {
-		return "", fmt.Errorf("invalid video URL: %w", err)
+		return nil, fmt.Errorf("invalid video URL: %w", err)
 	}
 
-	// Validate time range with reasonable bounds
-	if startSec < 0 {
-		return "", fmt.Errorf("invalid time range: startSec must be >= 0")
+	// Chapters are only known from the metadata, so those requests probe first
+	var (
+		info     *downloader.VideoInfo
+		rng      TimeRange
+		chapters []downloader.Chapter
+	)
+	if len(input.Chapters) > 0 {
+		if info, err = vs.ProbeVideo(validatedURL); err != nil {
+			return nil, err
+		}
+		rng, chapters, err = chapterTimeRange(info, input)
+	} else {
+		// Timestamps come in several notations, bring them to milliseconds before anything else
+		rng, err = normalizeTimeRange(validatedURL, input)
 	}
-	if endSec <= startSec {
-		return "", fmt.Errorf("invalid time range: endSec must be > startSec")
+	if err != nil {
+		return nil, err
 	}
-	if endSec-startSec > MaxClipDurationSeconds {
-		return "", fmt.Errorf("invalid time range: clip duration cannot exceed %d seconds", MaxClipDurationSeconds)
+	startSec, endSec := rng.StartSeconds(), rng.EndSeconds()
+
+	_, record, err := vs.timeRangeOptions(rawOpts, userID, startSec, endSec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the range against the real video rather than failing inside yt-dlp. Without the
+	// metadata at hand the worker checks it, so the request does not wait on a probe.
+	if info != nil {
+		if err := checkRangeEnd(info, endSec); err != nil {
+			return nil, err
+		}
 	}
 
 	// Generate a temporary ID for tracking
 	tempID := uuid.New().String()
 
 	// Store the download request in repository
-	if err := vs.VideoRepo.CreateTimeRangeDownloadRequest(tempID, validatedURL, startSec, endSec); err != nil {
+	if err := vs.VideoRepo.CreateTimeRangeDownloadRequest(tempID, validatedURL, userID, startSec, endSec, record); err != nil {
 		log.Printf("DownloadVideoTimeRange - CreateTimeRangeDownloadRequest error: %v", err)
-		return "", fmt.Errorf("failed to create time range download request: %w", err)
+		return nil, fmt.Errorf("failed to create time range download request: %w", err)
 	}
 
-	// Start async download and processing
-	go func() {
-		if err := downloader.TimeRangeFHD(validatedURL, startSec, endSec, tempID); err != nil {
-			// Update status in repository with error logging
-			if updateErr := vs.VideoRepo.UpdateTimeRangeDownloadStatus(tempID, StatusFailed, err.Error(), ""); updateErr != nil {
-				log.Printf("DownloadVideoTimeRange - UpdateTimeRangeDownloadStatus error: %v", updateErr)
-			}
-			return
+	// A worker starts the download once one is free
+	payload := queuePayload{Options: rawOpts, StartSec: startSec, EndSec: endSec, CheckEnd: info == nil}
+	if err := vs.enqueue(queueKindTimeRange, tempID, validatedURL, userID, payload); err != nil {
+		if updateErr := vs.VideoRepo.MarkTimeRangeDownloadFailed(tempID, FailureDownload, err.Error()); updateErr != nil {
+			log.Printf("DownloadVideoTimeRange - MarkTimeRangeDownloadFailed error: %v", updateErr)
 		}
-		if updateErr := vs.VideoRepo.UpdateTimeRangeDownloadStatus(tempID, StatusCompleted, "", ""); updateErr != nil {
-			log.Printf("DownloadVideoTimeRange - UpdateTimeRangeDownloadStatus error: %v", updateErr)
+		return nil, err
+	}
+
+	return &TimeRangeJob{ID: tempID, Range: rng, Chapters: chapters}, nil
+}
+
+// timeRangeOptions validates the options of a time range job, when it is queued and again when
+// a worker claims it
+func (vs *VideoService) timeRangeOptions(rawOpts DownloadOptions, userID string, startSec, endSec float64) (downloader.Options, model.JobOptions, error) {
+	opts, record, err := vs.validateOptions(rawOpts)
+	if err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	if err := withCutPrecision(rawOpts.CutPrecision, &opts, &record); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	if err := withReframe(rawOpts, &opts, &record); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	if err := vs.withWatermark(rawOpts.WatermarkID, userID, &opts, &record); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	if err := vs.withAnimation(rawOpts, endSec-startSec, &opts, &record); err != nil {
+		return downloader.Options{}, model.JobOptions{}, err
+	}
+	opts.Timeouts = timeRangeTimeouts()
+	return opts, record, nil
+}
+
+// checkRangeEnd rejects a range ending after the video, live streams have no end yet
+func checkRangeEnd(info *downloader.VideoInfo, endSec float64) error {
+	if !info.IsLive && info.Duration > 0 && endSec > info.Duration {
+		return fmt.Errorf("%w: end_time is %s but the video is %.0f seconds long", ErrEndPastDuration, strconv.FormatFloat(endSec, 'f', -1, 64), info.Duration)
+	}
+	return nil
+}
+
+// probeRangeEnd probes the video of a queued time range job to check where it ends
+func probeRangeEnd(ctx context.Context, videoURL string, endSec float64) error {
+	ctx, cancel := withTimeout(ctx, probeTimeout())
+	defer cancel()
+	info, err := downloader.ProbeVideo(ctx, videoURL)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return err
 		}
-	}()
+		return probeError(err)
+	}
+	return checkRangeEnd(info, endSec)
+}
 
-	return tempID, nil
+// runTimeRange runs a time range job to the end and records how it finished. checkEnd probes
+// the video first for jobs whose range was not checked when they were queued.
+func (vs *VideoService) runTimeRange(ctx context.Context, downloadID, videoURL string, startSec, endSec float64, checkEnd bool, opts downloader.Options) {
+	defer vs.jobs.finish(downloadID)
+
+	onProgress := func(p downloader.Progress) {
+		if err := vs.VideoRepo.UpdateTimeRangeDownloadProgress(downloadID, jobProgressRecord(p)); err != nil {
+			log.Printf("DownloadVideoTimeRange - UpdateTimeRangeDownloadProgress error: %v", err)
+		}
+	}
+	var (
+		result *downloader.Result
+		err    error
+	)
+	if checkEnd {
+		err = probeRangeEnd(ctx, videoURL, endSec)
+	}
+	if err == nil {
+		result, err = downloader.TimeRangeFHD(ctx, videoURL, startSec, endSec, downloadID, opts, onProgress)
+	}
+	if claimLost(ctx) {
+		// The server that took the job over records it, a finished file is dropped with the rest
+		if err == nil {
+			os.Remove(result.Path)
+		}
+		return
+	}
+	if err == nil && !vs.jobs.seal(downloadID) {
+		// Cancelled while the last step was finishing, drop the file like any cancelled job's
+		os.Remove(result.Path)
+		err = context.Canceled
+	}
+	if errors.Is(err, context.Canceled) {
+		if updateErr := vs.VideoRepo.UpdateTimeRangeDownloadStatus(downloadID, StatusCancelled, "Download cancelled", ""); updateErr != nil {
+			log.Printf("DownloadVideoTimeRange - UpdateTimeRangeDownloadStatus error: %v", updateErr)
+		}
+		return
+	}
+	if err != nil {
+		// Update status in repository with error logging
+		if updateErr := vs.VideoRepo.MarkTimeRangeDownloadFailed(downloadID, failureCode(err), err.Error()); updateErr != nil {
+			log.Printf("DownloadVideoTimeRange - MarkTimeRangeDownloadFailed error: %v", updateErr)
+		}
+		return
+	}
+	if saveErr := vs.VideoRepo.SaveTimeRangeDownloadResult(downloadID, jobResultRecord(result)); saveErr != nil {
+		log.Printf("DownloadVideoTimeRange - SaveTimeRangeDownloadResult error: %v", saveErr)
+	}
+	if updateErr := vs.VideoRepo.UpdateTimeRangeDownloadStatus(downloadID, StatusCompleted, result.Notice, result.Path); updateErr != nil {
+		log.Printf("DownloadVideoTimeRange - UpdateTimeRangeDownloadStatus error: %v", updateErr)
+	}
 }
 
 func (vs *VideoService) GetTimeRangeDownloadStatus(downloadID string) (map[string]interface{}, error) {
@@ -177,6 +479,163 @@ func (vs *VideoService) GetTimeRangeDownloadStatus(downloadID string) (map[strin
 	if err != nil {
 		return nil, fmt.Errorf("failed to get time range download status: %w", err)
 	}
+	publicStatus(status)
+	vs.withQueueStatus(downloadID, status)
 
 	return status, nil
 }
+
+// CancelDownload takes a queued full video download off the queue or stops a running one, on
+// whichever server runs it; the job marks running ones cancelled. Only the user who started it
+// can cancel it.
+func (vs *VideoService) CancelDownload(downloadID, userID string) error {
+	if downloadID == "" {
+		return fmt.Errorf("download ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetStatus(downloadID)
+	if err != nil {
+		return fmt.Errorf("failed to get download status: %w", err)
+	}
+	if err := checkOwner(row, userID); err != nil {
+		return err
+	}
+	dequeued, err := vs.cancelQueued(downloadID)
+	if err != nil {
+		return err
+	}
+	if dequeued {
+		if updateErr := vs.VideoRepo.UpdateDownloadStatus(downloadID, StatusCancelled, "Download cancelled"); updateErr != nil {
+			log.Printf("CancelDownload - UpdateDownloadStatus error: %v", updateErr)
+		}
+	}
+	return nil
+}
+
+// CancelTimeRangeDownload takes a queued time range download off the queue or stops a running
+// one, on whichever server runs it; the job marks running ones cancelled. Only the user who
+// started it can cancel it.
+func (vs *VideoService) CancelTimeRangeDownload(downloadID, userID string) error {
+	if downloadID == "" {
+		return fmt.Errorf("download ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetTimeRangeDownloadStatus(downloadID)
+	if err != nil {
+		return fmt.Errorf("failed to get time range download status: %w", err)
+	}
+	if err := checkOwner(row, userID); err != nil {
+		return err
+	}
+	dequeued, err := vs.cancelQueued(downloadID)
+	if err != nil {
+		return err
+	}
+	if dequeued {
+		if updateErr := vs.VideoRepo.UpdateTimeRangeDownloadStatus(downloadID, StatusCancelled, "Download cancelled", ""); updateErr != nil {
+			log.Printf("CancelTimeRangeDownload - UpdateTimeRangeDownloadStatus error: %v", updateErr)
+		}
+	}
+	return nil
+}
+
+// OutputFile is a finished job's file on disk and the name clients should save it as
+type OutputFile struct {
+	Path string
+	Name string
+}
+
+// DownloadFile returns the file of a completed full video download owned by userID
+func (vs *VideoService) DownloadFile(downloadID, userID string) (OutputFile, error) {
+	if downloadID == "" {
+		return OutputFile{}, fmt.Errorf("download ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetStatus(downloadID)
+	if err != nil {
+		return OutputFile{}, fmt.Errorf("failed to get download status: %w", err)
+	}
+	return ownedOutputFile(row, userID)
+}
+
+// TimeRangeDownloadFile returns the file of a completed time range download owned by userID
+func (vs *VideoService) TimeRangeDownloadFile(downloadID, userID string) (OutputFile, error) {
+	if downloadID == "" {
+		return OutputFile{}, fmt.Errorf("download ID cannot be empty")
+	}
+
+	row, err := vs.VideoRepo.GetTimeRangeDownloadStatus(downloadID)
+	if err != nil {
+		return OutputFile{}, fmt.Errorf("failed to get time range download status: %w", err)
+	}
+	return ownedOutputFile(row, userID)
+}
+
+// privateColumns are left out of the job status anyone with the job's ID can read: who started
+// the job, where its output sits on the server and the options it was started with
+var privateColumns = []string{
+	"user_id", "output_file", "output_dir",
+	"quality", "audio_mode", "audio_codec", "audio_bitrate",
+	"sponsorblock", "sponsorblock_categories",
+	"subtitles", "subtitle_languages", "subtitle_auto", "subtitle_format",
+	"thumbnail", "cut_precision", "watermark_id",
+	"output_format", "animation_width", "animation_fps", "animation_loop", "animation_single_pass", "animation_max_bytes",
+	"container", "video_codec", "crf", "encoder_preset",
+	"normalize", "target_lufs", "fade_in", "fade_out", "stereo",
+	"reframe", "reframe_crop_x",
+}
+
+// publicStatus strips privateColumns from a job row
+func publicStatus(row map[string]interface{}) {
+	for _, column := range privateColumns {
+		delete(row, column)
+	}
+}
+
+// checkOwner checks a job row belongs to userID.
+// Jobs started anonymously have no owner, so nobody can act on them through the API.
+func checkOwner(row map[string]interface{}, userID string) error {
+	owner, _ := row["user_id"].(string)
+	if owner == "" || owner != userID {
+		return ErrDownloadForbidden
+	}
+	return nil
+}
+
+// ownedOutputFile checks a job row belongs to userID and has a file on disk
+func ownedOutputFile(row map[string]interface{}, userID string) (OutputFile, error) {
+	if err := checkOwner(row, userID); err != nil {
+		return OutputFile{}, err
+	}
+
+	status, _ := row["status"].(string)
+	path, _ := row["output_file"].(string)
+	if status != StatusCompleted || path == "" {
+		return OutputFile{}, ErrFileNotReady
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return OutputFile{}, ErrFileGone
+	}
+
+	// Rows finished before output names were recorded only have the path
+	name, _ := row["output_name"].(string)
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	return OutputFile{Path: path, Name: name}, nil
+}
+
+// failureCode classifies the error a failed job ended with
+func failureCode(err error) string {
+	switch {
+	case errors.Is(err, downloader.ErrTimeout):
+		return FailureTimeout
+	case errors.Is(err, downloader.ErrStalled):
+		return FailureStallTimeout
+	case errors.Is(err, ErrInsufficientCredits):
+		return FailureCredits
+	}
+	return FailureDownload
+}
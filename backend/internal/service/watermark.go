@@ -0,0 +1,206 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/verse91/ytb-clipy/backend/internal/model"
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+)
+
+var (
+	// ErrInvalidWatermark is returned for watermarks that cannot be stored or used, wrapped with the reason
+	ErrInvalidWatermark = errors.New("invalid watermark")
+	// ErrWatermarkNotFound is returned for unknown watermarks and for other users' watermarks
+	ErrWatermarkNotFound = errors.New("watermark not found")
+	// ErrWatermarkInUse is returned when deleting a watermark that queued or running jobs still need
+	ErrWatermarkInUse = errors.New("watermark is used by unfinished jobs")
+)
+
+// Watermark kinds stored on the row
+const (
+	WatermarkImage = "image"
+	WatermarkText  = "text"
+)
+
+const (
+	// MaxWatermarksPerUser bounds how many watermarks one user can keep
+	MaxWatermarksPerUser   = 20
+	maxWatermarkNameLength = 100
+)
+
+// WatermarkInput is a watermark as uploaded, exactly one of Image (PNG bytes) and Text is set
+type WatermarkInput struct {
+	Name     string
+	Image    []byte
+	Text     string
+	Position string
+	Margin   *int // nil takes the default
+	Opacity  float64
+	Scale    float64
+}
+
+// CreateWatermark validates and stores a watermark for userID, saving its logo next to the outputs
+func (vs *VideoService) CreateWatermark(userID string, input WatermarkInput) (*model.Watermark, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("%w: userID cannot be empty", ErrInvalidArgument)
+	}
+	name := strings.TrimSpace(input.Name)
+	if len(name) > maxWatermarkNameLength {
+		return nil, fmt.Errorf("%w: name must be at most %d characters", ErrInvalidWatermark, maxWatermarkNameLength)
+	}
+	if len(input.Image) > 0 && strings.TrimSpace(input.Text) != "" {
+		return nil, fmt.Errorf("%w: give either an image or a text, not both", ErrInvalidWatermark)
+	}
+
+	existing, err := vs.VideoRepo.ListWatermarks(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list watermarks: %w", err)
+	}
+	if len(existing) >= MaxWatermarksPerUser {
+		return nil, fmt.Errorf("%w: at most %d watermarks can be stored, delete one first", ErrInvalidWatermark, MaxWatermarksPerUser)
+	}
+
+	id := uuid.New().String()
+	var imagePath string
+	if len(input.Image) > 0 {
+		imagePath, err = downloader.SaveWatermarkImage(id, input.Image)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidWatermark, err)
+		}
+	}
+	margin := downloader.DefaultWatermarkMargin
+	if input.Margin != nil {
+		margin = *input.Margin
+	}
+	wm, err := downloader.NewWatermark(imagePath, input.Text, input.Position, margin, input.Opacity, input.Scale)
+	if err != nil {
+		removeWatermarkImage(imagePath)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWatermark, err)
+	}
+
+	record := model.Watermark{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Kind:      WatermarkText,
+		Text:      wm.Text,
+		ImageFile: wm.ImagePath,
+		Position:  string(wm.Position),
+		Margin:    wm.Margin,
+		Opacity:   wm.Opacity,
+		Scale:     wm.Scale,
+	}
+	if wm.ImagePath != "" {
+		record.Kind = WatermarkImage
+	}
+	if record.Name == "" {
+		record.Name = record.Kind
+		if wm.Text != "" {
+			record.Name = wm.Text
+		}
+	}
+	if err := vs.VideoRepo.CreateWatermark(record); err != nil {
+		log.Printf("CreateWatermark - CreateWatermark error: %v", err)
+		removeWatermarkImage(imagePath)
+		return nil, fmt.Errorf("failed to store watermark: %w", err)
+	}
+	return &record, nil
+}
+
+// ListWatermarks returns the watermarks of userID, oldest first
+func (vs *VideoService) ListWatermarks(userID string) ([]model.Watermark, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("%w: userID cannot be empty", ErrInvalidArgument)
+	}
+	watermarks, err := vs.VideoRepo.ListWatermarks(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list watermarks: %w", err)
+	}
+	sort.SliceStable(watermarks, func(i, j int) bool { return watermarks[i].CreatedAt < watermarks[j].CreatedAt })
+	return watermarks, nil
+}
+
+// DeleteWatermark removes one of userID's watermarks and its logo
+func (vs *VideoService) DeleteWatermark(id, userID string) error {
+	wm, err := vs.ownedWatermark(id, userID)
+	if err != nil {
+		return err
+	}
+	// Queued jobs load the watermark when they start and running ones read its image
+	inUse, err := vs.VideoRepo.CountUnfinishedWatermarkJobs(id)
+	if err != nil {
+		log.Printf("DeleteWatermark - CountUnfinishedWatermarkJobs error: %v", err)
+		return fmt.Errorf("failed to check watermark jobs: %w", err)
+	}
+	if inUse > 0 {
+		return fmt.Errorf("%w: %d downloads still need it, delete it once they finish or cancel them", ErrWatermarkInUse, inUse)
+	}
+	if err := vs.VideoRepo.DeleteWatermark(id); err != nil {
+		log.Printf("DeleteWatermark - DeleteWatermark error: %v", err)
+		return fmt.Errorf("failed to delete watermark: %w", err)
+	}
+	removeWatermarkImage(wm.ImageFile)
+	return nil
+}
+
+// withWatermark applies the stored watermark a job references by ID, it must belong to the job's user
+func (vs *VideoService) withWatermark(id, userID string, opts *downloader.Options, record *model.JobOptions) error {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil
+	}
+	if userID == "" {
+		return fmt.Errorf("%w: watermarks require a signed in user", ErrInvalidWatermark)
+	}
+	if opts.AudioMode == downloader.AudioModeOnly {
+		return fmt.Errorf("%w: audio-only jobs have no video to watermark", ErrInvalidWatermark)
+	}
+	// The re-encode keeps the main video only, cover art would be dropped
+	if opts.Thumbnail {
+		return fmt.Errorf("%w: watermarked outputs cannot carry a thumbnail", ErrInvalidWatermark)
+	}
+
+	stored, err := vs.ownedWatermark(id, userID)
+	if err != nil {
+		return err
+	}
+	wm, err := downloader.NewWatermark(stored.ImageFile, stored.Text, stored.Position, stored.Margin, stored.Opacity, stored.Scale)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWatermark, err)
+	}
+	if wm.ImagePath != "" {
+		if _, err := os.Stat(wm.ImagePath); err != nil {
+			return fmt.Errorf("%w: the image of watermark %q is missing, upload it again", ErrInvalidWatermark, stored.Name)
+		}
+	}
+	opts.Watermark = wm
+	record.WatermarkID = id
+	return nil
+}
+
+// ownedWatermark loads a watermark, hiding other users' watermarks behind ErrWatermarkNotFound
+func (vs *VideoService) ownedWatermark(id, userID string) (*model.Watermark, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: watermark ID cannot be empty", ErrInvalidArgument)
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrWatermarkNotFound, id)
+	}
+	wm, err := vs.VideoRepo.GetWatermark(id)
+	if err != nil || wm.UserID != userID {
+		return nil, fmt.Errorf("%w: %s", ErrWatermarkNotFound, id)
+	}
+	return wm, nil
+}
+
+func removeWatermarkImage(path string) {
+	if path != "" {
+		os.Remove(path)
+	}
+}
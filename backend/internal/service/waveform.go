@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/verse91/ytb-clipy/backend/internal/video_pipeline/downloader"
+)
+
+// Waveform encodings clients can ask for
+const (
+	WaveformJSON = "json"
+	WaveformDat  = "dat" // audiowaveform binary
+)
+
+// ErrInvalidWaveform is returned for waveform requests with a bad resolution or format, or
+// media the waveform cannot be drawn for
+var ErrInvalidWaveform = errors.New("invalid waveform request")
+
+// WaveformInput is a waveform request as the client sent it
+type WaveformInput struct {
+	Resolution int    // peaks per second, 0 takes the default
+	Format     string // json or dat
+}
+
+// validateWaveform turns a waveform request into downloader settings and the encoding to serve
+func validateWaveform(input WaveformInput) (downloader.Waveform, string, error) {
+	format := strings.ToLower(strings.TrimSpace(input.Format))
+	if format == "" {
+		format = WaveformJSON
+	}
+	if format != WaveformJSON && format != WaveformDat {
+		return downloader.Waveform{}, "", fmt.Errorf("%w: format must be %q or %q", ErrInvalidWaveform, WaveformJSON, WaveformDat)
+	}
+	w, err := downloader.NewWaveform(input.Resolution)
+	if err != nil {
+		return downloader.Waveform{}, "", fmt.Errorf("%w: %v", ErrInvalidWaveform, err)
+	}
+	return w, format, nil
+}
+
+// waveformResult picks the requested encoding, or sorts the failure into client mistakes and our own
+func waveformResult(files downloader.WaveformFiles, format string, err error) (string, error) {
+	switch {
+	case errors.Is(err, downloader.ErrNoAudioTrack), errors.Is(err, downloader.ErrWaveformTooLong):
+		return "", fmt.Errorf("%w: %v", ErrInvalidWaveform, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "", fmt.Errorf("%w: timed out", ErrPreviewFailed)
+	case err != nil:
+		return "", fmt.Errorf("%w: %v", ErrPreviewFailed, err)
+	case format == WaveformDat:
+		return files.Dat, nil
+	}
+	return files.JSON, nil
+}
+
+// SourceWaveform returns cached or freshly computed waveform peaks of the source video's audio
+func (vs *VideoService) SourceWaveform(videoURL string, input WaveformInput) (string, error) {
+	validatedURL, err := vs.validateURL(videoURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid video URL: %w", err)
+	}
+	w, format, err := validateWaveform(input)
+	if err != nil {
+		return "", err
+	}
+	release, err := vs.previews.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	ctx, cancel := withTimeout(context.Background(), waveformTimeout())
+	defer cancel()
+	files, err := downloader.SourceWaveform(ctx, validatedURL, w)
+	if err == nil {
+		vs.previews.prune()
+	}
+	return waveformResult(files, format, err)
+}
+
+// DownloadWaveform returns waveform peaks of the file of a completed full video download owned by userID
+func (vs *VideoService) DownloadWaveform(downloadID, userID string, input WaveformInput) (string, error) {
+	file, err := vs.DownloadFile(downloadID, userID)
+	if err != nil {
+		return "", err
+	}
+	return vs.outputWaveform(file, input)
+}
+
+// TimeRangeWaveform returns waveform peaks of the clip of a completed time range download owned by userID
+func (vs *VideoService) TimeRangeWaveform(downloadID, userID string, input WaveformInput) (string, error) {
+	file, err := vs.TimeRangeDownloadFile(downloadID, userID)
+	if err != nil {
+		return "", err
+	}
+	return vs.outputWaveform(file, input)
+}
+
+// outputWaveform computes the peaks of a finished job's file, cached next to it
+func (vs *VideoService) outputWaveform(file OutputFile, input WaveformInput) (string, error) {
+	w, format, err := validateWaveform(input)
+	if err != nil {
+		return "", err
+	}
+	release, err := vs.previews.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	ctx, cancel := withTimeout(context.Background(), waveformTimeout())
+	defer cancel()
+	files, err := downloader.FileWaveform(ctx, file.Path, w)
+	return waveformResult(files, format, err)
+}
@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AnimationFormat is an animated image output for time range jobs
+type AnimationFormat string
+
+const (
+	AnimationNone AnimationFormat = ""
+	AnimationGIF  AnimationFormat = "gif"
+	AnimationWebP AnimationFormat = "webp"
+)
+
+// Defaults and bounds for animated exports
+const (
+	DefaultAnimationWidth = 480
+	DefaultAnimationFPS   = 15
+	MinAnimationWidth     = 64
+	MaxAnimationWidth     = 1280
+	MinAnimationFPS       = 1
+	MaxAnimationFPS       = 30
+	MaxAnimationLoop      = 100
+	// MaxAnimationSeconds keeps exports to the length animated images are used for
+	MaxAnimationSeconds = 60
+)
+
+// The size ceiling gives up rather than go below these
+const (
+	minFittedWidth = 120
+	minFittedFPS   = 5
+	maxFitAttempts = 8
+)
+
+// Animation is the animated image export of a clip
+type Animation struct {
+	Format     AnimationFormat
+	Width      int     // pixels, the height follows the aspect ratio; never upscaled
+	FPS        float64 // frames per second
+	Loop       int     // times to play, 0 loops forever
+	SinglePass bool    // GIF only: skip the palette pass, faster but with banding
+	MaxBytes   int64   // lower fps and width until the file fits, 0 means no ceiling
+}
+
+// NewAnimation validates an animated export, applying defaults for zero values
+func NewAnimation(format string, width int, fps float64, loop int, singlePass bool, maxBytes int64) (Animation, error) {
+	var a Animation
+	switch f := AnimationFormat(strings.ToLower(strings.TrimSpace(format))); f {
+	case AnimationNone:
+		if width != 0 || fps != 0 || loop != 0 || singlePass || maxBytes != 0 {
+			return a, fmt.Errorf("animation options require an animation format")
+		}
+		return a, nil
+	case AnimationGIF, AnimationWebP:
+		a.Format = f
+	default:
+		return a, fmt.Errorf("unknown animation format %q, expected %q or %q", format, AnimationGIF, AnimationWebP)
+	}
+
+	if width == 0 {
+		width = DefaultAnimationWidth
+	}
+	if width < MinAnimationWidth || width > MaxAnimationWidth {
+		return a, fmt.Errorf("animation width must be between %d and %d", MinAnimationWidth, MaxAnimationWidth)
+	}
+	if fps == 0 {
+		fps = DefaultAnimationFPS
+	}
+	if fps < MinAnimationFPS || fps > MaxAnimationFPS {
+		return a, fmt.Errorf("animation fps must be between %d and %d", MinAnimationFPS, MaxAnimationFPS)
+	}
+	if loop < 0 || loop > MaxAnimationLoop {
+		return a, fmt.Errorf("animation loop must be between 0 (forever) and %d", MaxAnimationLoop)
+	}
+	if singlePass && a.Format != AnimationGIF {
+		return a, fmt.Errorf("single pass encoding only applies to gif")
+	}
+	if maxBytes < 0 {
+		return a, fmt.Errorf("animation max size cannot be negative")
+	}
+
+	a.Width = width &^ 1 // even, so the scaled height can be kept even too
+	a.FPS = fps
+	a.Loop = loop
+	a.SinglePass = singlePass
+	a.MaxBytes = maxBytes
+	return a, nil
+}
+
+// Enabled reports whether the job exports an animation instead of a video
+func (a Animation) Enabled() bool {
+	return a.Format != AnimationNone
+}
+
+// label names the format in output file names
+func (a Animation) label() string {
+	if a.Format == AnimationWebP {
+		return "WebP"
+	}
+	return "GIF"
+}
+
+// exportAnimation converts the clip at videoPath into an animation next to it and removes the clip.
+// With a size ceiling it re-encodes at lower fps and width, alternately, until the file fits.
+func exportAnimation(ctx context.Context, videoPath string, a Animation) (string, float64, error) {
+	outPath := strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + "." + string(a.Format)
+	width, fps := a.Width, a.FPS
+
+	for attempt := 1; ; attempt++ {
+		if err := encodeAnimation(ctx, videoPath, outPath, a, width, fps); err != nil {
+			os.Remove(outPath)
+			return "", 0, err
+		}
+		stat, err := os.Stat(outPath)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to stat animation: %w", err)
+		}
+		if a.MaxBytes == 0 || stat.Size() <= a.MaxBytes {
+			break
+		}
+
+		if width, fps, err = fitAnimation(attempt, width, fps, stat.Size(), a.MaxBytes); err != nil {
+			os.Remove(outPath)
+			return "", 0, err
+		}
+	}
+
+	os.Remove(videoPath)
+	return outPath, fps, nil
+}
+
+// fitAnimation picks the width and fps of the next attempt after one came out size bytes, above
+// maxBytes. It lowers fps and width alternately, in proportion to how far over the ceiling the
+// file is and at least one step each time, and gives up at the floors or after maxFitAttempts.
+func fitAnimation(attempt, width int, fps float64, size, maxBytes int64) (int, float64, error) {
+	if attempt >= maxFitAttempts || (fps <= minFittedFPS && width <= minFittedWidth) {
+		return 0, 0, fmt.Errorf("animation is %.1f MB at %dpx and %s fps, above the %.1f MB limit",
+			float64(size)/(1<<20), width, formatSeconds(fps), float64(maxBytes)/(1<<20))
+	}
+	over := math.Sqrt(float64(size) / float64(maxBytes))
+	if attempt%2 == 1 && fps > minFittedFPS || width <= minFittedWidth {
+		fps = math.Max(minFittedFPS, math.Floor(math.Min(fps*0.8, fps/over)))
+	} else {
+		width = max(minFittedWidth, int(math.Min(float64(width)*0.8, float64(width)/over))&^1)
+	}
+	return width, fps, nil
+}
+
+// encodeAnimation writes one animation of videoPath at the given width and fps
+func encodeAnimation(ctx context.Context, videoPath, outPath string, a Animation, width int, fps float64) error {
+	scale := fmt.Sprintf("fps=%s,scale='min(%d,iw)':-2:flags=lanczos", formatSeconds(fps), width)
+
+	if a.Format == AnimationWebP {
+		return runFFmpeg(ctx,
+			"-i", videoPath,
+			"-vf", scale,
+			"-an", "-sn",
+			"-c:v", "libwebp_anim", "-lossless", "0", "-q:v", "75", "-compression_level", "6",
+			"-loop", strconv.Itoa(a.Loop),
+			outPath,
+		)
+	}
+
+	// The gif muxer counts repeats: -1 plays once, 0 loops forever
+	loop := a.Loop - 1
+	if a.Loop == 0 {
+		loop = 0
+	}
+	if a.SinglePass {
+		return runFFmpeg(ctx,
+			"-i", videoPath,
+			"-vf", scale,
+			"-an", "-sn",
+			"-loop", strconv.Itoa(loop),
+			outPath,
+		)
+	}
+
+	// Two passes: build a palette from the clip itself, then map the frames onto it
+	palette := strings.TrimSuffix(outPath, filepath.Ext(outPath)) + ".palette.png"
+	defer os.Remove(palette)
+	if err := runFFmpeg(ctx,
+		"-i", videoPath,
+		"-vf", scale+",palettegen=stats_mode=diff",
+		palette,
+	); err != nil {
+		return err
+	}
+	return runFFmpeg(ctx,
+		"-i", videoPath,
+		"-i", palette,
+		"-lavfi", scale+"[x];[x][1:v]paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle",
+		"-an", "-sn",
+		"-loop", strconv.Itoa(loop),
+		outPath,
+	)
+}
@@ -0,0 +1,151 @@
+package downloader
+
+import "testing"
+
+func TestNewAnimation(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		width      int
+		fps        float64
+		loop       int
+		singlePass bool
+		maxBytes   int64
+		want       Animation
+		wantErr    bool
+	}{
+		{name: "off", want: Animation{}},
+		{name: "options without format", width: 320, wantErr: true},
+		{name: "max size without format", maxBytes: 1 << 20, wantErr: true},
+		{name: "unknown format", format: "apng", wantErr: true},
+		{
+			name:   "gif defaults",
+			format: "gif",
+			want:   Animation{Format: AnimationGIF, Width: DefaultAnimationWidth, FPS: DefaultAnimationFPS},
+		},
+		{
+			name:   "format is case insensitive",
+			format: " WebP ",
+			want:   Animation{Format: AnimationWebP, Width: DefaultAnimationWidth, FPS: DefaultAnimationFPS},
+		},
+		{
+			name:   "odd width is made even",
+			format: "gif", width: 321, fps: 10, loop: 3,
+			want: Animation{Format: AnimationGIF, Width: 320, FPS: 10, Loop: 3},
+		},
+		{
+			name:   "single pass gif with ceiling",
+			format: "gif", singlePass: true, maxBytes: 5 << 20,
+			want: Animation{Format: AnimationGIF, Width: DefaultAnimationWidth, FPS: DefaultAnimationFPS, SinglePass: true, MaxBytes: 5 << 20},
+		},
+		{name: "width too small", format: "gif", width: MinAnimationWidth - 1, wantErr: true},
+		{name: "width too large", format: "gif", width: MaxAnimationWidth + 1, wantErr: true},
+		{name: "fps too low", format: "gif", fps: 0.5, wantErr: true},
+		{name: "fps too high", format: "webp", fps: MaxAnimationFPS + 1, wantErr: true},
+		{name: "negative loop", format: "gif", loop: -1, wantErr: true},
+		{name: "loop too high", format: "gif", loop: MaxAnimationLoop + 1, wantErr: true},
+		{name: "single pass webp", format: "webp", singlePass: true, wantErr: true},
+		{name: "negative max size", format: "gif", maxBytes: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewAnimation(tt.format, tt.width, tt.fps, tt.loop, tt.singlePass, tt.maxBytes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewAnimation() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewAnimation() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("NewAnimation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitAnimation(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name      string
+		attempt   int
+		width     int
+		fps       float64
+		size      int64
+		wantWidth int
+		wantFPS   float64
+		wantErr   bool
+	}{
+		{
+			name:    "odd attempts lower fps by at least a fifth",
+			attempt: 1, width: 480, fps: 15, size: 11 * mb / 10,
+			wantWidth: 480, wantFPS: 12,
+		},
+		{
+			name:    "far over the ceiling lowers fps further",
+			attempt: 1, width: 480, fps: 30, size: 4 * mb,
+			wantWidth: 480, wantFPS: 15,
+		},
+		{
+			name:    "even attempts lower width, kept even",
+			attempt: 2, width: 480, fps: 12, size: 11 * mb / 10,
+			wantWidth: 384, wantFPS: 12,
+		},
+		{
+			name:    "far over the ceiling lowers width further",
+			attempt: 2, width: 640, fps: 12, size: 4 * mb,
+			wantWidth: 320, wantFPS: 12,
+		},
+		{
+			name:    "fps does not go below its floor",
+			attempt: 1, width: 480, fps: 6, size: 4 * mb,
+			wantWidth: 480, wantFPS: minFittedFPS,
+		},
+		{
+			name:    "width at its floor lowers fps on even attempts",
+			attempt: 2, width: minFittedWidth, fps: 12, size: 11 * mb / 10,
+			wantWidth: minFittedWidth, wantFPS: 9,
+		},
+		{
+			name:    "fps at its floor lowers width on odd attempts",
+			attempt: 3, width: 480, fps: minFittedFPS, size: 11 * mb / 10,
+			wantWidth: 384, wantFPS: minFittedFPS,
+		},
+		{
+			name:    "width does not go below its floor",
+			attempt: 2, width: 150, fps: 12, size: 4 * mb,
+			wantWidth: minFittedWidth, wantFPS: 12,
+		},
+		{
+			name:    "both floors reached",
+			attempt: 3, width: minFittedWidth, fps: minFittedFPS, size: 11 * mb / 10,
+			wantErr: true,
+		},
+		{
+			name:    "out of attempts",
+			attempt: maxFitAttempts, width: 480, fps: 15, size: 11 * mb / 10,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, fps, err := fitAnimation(tt.attempt, tt.width, tt.fps, tt.size, mb)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("fitAnimation() = %d, %v, want an error", width, fps)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fitAnimation() error: %v", err)
+			}
+			if width != tt.wantWidth || fps != tt.wantFPS {
+				t.Errorf("fitAnimation() = %d, %v, want %d, %v", width, fps, tt.wantWidth, tt.wantFPS)
+			}
+		})
+	}
+}
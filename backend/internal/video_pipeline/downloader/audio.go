@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AudioMode controls what happens to the audio track of a job
+type AudioMode string
+
+const (
+	AudioModeDefault AudioMode = "default" // keep audio alongside the video stream
+	AudioModeOnly    AudioMode = "only"    // extract audio, drop the video stream
+	AudioModeMute    AudioMode = "mute"    // video stream only, no audio track
+)
+
+// Defaults used when an audio-only request leaves codec or bitrate empty
+const (
+	DefaultAudioCodec   = "mp3"
+	DefaultAudioBitrate = 192 // kbps
+)
+
+// lossless codecs ignore the bitrate setting
+var audioCodecs = map[string]bool{
+	"mp3":  false,
+	"m4a":  false,
+	"opus": false,
+	"flac": true,
+	"wav":  true,
+}
+
+var audioBitrates = []int{64, 96, 128, 160, 192, 256, 320}
+
+// AudioFormat is the target codec and bitrate for audio-only extraction
+type AudioFormat struct {
+	Codec   string
+	Bitrate int // kbps, 0 for lossless codecs
+}
+
+// ParseAudioMode validates a requested audio mode, empty means AudioModeDefault
+func ParseAudioMode(mode string) (AudioMode, error) {
+	switch m := AudioMode(strings.ToLower(strings.TrimSpace(mode))); m {
+	case "":
+		return AudioModeDefault, nil
+	case AudioModeDefault, AudioModeOnly, AudioModeMute:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown audio mode %q", mode)
+	}
+}
+
+// NewAudioFormat validates codec and bitrate, applying defaults for empty values
+func NewAudioFormat(codec string, bitrate int) (AudioFormat, error) {
+	codec = strings.ToLower(strings.TrimSpace(codec))
+	if codec == "" {
+		codec = DefaultAudioCodec
+	}
+
+	lossless, ok := audioCodecs[codec]
+	if !ok {
+		return AudioFormat{}, fmt.Errorf("unsupported audio codec %q, supported: %s", codec, strings.Join(AudioCodecNames(), ", "))
+	}
+	if lossless {
+		if bitrate != 0 {
+			return AudioFormat{}, fmt.Errorf("audio codec %s is lossless and does not take a bitrate", codec)
+		}
+		return AudioFormat{Codec: codec}, nil
+	}
+
+	if bitrate == 0 {
+		bitrate = DefaultAudioBitrate
+	}
+	if !validAudioBitrate(bitrate) {
+		return AudioFormat{}, fmt.Errorf("unsupported audio bitrate %dk, supported: %v", bitrate, audioBitrates)
+	}
+	return AudioFormat{Codec: codec, Bitrate: bitrate}, nil
+}
+
+// AudioCodecNames lists the supported audio-only codecs
+func AudioCodecNames() []string {
+	names := make([]string, 0, len(audioCodecs))
+	for name := range audioCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Label is the short form used in output file names, e.g. "mp3, 192k"
+func (a AudioFormat) Label() string {
+	if a.Bitrate == 0 {
+		return a.Codec
+	}
+	return fmt.Sprintf("%s, %dk", a.Codec, a.Bitrate)
+}
+
+func (a AudioFormat) args() []string {
+	args := []string{"-f", "ba/b", "-x", "--audio-format", a.Codec}
+	if a.Bitrate > 0 {
+		args = append(args, "--audio-quality", fmt.Sprintf("%dK", a.Bitrate))
+	}
+	return args
+}
+
+func validAudioBitrate(bitrate int) bool {
+	for _, b := range audioBitrates {
+		if b == bitrate {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CutPrecision is how exactly a time range job cuts at its start and end
+type CutPrecision string
+
+const (
+	// CutFast stream copies from the keyframe at or before the start, the clip may begin
+	// a second or two early on a frozen frame
+	CutFast CutPrecision = "fast"
+	// CutAccurate re-encodes only the GOPs at both edges and stream copies the middle
+	CutAccurate CutPrecision = "accurate"
+	// CutReencode re-encodes the whole clip
+	CutReencode CutPrecision = "reencode"
+)
+
+// cutPadding is the extra source accurate cuts fetch on both sides of the range, in seconds,
+// so the keyframes around the cut points are in the file
+const cutPadding = 10
+
+// ParseCutPrecision validates a cut precision, empty means CutFast
+func ParseCutPrecision(precision string) (CutPrecision, error) {
+	switch p := CutPrecision(strings.ToLower(strings.TrimSpace(precision))); p {
+	case "":
+		return CutFast, nil
+	case CutFast, CutAccurate, CutReencode:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown cut precision %q, expected one of: %s", precision, strings.Join(CutPrecisionNames(), ", "))
+}
+
+// CutPrecisionNames lists the valid cut precisions
+func CutPrecisionNames() []string {
+	return []string{string(CutFast), string(CutAccurate), string(CutReencode)}
+}
+
+// fetchWindow is the source range yt-dlp downloads for a clip of [begin, end]
+func (c CutPrecision) fetchWindow(begin, end float64) (float64, float64) {
+	if c != CutAccurate {
+		return begin, end
+	}
+	from := begin - cutPadding
+	if from < 0 {
+		from = 0
+	}
+	return from, end + cutPadding
+}
+
+// sectionArgs returns the yt-dlp flags downloading [begin, end] with the precision
+func (c CutPrecision) sectionArgs(begin, end float64) []string {
+	from, to := c.fetchWindow(begin, end)
+	args := []string{"--download-section", "*" + formatSeconds(from) + "-" + formatSeconds(to)}
+	if c == CutReencode {
+		// yt-dlp re-encodes the section so it starts and ends exactly on the cut points
+		args = append(args, "--force-keyframes-at-cuts")
+	}
+	return args
+}
+
+// packet is one packet of a stream as ffprobe lists it, times in seconds
+type packet struct {
+	pts      float64
+	duration float64
+	key      bool
+}
+
+// probePackets lists the packets of the first stream matching stream (an ffprobe specifier)
+// sorted by presentation time. Pre-roll before a cut point has negative times.
+func probePackets(ctx context.Context, path, stream string) ([]packet, error) {
+	cmd := exec.CommandContext(ctx, ffprobePath,
+		"-v", "error",
+		"-select_streams", stream,
+		"-show_entries", "packet=pts_time,duration_time,flags",
+		"-of", "csv=p=0",
+		path,
+	)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, fmt.Errorf("ffprobe failed: %w: %s", err, lastLines(stderrBuf.String(), 3))
+	}
+
+	var packets []packet
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 3 {
+			continue
+		}
+		pts, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		duration, _ := strconv.ParseFloat(fields[1], 64)
+		packets = append(packets, packet{pts: pts, duration: duration, key: strings.Contains(fields[2], "K")})
+	}
+	sort.Slice(packets, func(i, j int) bool { return packets[i].pts < packets[j].pts })
+	return packets, nil
+}
+
+// cutBounds returns the source times of the first and last instant the clip at path shows,
+// begin being the source time of the file's zero. Video frames are measured when there are
+// any, audio otherwise.
+func cutBounds(ctx context.Context, path string, begin float64) (float64, float64, error) {
+	packets, err := probePackets(ctx, path, "V:0")
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(packets) == 0 {
+		if packets, err = probePackets(ctx, path, "a:0"); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(packets) == 0 {
+		return 0, 0, fmt.Errorf("no audio or video packets in %s", filepath.Base(path))
+	}
+	last := packets[len(packets)-1]
+	return begin + packets[0].pts, begin + last.pts + last.duration, nil
+}
+
+// accurateCut trims the padded download at path to [start, end] (seconds into the file) in place.
+// H.264 video is cut smart: the partial GOPs at the edges are re-encoded and the keyframe
+// aligned middle is copied. Other video codecs are re-encoded whole, audio is copied either
+// way since every audio frame is a keyframe.
+func accurateCut(ctx context.Context, path string, start, end float64) error {
+	video, err := probePackets(ctx, path, "V:0")
+	if err != nil {
+		return err
+	}
+	if len(video) == 0 {
+		return ffmpegReplace(ctx, path, append(trimArgs(path, start, end-start), "-map", "0", "-c", "copy"))
+	}
+
+	info, err := probeMedia(ctx, path)
+	if err != nil {
+		return err
+	}
+	firstKey, lastKey := -1.0, -1.0
+	for _, p := range video {
+		if !p.key {
+			continue
+		}
+		if firstKey < 0 && p.pts >= start {
+			firstKey = p.pts
+		}
+		if p.pts <= end {
+			lastKey = p.pts
+		}
+	}
+	if info.VideoCodec != "h264" || firstKey < 0 || lastKey <= firstKey {
+		log.Printf("accurateCut - smart cut not possible for codec %q, re-encoding the clip", info.VideoCodec)
+		return ffmpegReplace(ctx, path, append(trimArgs(path, start, end-start),
+			"-map", "0:V:0", "-map", "0:a?", "-map", "0:s?",
+			"-c:a", "copy", "-c:s", "copy",
+		))
+	}
+
+	workDir, err := os.MkdirTemp(filepath.Dir(path), ".cut-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cut dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// MPEG-TS pieces carry their parameter sets in band, so re-encoded and copied pieces concatenate
+	var pieces []string
+	piece := func(from, to float64, copyStream bool) error {
+		if to-from < 0.001 {
+			return nil
+		}
+		out := filepath.Join(workDir, fmt.Sprintf("%d.ts", len(pieces)))
+		args := append(trimArgs(path, from, to-from), "-map", "0:V:0", "-an", "-sn", "-dn")
+		if copyStream {
+			args = append(args, "-c:v", "copy")
+		} else {
+			args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-pix_fmt", "yuv420p")
+		}
+		args = append(args, "-bsf:v", "h264_mp4toannexb", "-f", "mpegts", out)
+		if err := runFFmpeg(ctx, args...); err != nil {
+			return err
+		}
+		pieces = append(pieces, out)
+		return nil
+	}
+	if err := piece(start, firstKey, false); err != nil {
+		return err
+	}
+	if err := piece(firstKey, lastKey, true); err != nil {
+		return err
+	}
+	if err := piece(lastKey, end, false); err != nil {
+		return err
+	}
+
+	listPath := filepath.Join(workDir, "pieces.txt")
+	var list strings.Builder
+	for _, p := range pieces {
+		fmt.Fprintf(&list, "file '%s'\n", filepath.Base(p))
+	}
+	if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write cut list: %w", err)
+	}
+
+	// Everything but the main video comes from the download: audio, subtitles, cover art, tags
+	args := []string{"-f", "concat", "-safe", "0", "-i", listPath}
+	args = append(args, trimArgs(path, start, end-start)...)
+	args = append(args,
+		"-map", "0:v:0", "-map", "1", "-map", "-1:V", "-dn",
+		"-map_metadata", "1", "-map_chapters", "1",
+		"-c", "copy",
+	)
+	return ffmpegReplace(ctx, path, args)
+}
+
+// formatSeconds writes seconds the way yt-dlp and ffmpeg accept them, without trailing zeros
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
+
+// trimArgs opens path as an ffmpeg input seeked to start and limited to duration seconds
+func trimArgs(path string, start, duration float64) []string {
+	return []string{
+		"-ss", formatSeconds(start),
+		"-t", formatSeconds(duration),
+		"-i", path,
+	}
+}
@@ -1,17 +1,206 @@
 package downloader
 
 import (
-	// "fmt"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 )
 
 var (
-	ytDlpPath = getExecutablePath("yt-dlp")
-	outputDir = getConfigValue("OUTPUT_DIR", "internal/video_pipeline/videos")
+	ytDlpPath  = getExecutablePath("yt-dlp")
+	ffmpegPath = getExecutablePath("ffmpeg")
+	// ffprobe ships with ffmpeg builds
+	ffprobePath = getExecutablePath("ffprobe")
+	outputDir   = getConfigValue("OUTPUT_DIR", "internal/video_pipeline/videos")
 )
 
+// Options holds the per-job settings shared by full video and time range downloads
+type Options struct {
+	Quality         QualityPreset
+	AudioMode       AudioMode
+	Audio           AudioFormat // only used with AudioModeOnly
+	SponsorBlock    SponsorBlock
+	Subtitles       Subtitles
+	Thumbnail       bool         // embed the source thumbnail as cover art
+	Cut             CutPrecision // time range jobs only
+	Animation       Animation    // time range jobs only, replaces the video output
+	Reframe         Reframe      // time range jobs only
+	Watermark       Watermark
+	Encoding        Encoding // codec and container conversion, the default is the preset's mp4
+	AudioProcessing AudioProcessing
+	Timeouts        Timeouts
+}
+
+// Result describes what a finished job produced. Outputs are named after the job ID,
+// FileName is the title based name to offer clients instead.
+type Result struct {
+	Path       string
+	FileName   string
+	Size       int64
+	Duration   float64 // final length in seconds, 0 when unknown
+	Width      int
+	Height     int
+	VideoCodec string // empty for audio-only outputs
+	AudioCodec string // empty for muted outputs
+	Container  string // file extension, e.g. mp4, mkv, m4a
+
+	// What yt-dlp picked for the requested preset, before any reframe or conversion
+	SourceFormat string // format ID, "137+140" for merged streams
+	SourceHeight int    // 0 for audio-only outputs
+
+	SponsorSegments []SponsorSegment // removed or marked segments, clipped to the window for time range jobs
+
+	// Source times the clip really starts and ends at, time range jobs only
+	CutStart float64
+	CutEnd   float64
+
+	FPS float64 // frame rate of animated outputs, after any lowering to fit the size limit
+
+	Loudness *Loudness // measured when the job normalizes its audio
+
+	Notice string // something the job asked for but finished without, e.g. missing subtitles
+}
+
+// formatArgs returns the yt-dlp stream selection flags for the job
+func (o Options) formatArgs() []string {
+	switch o.AudioMode {
+	case AudioModeOnly:
+		return o.Audio.args()
+	case AudioModeMute:
+		if o.Encoding.Enabled() {
+			return o.Encoding.formatArgs(o.Quality, true)
+		}
+		// Select video-only formats so the audio stream is never downloaded
+		return []string{"-f", o.Quality.MuteFormat, "-S", o.Quality.Sort}
+	}
+	if o.Encoding.Enabled() {
+		return o.Encoding.formatArgs(o.Quality, false)
+	}
+	return []string{"-f", o.Quality.Format, "-S", o.Quality.Sort}
+}
+
+// fileLabel is the yt-dlp template fragment describing the output in its file name
+func (o Options) fileLabel() string {
+	if o.Animation.Enabled() {
+		return o.Animation.label()
+	}
+	if o.Reframe.Enabled() {
+		label := fmt.Sprintf("%dx%d", reframeWidth, reframeHeight)
+		if o.AudioMode == AudioModeMute {
+			label += ", muted"
+		}
+		return label
+	}
+	codec := "%(vcodec.:4)s"
+	if o.Encoding.Codec != "" {
+		codec = string(o.Encoding.Codec)
+	}
+	switch o.AudioMode {
+	case AudioModeOnly:
+		return o.Audio.Label()
+	case AudioModeMute:
+		return "%(height)sp, " + codec + ", muted"
+	}
+	return "%(height)sp, " + codec
+}
+
+// newPartsDir creates the per-job dir yt-dlp keeps intermediate and .part files in,
+// so a cancelled or failed job can be cleaned up without touching other jobs
+func newPartsDir() (string, error) {
+	root := filepath.Join(outputDir, ".parts")
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create parts dir: %w", err)
+	}
+	dir, err := os.MkdirTemp(root, "job-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create parts dir: %w", err)
+	}
+	return dir, nil
+}
+
+// pathArgs sends intermediate files to partsDir and only moves the finished file to outputDir
+func pathArgs(partsDir string) []string {
+	return []string{"-P", "home:" + outputDir, "-P", "temp:" + partsDir}
+}
+
+// printFiles is a scratch dir for values yt-dlp reports through --print-to-file
+type printFiles struct {
+	dir string
+}
+
+func newPrintFiles() (*printFiles, error) {
+	dir, err := os.MkdirTemp("", "yt-dlp-print-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create print dir: %w", err)
+	}
+	return &printFiles{dir: dir}, nil
+}
+
+// arg returns the --print-to-file flags writing template into the file called name
+func (p *printFiles) arg(name, template string) []string {
+	return []string{"--print-to-file", template, filepath.Join(p.dir, name)}
+}
+
+// read returns what yt-dlp printed into name, empty if it printed nothing
+func (p *printFiles) read(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(p.dir, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// finalPathArg asks yt-dlp to report where the finished file was moved
+func (p *printFiles) finalPathArg() []string {
+	return p.arg("filepath", "after_move:%(filepath)s")
+}
+
+// nameArg asks yt-dlp to report the title based name of the output, without extension
+func (p *printFiles) nameArg(label string) []string {
+	return p.arg("name", "after_move:%(title)s ("+label+")")
+}
+
+// sourceFormatArg asks yt-dlp to report the format it resolved the quality preset to
+func (p *printFiles) sourceFormatArg() []string {
+	return p.arg("format", "after_move:%(format_id)s|%(height)s")
+}
+
+// sourceFormat fills the source fields of result from what sourceFormatArg reported. Multi-range
+// runs print a line per segment, they all come from the same formats.
+func (p *printFiles) sourceFormat(result *Result) {
+	data, err := p.read("format")
+	if err != nil || data == "" {
+		return
+	}
+	line, _, _ := strings.Cut(data, "\n")
+	formatID, height, _ := strings.Cut(strings.TrimSpace(line), "|")
+	result.SourceFormat = formatID
+	// yt-dlp prints NA for audio-only formats
+	result.SourceHeight, _ = strconv.Atoi(height)
+}
+
+// finalPath returns the path reported through finalPathArg
+func (p *printFiles) finalPath() (string, error) {
+	path, err := p.read("filepath")
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return "", fmt.Errorf("yt-dlp did not report the output file")
+	}
+	return path, nil
+}
+
+func (p *printFiles) cleanup() {
+	os.RemoveAll(p.dir)
+}
+
 func getExecutablePath(name string) string {
 	if runtime.GOOS == "windows" {
 		return filepath.Join("internal", "video_pipeline", "bin", name+".exe")
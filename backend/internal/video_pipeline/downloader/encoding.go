@@ -0,0 +1,265 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// VideoCodec is an output video codec a job can ask for
+type VideoCodec string
+
+const (
+	CodecH264 VideoCodec = "h264"
+	CodecH265 VideoCodec = "h265"
+	CodecVP9  VideoCodec = "vp9"
+	CodecAV1  VideoCodec = "av1"
+)
+
+// Container is an output file format a job can ask for
+type Container string
+
+const (
+	ContainerMP4  Container = "mp4"
+	ContainerWebM Container = "webm"
+	ContainerMKV  Container = "mkv"
+)
+
+// videoCodecs describes each codec: the ffprobe name of matching streams, the yt-dlp vcodec
+// pattern that selects them at the source, the encoder used otherwise and its CRF scale
+var videoCodecs = map[VideoCodec]struct {
+	probeName  string
+	sourceExpr string
+	encoder    string
+	maxCRF     int
+	defaultCRF int
+}{
+	CodecH264: {"h264", `^(avc1|h264)`, "libx264", 51, 23},
+	CodecH265: {"hevc", `^(hvc1|hev1|h265|hevc)`, "libx265", 51, 28},
+	CodecVP9:  {"vp9", `^(vp0?9)`, "libvpx-vp9", 63, 32},
+	CodecAV1:  {"av1", `^(av01|av1)`, "libsvtav1", 63, 35},
+}
+
+// containerCodecs lists the video and audio codecs (ffprobe names) each container can hold
+var containerCodecs = map[Container]struct {
+	video         []VideoCodec
+	audio         []string
+	audioEncoder  []string // used when the source audio does not fit
+	subtitleCodec string
+}{
+	ContainerMP4:  {[]VideoCodec{CodecH264, CodecH265, CodecVP9, CodecAV1}, []string{"aac", "mp3", "opus", "ac3", "eac3", "alac", "flac"}, []string{"aac", "-b:a", "192k"}, "mov_text"},
+	ContainerWebM: {[]VideoCodec{CodecVP9, CodecAV1}, []string{"opus", "vorbis"}, []string{"libopus", "-b:a", "160k"}, "webvtt"},
+	ContainerMKV:  {[]VideoCodec{CodecH264, CodecH265, CodecVP9, CodecAV1}, nil, nil, "copy"},
+}
+
+// EncoderPresets are the speed presets, fastest first, in x264 naming. VP9 and AV1 map them
+// onto their own speed scales.
+var EncoderPresets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+
+const defaultEncoderPreset = "medium"
+
+// Encoding is the codec and container a job converts its output to
+type Encoding struct {
+	Codec     VideoCodec // empty keeps the source codec, only valid with ContainerMKV
+	Container Container
+	CRF       int
+	Preset    string
+}
+
+// VideoCodecNames lists the valid video codecs
+func VideoCodecNames() []string {
+	return []string{string(CodecH264), string(CodecH265), string(CodecVP9), string(CodecAV1)}
+}
+
+// ContainerNames lists the valid containers
+func ContainerNames() []string {
+	return []string{string(ContainerMP4), string(ContainerWebM), string(ContainerMKV)}
+}
+
+// NewEncoding validates a codec and container choice, applying defaults for empty values.
+// A codec alone picks its usual container; a container alone picks its usual codec, except
+// mkv which keeps the source streams as they are.
+func NewEncoding(codec, container string, crf int, preset string) (Encoding, error) {
+	var enc Encoding
+	codec = strings.ToLower(strings.TrimSpace(codec))
+	switch codec {
+	case "avc":
+		codec = string(CodecH264)
+	case "hevc":
+		codec = string(CodecH265)
+	}
+	enc.Codec = VideoCodec(codec)
+	enc.Container = Container(strings.ToLower(strings.TrimSpace(container)))
+	enc.Preset = strings.ToLower(strings.TrimSpace(preset))
+
+	if enc.Codec == "" && enc.Container == "" {
+		if crf != 0 || enc.Preset != "" {
+			return Encoding{}, fmt.Errorf("crf and encoder preset require a video codec")
+		}
+		return Encoding{}, nil
+	}
+	if _, ok := videoCodecs[enc.Codec]; enc.Codec != "" && !ok {
+		return Encoding{}, fmt.Errorf("unknown video codec %q, expected one of: %s", codec, strings.Join(VideoCodecNames(), ", "))
+	}
+	if _, ok := containerCodecs[enc.Container]; enc.Container != "" && !ok {
+		return Encoding{}, fmt.Errorf("unknown container %q, expected one of: %s", container, strings.Join(ContainerNames(), ", "))
+	}
+
+	switch {
+	case enc.Container == "" && enc.Codec == CodecVP9:
+		enc.Container = ContainerWebM
+	case enc.Container == "":
+		enc.Container = ContainerMP4
+	case enc.Codec == "" && enc.Container == ContainerMP4:
+		enc.Codec = CodecH264
+	case enc.Codec == "" && enc.Container == ContainerWebM:
+		enc.Codec = CodecVP9
+	}
+	if enc.Codec == "" {
+		// mkv remux, nothing is encoded
+		if crf != 0 || enc.Preset != "" {
+			return Encoding{}, fmt.Errorf("crf and encoder preset require a video codec, mkv without one only remuxes")
+		}
+		return enc, nil
+	}
+	if !slices.Contains(containerCodecs[enc.Container].video, enc.Codec) {
+		return Encoding{}, fmt.Errorf("%s cannot hold %s video", enc.Container, enc.Codec)
+	}
+
+	spec := videoCodecs[enc.Codec]
+	if crf == 0 {
+		crf = spec.defaultCRF
+	}
+	if crf < 1 || crf > spec.maxCRF {
+		return Encoding{}, fmt.Errorf("crf for %s must be between 1 and %d", enc.Codec, spec.maxCRF)
+	}
+	enc.CRF = crf
+	if enc.Preset == "" {
+		enc.Preset = defaultEncoderPreset
+	}
+	if !slices.Contains(EncoderPresets, enc.Preset) {
+		return Encoding{}, fmt.Errorf("unknown encoder preset %q, expected one of: %s", preset, strings.Join(EncoderPresets, ", "))
+	}
+	return enc, nil
+}
+
+// Enabled reports whether the job converts its output instead of keeping the default mp4
+func (e Encoding) Enabled() bool {
+	return e.Container != ""
+}
+
+// formatArgs prefers source streams already in the codec (and, for webm, audio the container
+// takes), so the conversion is a remux. Other streams are still accepted as a fallback.
+func (e Encoding) formatArgs(quality QualityPreset, mute bool) []string {
+	fallback := quality.Format
+	if mute {
+		fallback = quality.MuteFormat
+	}
+	format := fallback
+	if e.Codec != "" {
+		video := "bv"
+		if !mute {
+			video = "bv*"
+		}
+		if quality.MaxHeight > 0 {
+			video += fmt.Sprintf("[height<=%d]", quality.MaxHeight)
+		}
+		video += fmt.Sprintf("[vcodec~='%s']", videoCodecs[e.Codec].sourceExpr)
+		switch {
+		case mute:
+			format = video + "/" + fallback
+		case e.Container == ContainerWebM:
+			format = video + "+ba[acodec=opus]/" + video + "+ba/" + fallback
+		default:
+			format = video + "+ba[ext=m4a]/" + video + "+ba/" + fallback
+		}
+	}
+	// Any pair of streams fits in mkv, convertOutput brings it to the requested container
+	return []string{"-f", format, "-S", quality.Sort, "--merge-output-format", string(ContainerMKV)}
+}
+
+// videoArgs returns the ffmpeg flags encoding the main video with the codec
+func (e Encoding) videoArgs() []string {
+	spec := videoCodecs[e.Codec]
+	speed := slices.Index(EncoderPresets, e.Preset)
+	args := []string{"-c:v:0", spec.encoder, "-crf", strconv.Itoa(e.CRF)}
+	switch e.Codec {
+	case CodecH264:
+		args = append(args, "-preset", e.Preset, "-pix_fmt", "yuv420p")
+	case CodecH265:
+		args = append(args, "-preset", e.Preset, "-pix_fmt", "yuv420p")
+		if e.Container == ContainerMP4 {
+			// hvc1 is the tag Apple players require
+			args = append(args, "-tag:v:0", "hvc1")
+		}
+	case CodecVP9:
+		// Constant quality needs the bitrate unset, cpu-used runs from 5 (fast) to 0 (slow)
+		args = append(args, "-b:v:0", "0", "-deadline", "good", "-cpu-used", strconv.Itoa(5-speed*5/8), "-row-mt", "1", "-pix_fmt", "yuv420p")
+	case CodecAV1:
+		// SVT-AV1 presets run from 12 (fast) to 4 (slow) over the same names
+		args = append(args, "-preset", strconv.Itoa(12-speed), "-pix_fmt", "yuv420p")
+	}
+	return args
+}
+
+// filterVideoArgs returns the ffmpeg flags encoding the video of a pass that has to re-encode it
+// anyway (reframe, burned-in subtitles, watermark). With a requested codec the pass encodes
+// straight to it, so convertOutput copies the video rather than encoding it a second time.
+func (e Encoding) filterVideoArgs() []string {
+	if e.Codec == "" {
+		return []string{"-c:v", "libx264", "-crf", "18", "-preset", "veryfast", "-pix_fmt", "yuv420p"}
+	}
+	// The pass writes the mkv download in place, container specific tags are set by convertOutput
+	e.Container = ContainerMKV
+	return e.videoArgs()
+}
+
+// convertOutput brings the file at mediaPath to the requested codec and container and returns
+// the new path. Streams that already fit are copied, only the others are encoded.
+func convertOutput(ctx context.Context, mediaPath string, e Encoding) (string, error) {
+	info, err := probeMedia(ctx, mediaPath)
+	if err != nil {
+		return "", err
+	}
+	target := containerCodecs[e.Container]
+
+	args := []string{"-i", mediaPath, "-map", "0:V:0?", "-map", "0:a?", "-map", "0:s?", "-map_metadata", "0", "-map_chapters", "0"}
+	copyVideo := e.Codec == "" || info.VideoCodec == "" || info.VideoCodec == videoCodecs[e.Codec].probeName
+	switch {
+	case !copyVideo:
+		args = append(args, e.videoArgs()...)
+	case info.VideoCodec == videoCodecs[CodecH265].probeName && e.Container == ContainerMP4:
+		args = append(args, "-c:v", "copy", "-tag:v:0", "hvc1")
+	default:
+		args = append(args, "-c:v", "copy")
+	}
+	if target.audio == nil || info.AudioCodec == "" || slices.Contains(target.audio, info.AudioCodec) {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a")
+		args = append(args, target.audioEncoder...)
+	}
+	args = append(args, "-c:s", target.subtitleCodec)
+	if e.Container == ContainerMP4 {
+		args = append(args, "-movflags", "+faststart")
+	}
+
+	outPath := strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + "." + string(e.Container)
+	if outPath == mediaPath {
+		if copyVideo && target.audio == nil {
+			// Already an mkv with every stream it needs
+			return mediaPath, nil
+		}
+		return mediaPath, ffmpegReplace(ctx, mediaPath, args)
+	}
+	if err := runFFmpeg(ctx, append(args, outPath)...); err != nil {
+		os.Remove(outPath)
+		return "", err
+	}
+	os.Remove(mediaPath)
+	return outPath, nil
+}
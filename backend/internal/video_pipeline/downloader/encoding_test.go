@@ -0,0 +1,86 @@
+package downloader
+
+import "testing"
+
+func TestNewEncoding(t *testing.T) {
+	tests := []struct {
+		name      string
+		codec     string
+		container string
+		crf       int
+		preset    string
+		want      Encoding
+		wantErr   bool
+	}{
+		{name: "off", want: Encoding{}},
+		{name: "crf without codec", crf: 20, wantErr: true},
+		{name: "preset without codec", preset: "fast", wantErr: true},
+		{
+			name:  "codec alone picks mp4",
+			codec: "h265",
+			want:  Encoding{Codec: CodecH265, Container: ContainerMP4, CRF: 28, Preset: defaultEncoderPreset},
+		},
+		{
+			name:  "vp9 alone picks webm",
+			codec: "vp9",
+			want:  Encoding{Codec: CodecVP9, Container: ContainerWebM, CRF: 32, Preset: defaultEncoderPreset},
+		},
+		{
+			name:  "codec aliases",
+			codec: " HEVC ",
+			want:  Encoding{Codec: CodecH265, Container: ContainerMP4, CRF: 28, Preset: defaultEncoderPreset},
+		},
+		{
+			name:  "avc alias",
+			codec: "avc",
+			want:  Encoding{Codec: CodecH264, Container: ContainerMP4, CRF: 23, Preset: defaultEncoderPreset},
+		},
+		{
+			name:      "mp4 alone picks h264",
+			container: "MP4",
+			want:      Encoding{Codec: CodecH264, Container: ContainerMP4, CRF: 23, Preset: defaultEncoderPreset},
+		},
+		{
+			name:      "webm alone picks vp9",
+			container: "webm",
+			want:      Encoding{Codec: CodecVP9, Container: ContainerWebM, CRF: 32, Preset: defaultEncoderPreset},
+		},
+		{
+			name:      "mkv alone remuxes",
+			container: "mkv",
+			want:      Encoding{Container: ContainerMKV},
+		},
+		{name: "mkv remux with crf", container: "mkv", crf: 20, wantErr: true},
+		{name: "mkv remux with preset", container: "mkv", preset: "slow", wantErr: true},
+		{
+			name:  "explicit crf and preset",
+			codec: "av1", container: "mkv", crf: 40, preset: "Slow",
+			want: Encoding{Codec: CodecAV1, Container: ContainerMKV, CRF: 40, Preset: "slow"},
+		},
+		{name: "webm cannot hold h264", codec: "h264", container: "webm", wantErr: true},
+		{name: "unknown codec", codec: "mpeg2", wantErr: true},
+		{name: "unknown container", container: "avi", wantErr: true},
+		{name: "crf above the h264 scale", codec: "h264", crf: 52, wantErr: true},
+		{name: "crf within the av1 scale", codec: "av1", crf: 63, want: Encoding{Codec: CodecAV1, Container: ContainerMP4, CRF: 63, Preset: defaultEncoderPreset}},
+		{name: "negative crf", codec: "h264", crf: -1, wantErr: true},
+		{name: "unknown preset", codec: "h264", preset: "warp", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEncoding(tt.codec, tt.container, tt.crf, tt.preset)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewEncoding() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewEncoding() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("NewEncoding() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
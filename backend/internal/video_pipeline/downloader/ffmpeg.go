@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runFFmpeg runs ffmpeg non-interactively and includes its last output lines in the error
+func runFFmpeg(ctx context.Context, args ...string) error {
+	_, err := runFFmpegLog(ctx, args...)
+	return err
+}
+
+// runFFmpegLog is runFFmpeg for filters that report through the log, it returns what ffmpeg printed
+func runFFmpegLog(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, ffmpegPath, append([]string{"-hide_banner", "-nostdin", "-y"}, args...)...)
+	killProcessTree(cmd)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", context.Cause(ctx)
+		}
+		return "", fmt.Errorf("ffmpeg failed: %w: %s", err, lastLines(stderrBuf.String(), 3))
+	}
+	return stderrBuf.String(), nil
+}
+
+// ffmpegReplace runs ffmpeg writing to a temp file next to mediaPath, then swaps it in
+func ffmpegReplace(ctx context.Context, mediaPath string, args []string) error {
+	_, err := ffmpegReplaceLog(ctx, mediaPath, args)
+	return err
+}
+
+// ffmpegReplaceLog is ffmpegReplace returning what ffmpeg printed
+func ffmpegReplaceLog(ctx context.Context, mediaPath string, args []string) (string, error) {
+	ext := filepath.Ext(mediaPath)
+	tmpPath := strings.TrimSuffix(mediaPath, ext) + ".tmp" + ext
+
+	output, err := runFFmpegLog(ctx, append(args, tmpPath)...)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, mediaPath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to replace %s: %w", filepath.Base(mediaPath), err)
+	}
+	return output, nil
+}
+
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, " | ")
+}
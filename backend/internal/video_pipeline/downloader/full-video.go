@@ -1,68 +1,127 @@
 package downloader
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
-	"os/exec"
-	"path/filepath"
-	"regexp"
-	"time"
+	"log"
+	"os"
 )
 
-func FullVideoFHD(videoURL string) error {
-	start := time.Now()
+func FullVideoFHD(ctx context.Context, videoURL, downloadID string, opts Options, onProgress ProgressFunc) (*Result, error) {
+	ctx, cancel := opts.Timeouts.withTotal(ctx)
+	defer cancel()
 
-	// make sure to check no playlist from user's input, video will download for the res <=1080p
-	cmd_1080p := exec.Command(
-		ytDlpPath,
-		"--no-playlist",
-		"-f", `bv*[height<=1080][vcodec~=avc1]+ba*[ext=m4a]/bv*[height<=1080]+ba*[ext=m4a]/bv*+ba*/best[height<=1080]/best`,
-		"-S", "res:1080,+codec:avc1,+br",
-		"-o", filepath.Join(outputDir, "%(title)s (%(height)sp, h264).%(ext)s"),
+	files, err := newPrintFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer files.cleanup()
+
+	// Whatever is left in the parts dir is partial, drop it however the job ends
+	partsDir, err := newPartsDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(partsDir)
+
+	// make sure to check no playlist from user's input, video will download for the res of the chosen preset
+	args := append([]string{"--no-playlist"}, opts.formatArgs()...)
+	args = append(args, opts.SponsorBlock.args(files)...)
+	args = append(args, opts.Subtitles.args(false)...)
+	args = append(args, metadataArgs(opts.Thumbnail, "")...)
+	args = append(args, files.finalPathArg()...)
+	args = append(args, files.sourceFormatArg()...)
+	args = append(args, files.nameArg(opts.fileLabel())...)
+	args = append(args, pathArgs(partsDir)...)
+	args = append(args,
+		// Named after the job so the file can be found again without guessing from titles
+		"-o", downloadID+".%(ext)s",
 		videoURL,
 	)
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
-	cmd_1080p.Stdout = &stdoutBuf
-	cmd_1080p.Stderr = &stderrBuf
-
-	if err := cmd_1080p.Run(); err != nil {
-		fmt.Println("Fail:", err)
-		return err
+	tracker := newProgressTracker(onProgress)
+	defer tracker.wait()
+	if _, err := runYtDlp(ctx, args, tracker, opts.Timeouts.Stall); err != nil {
+		return nil, err
+	}
+
+	finalPath, err := files.finalPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate downloaded file: %w", err)
+	}
+
+	result := &Result{}
+	if opts.SponsorBlock.Enabled() {
+		segments, err := readSponsorSegments(files)
+		if err != nil {
+			os.Remove(finalPath)
+			return nil, fmt.Errorf("reading sponsor segments failed: %w", err)
+		}
+		result.SponsorSegments = segments
+	}
+
+	if opts.Subtitles.Enabled() {
+		tracker.enter(PhasePostProcessing)
+		if err := processSubtitles(ctx, finalPath, opts.Subtitles, nil, opts.Encoding); opts.Subtitles.missingSidecar(err) {
+			result.Notice = opts.Subtitles.noSubtitlesNotice()
+		} else if err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("subtitles failed: %w", err)
+		}
+	}
+
+	if opts.Watermark.Enabled() {
+		tracker.enter(PhasePostProcessing)
+		if err := watermarkVideo(ctx, finalPath, opts.Watermark, opts.Encoding); err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("watermark failed: %w", err)
+		}
 	}
 
-	// Combine both stdout and stderr for scanning, since yt-dlp may print to either
-	combined := append(stdoutBuf.Bytes(), stderrBuf.Bytes()...)
-	scanner := bufio.NewScanner(bytes.NewReader(combined))
-	re := regexp.MustCompile(`\[Merger\] Merging formats into \"(.+)\"`)
-	var mergedFile string
-	for scanner.Scan() {
-		line := scanner.Text()
-		if matches := re.FindStringSubmatch(line); matches != nil {
-			mergedFile = matches[1]
-			break
+	if opts.Encoding.Enabled() {
+		tracker.enter(PhasePostProcessing)
+		converted, err := convertOutput(ctx, finalPath, opts.Encoding)
+		if err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("conversion failed: %w", err)
 		}
+		finalPath = converted
 	}
 
-	if mergedFile != "" {
-		base := filepath.Base(mergedFile)
-		// // Example: "Rick Astley - Never Gonna Give You Up (Official Video) (4K Remaster) (1080p, h264).mp4"
-		// // Cut .mp4
-		// ext := filepath.Ext(base)
-		// title := base[:len(base)-len(ext)]
-		// // Remove " (1080p, h264)"
-		// reTitle := regexp.MustCompile(`^(.*) \(1080p, h264\)$`)
-		// if m := reTitle.FindStringSubmatch(title); m != nil {
-		//     title = m[1]
-		// }
-		fmt.Println("✅ Download sucessfully:", base)
-		// fmt.Println("🎵 Video title:", title)
-	} else {
-		fmt.Println("♻️ Video is already downloaded.")
+	if opts.AudioProcessing.Enabled() {
+		tracker.enter(PhasePostProcessing)
+		loudness, err := processAudio(ctx, finalPath, opts.AudioProcessing, opts.Audio.Bitrate)
+		if err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("audio processing failed: %w", err)
+		}
+		result.Loudness = loudness
 	}
-	fmt.Println("Took:", time.Since(start))
-	return nil
+
+	if err := describeOutput(ctx, result, finalPath); err != nil {
+		if ctx.Err() != nil {
+			os.Remove(finalPath)
+			return nil, context.Cause(ctx)
+		}
+		// The file is fine, only its details are missing
+		log.Printf("FullVideoFHD - describeOutput error: %v", err)
+	}
+	name, _ := files.read("name")
+	result.FileName = displayName(name, finalPath)
+	files.sourceFormat(result)
+
+	return result, nil
 }
 
 // func HD(videoURL string) {
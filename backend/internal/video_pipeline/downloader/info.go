@@ -0,0 +1,214 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// ErrUnavailable is returned when yt-dlp ran but could not read the url: an unsupported site,
+// a private or removed video, a typo
+var ErrUnavailable = errors.New("video unavailable")
+
+// ytDlpReadError wraps the error of a yt-dlp run that only reads metadata
+func ytDlpReadError(what string, err error, stderr string) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%s failed: %w: %s", what, ErrUnavailable, lastLines(stderr, 3))
+	}
+	return fmt.Errorf("%s failed: %w: %s", what, err, lastLines(stderr, 3))
+}
+
+// VideoInfo is what a video offers before anything is downloaded
+type VideoInfo struct {
+	ID                 string        `json:"id"`
+	Title              string        `json:"title"`
+	Channel            string        `json:"channel"`
+	ChannelURL         string        `json:"channel_url,omitempty"`
+	Duration           float64       `json:"duration"` // seconds, 0 for live streams
+	Thumbnail          string        `json:"thumbnail,omitempty"`
+	LiveStatus         string        `json:"live_status"` // not_live, is_live, is_upcoming, was_live, post_live
+	IsLive             bool          `json:"is_live"`
+	Chapters           []Chapter     `json:"chapters"`
+	Formats            []VideoFormat `json:"formats"`
+	Subtitles          []string      `json:"subtitles"`           // manual subtitle languages
+	AutomaticSubtitles []string      `json:"automatic_subtitles"` // auto-generated languages
+}
+
+// Chapter is a chapter marker of the source video, in seconds
+type Chapter struct {
+	Title string  `json:"title"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// VideoFormat summarizes the video streams of one resolution and codec.
+// EstimatedSize includes the best audio stream, 0 when yt-dlp knows no size.
+type VideoFormat struct {
+	Height        int     `json:"height"`
+	Width         int     `json:"width"`
+	FPS           float64 `json:"fps,omitempty"`
+	VideoCodec    string  `json:"video_codec"` // codec family: avc1, vp9, av01, ...
+	Ext           string  `json:"ext"`
+	EstimatedSize int64   `json:"estimated_size"`
+}
+
+// ytDlpInfo is the part of yt-dlp's -J output we read
+type ytDlpInfo struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Channel    string  `json:"channel"`
+	Uploader   string  `json:"uploader"`
+	ChannelURL string  `json:"channel_url"`
+	Duration   float64 `json:"duration"`
+	Thumbnail  string  `json:"thumbnail"`
+	LiveStatus string  `json:"live_status"`
+	IsLive     bool    `json:"is_live"`
+	Chapters   []struct {
+		Title     string  `json:"title"`
+		StartTime float64 `json:"start_time"`
+		EndTime   float64 `json:"end_time"`
+	} `json:"chapters"`
+	Formats []struct {
+		Height         int     `json:"height"`
+		Width          int     `json:"width"`
+		FPS            float64 `json:"fps"`
+		VCodec         string  `json:"vcodec"`
+		ACodec         string  `json:"acodec"`
+		Ext            string  `json:"ext"`
+		Filesize       int64   `json:"filesize"`
+		FilesizeApprox int64   `json:"filesize_approx"`
+		TBR            float64 `json:"tbr"` // kbit/s
+	} `json:"formats"`
+	Subtitles         map[string]json.RawMessage `json:"subtitles"`
+	AutomaticCaptions map[string]json.RawMessage `json:"automatic_captions"`
+}
+
+// ProbeVideo asks yt-dlp for a video's metadata and formats without downloading it
+func ProbeVideo(ctx context.Context, videoURL string) (*VideoInfo, error) {
+	cmd := exec.CommandContext(ctx, ytDlpPath, "--no-playlist", "--skip-download", "-J", videoURL)
+	killProcessTree(cmd)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, ytDlpReadError("yt-dlp probe", err, stderrBuf.String())
+	}
+
+	var raw ytDlpInfo
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse yt-dlp metadata: %w", err)
+	}
+	return newVideoInfo(raw), nil
+}
+
+func newVideoInfo(raw ytDlpInfo) *VideoInfo {
+	info := &VideoInfo{
+		ID:                 raw.ID,
+		Title:              raw.Title,
+		Channel:            raw.Channel,
+		ChannelURL:         raw.ChannelURL,
+		Duration:           raw.Duration,
+		Thumbnail:          raw.Thumbnail,
+		LiveStatus:         raw.LiveStatus,
+		IsLive:             raw.IsLive || raw.LiveStatus == "is_live",
+		Chapters:           []Chapter{},
+		Subtitles:          subtitleLanguages(raw.Subtitles),
+		AutomaticSubtitles: subtitleLanguages(raw.AutomaticCaptions),
+	}
+	if info.Channel == "" {
+		info.Channel = raw.Uploader
+	}
+	for _, ch := range raw.Chapters {
+		info.Chapters = append(info.Chapters, Chapter{Title: ch.Title, Start: ch.StartTime, End: ch.EndTime})
+	}
+
+	size := func(filesize, approx int64, tbr float64) int64 {
+		switch {
+		case filesize > 0:
+			return filesize
+		case approx > 0:
+			return approx
+		case tbr > 0 && raw.Duration > 0:
+			return int64(tbr * 1000 / 8 * raw.Duration)
+		}
+		return 0
+	}
+
+	// The downloads pair video with the best audio, count it in every estimate
+	var audioSize int64
+	for _, f := range raw.Formats {
+		if f.VCodec == "none" && f.ACodec != "none" && f.ACodec != "" {
+			if s := size(f.Filesize, f.FilesizeApprox, f.TBR); s > audioSize {
+				audioSize = s
+			}
+		}
+	}
+
+	byKey := map[string]*VideoFormat{}
+	for _, f := range raw.Formats {
+		if f.Height == 0 || f.VCodec == "" || f.VCodec == "none" {
+			continue
+		}
+		codec := codecFamily(f.VCodec)
+		key := fmt.Sprintf("%d/%s", f.Height, codec)
+
+		estimate := size(f.Filesize, f.FilesizeApprox, f.TBR)
+		if estimate > 0 && (f.ACodec == "none" || f.ACodec == "") {
+			estimate += audioSize
+		}
+
+		current, ok := byKey[key]
+		if !ok {
+			current = &VideoFormat{Height: f.Height, Width: f.Width, VideoCodec: codec, Ext: f.Ext}
+			byKey[key] = current
+		}
+		// Keep the largest variant, the quality presets prefer the highest bitrate
+		if estimate > current.EstimatedSize {
+			current.EstimatedSize = estimate
+			current.Width = f.Width
+			current.Ext = f.Ext
+		}
+		if f.FPS > current.FPS {
+			current.FPS = f.FPS
+		}
+	}
+
+	info.Formats = make([]VideoFormat, 0, len(byKey))
+	for _, f := range byKey {
+		info.Formats = append(info.Formats, *f)
+	}
+	sort.Slice(info.Formats, func(i, j int) bool {
+		if info.Formats[i].Height != info.Formats[j].Height {
+			return info.Formats[i].Height > info.Formats[j].Height
+		}
+		return info.Formats[i].VideoCodec < info.Formats[j].VideoCodec
+	})
+	return info
+}
+
+// codecFamily shortens a codec string like "avc1.640028" to "avc1", the same as the file name labels
+func codecFamily(vcodec string) string {
+	family, _, _ := strings.Cut(vcodec, ".")
+	return family
+}
+
+// subtitleLanguages lists the languages of a yt-dlp subtitle map, sorted, "live_chat" is not a subtitle
+func subtitleLanguages(tracks map[string]json.RawMessage) []string {
+	langs := []string{}
+	for lang := range tracks {
+		if lang != "live_chat" {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
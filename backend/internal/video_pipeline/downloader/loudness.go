@@ -0,0 +1,215 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Defaults and bounds for audio processing
+const (
+	DefaultTargetLUFS = -14.0 // what the big streaming platforms normalize to
+	MinTargetLUFS     = -70.0
+	MaxTargetLUFS     = -5.0
+	MaxFadeSeconds    = 30.0
+	// True peak ceiling and loudness range handed to loudnorm
+	loudnormTruePeak = -1.5
+	loudnormRange    = 11.0
+)
+
+// AudioProcessing is the loudness, fade and channel work done on the audio track of an output
+type AudioProcessing struct {
+	Normalize  bool    // EBU R128 loudness normalization to TargetLUFS
+	TargetLUFS float64 // integrated loudness target, only used with Normalize
+	FadeIn     float64 // seconds, 0 for none
+	FadeOut    float64 // seconds, 0 for none
+	Stereo     bool    // downmix to two channels
+}
+
+// Loudness is what loudnorm measured before and after normalization, in LUFS, dBTP and LU
+type Loudness struct {
+	Target    float64
+	InputI    float64
+	InputTP   float64
+	InputLRA  float64
+	OutputI   float64
+	OutputTP  float64
+	OutputLRA float64
+}
+
+// NewAudioProcessing validates the audio processing of a job, a zero target takes the default
+func NewAudioProcessing(normalize bool, targetLUFS, fadeIn, fadeOut float64, stereo bool) (AudioProcessing, error) {
+	if targetLUFS != 0 && !normalize {
+		return AudioProcessing{}, fmt.Errorf("a target loudness requires normalization")
+	}
+	if normalize {
+		if targetLUFS == 0 {
+			targetLUFS = DefaultTargetLUFS
+		}
+		if targetLUFS < MinTargetLUFS || targetLUFS > MaxTargetLUFS {
+			return AudioProcessing{}, fmt.Errorf("target loudness must be between %g and %g LUFS", MinTargetLUFS, MaxTargetLUFS)
+		}
+	}
+	if fadeIn < 0 || fadeIn > MaxFadeSeconds || fadeOut < 0 || fadeOut > MaxFadeSeconds {
+		return AudioProcessing{}, fmt.Errorf("fades must be between 0 and %g seconds", MaxFadeSeconds)
+	}
+	return AudioProcessing{
+		Normalize:  normalize,
+		TargetLUFS: targetLUFS,
+		FadeIn:     fadeIn,
+		FadeOut:    fadeOut,
+		Stereo:     stereo,
+	}, nil
+}
+
+// Enabled reports whether the job touches its audio track after the download
+func (a AudioProcessing) Enabled() bool {
+	return a.Normalize || a.FadeIn > 0 || a.FadeOut > 0 || a.Stereo
+}
+
+// audioEncoders maps the ffprobe name of an audio codec to the encoder writing it again,
+// processing keeps the codec the output already has so the container still fits
+var audioEncoders = map[string]string{
+	"aac":    "aac",
+	"mp3":    "libmp3lame",
+	"opus":   "libopus",
+	"vorbis": "libvorbis",
+	"ac3":    "ac3",
+	"eac3":   "eac3",
+	"flac":   "flac",
+	"alac":   "alac",
+}
+
+// processAudio runs the audio processing over mediaPath in place. bitrate (kbps) is used for
+// lossy codecs, 0 takes the default. The loudness is nil unless the job normalizes.
+func processAudio(ctx context.Context, mediaPath string, a AudioProcessing, bitrate int) (*Loudness, error) {
+	info, err := probeMedia(ctx, mediaPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.AudioCodec == "" {
+		return nil, fmt.Errorf("output has no audio track")
+	}
+
+	var filters []string
+	if a.Stereo {
+		filters = append(filters, "aformat=channel_layouts=stereo")
+	}
+
+	var loudness *Loudness
+	if a.Normalize {
+		// First pass only measures, the second applies one gain computed from the measurement
+		measure := strings.Join(append(slices.Clip(filters), loudnormFilter(a.TargetLUFS, nil)), ",")
+		output, err := runFFmpegLog(ctx, "-i", mediaPath, "-map", "0:a:0", "-af", measure, "-f", "null", "-")
+		if err != nil {
+			return nil, err
+		}
+		measured, err := parseLoudnorm(output)
+		if err != nil {
+			return nil, err
+		}
+		// Silence measures as -inf, there is nothing to bring up
+		if inputI, _ := strconv.ParseFloat(measured["input_i"], 64); !math.IsInf(inputI, 0) {
+			filters = append(filters, loudnormFilter(a.TargetLUFS, measured))
+			loudness = &Loudness{Target: a.TargetLUFS}
+		}
+	}
+
+	fadeIn, fadeOut := a.FadeIn, a.FadeOut
+	if info.Duration > 0 && fadeIn+fadeOut > info.Duration {
+		// Shrink both fades alike so they meet instead of overlapping
+		scale := info.Duration / (fadeIn + fadeOut)
+		fadeIn, fadeOut = fadeIn*scale, fadeOut*scale
+	}
+	if fadeIn > 0 {
+		filters = append(filters, "afade=t=in:st=0:d="+formatSeconds(fadeIn))
+	}
+	if fadeOut > 0 && info.Duration > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=out:st=%s:d=%s", formatSeconds(info.Duration-fadeOut), formatSeconds(fadeOut)))
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	encoder, ok := audioEncoders[info.AudioCodec]
+	if !ok && strings.HasPrefix(info.AudioCodec, "pcm_") {
+		encoder, ok = info.AudioCodec, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("cannot re-encode %s audio", info.AudioCodec)
+	}
+	// loudnorm resamples to 192 kHz internally, bring it back to what the source had
+	sampleRate := info.SampleRate
+	if sampleRate == 0 || encoder == "libopus" {
+		sampleRate = 48000
+	}
+
+	args := []string{
+		"-i", mediaPath,
+		"-map", "0:v?", "-map", "0:a", "-map", "0:s?", "-map_metadata", "0", "-map_chapters", "0",
+		"-c", "copy", "-af", strings.Join(filters, ","),
+		"-c:a", encoder, "-ar", strconv.Itoa(sampleRate),
+	}
+	if lossless := encoder == "flac" || encoder == "alac" || strings.HasPrefix(encoder, "pcm_"); !lossless {
+		if bitrate == 0 {
+			bitrate = DefaultAudioBitrate
+		}
+		args = append(args, "-b:a", fmt.Sprintf("%dk", bitrate))
+	}
+	if loudness == nil {
+		return nil, ffmpegReplace(ctx, mediaPath, args)
+	}
+
+	output, err := ffmpegReplaceLog(ctx, mediaPath, args)
+	if err != nil {
+		return nil, err
+	}
+	result, err := parseLoudnorm(output)
+	if err != nil {
+		return nil, err
+	}
+	measured := []struct {
+		dst *float64
+		key string
+	}{
+		{&loudness.InputI, "input_i"}, {&loudness.InputTP, "input_tp"}, {&loudness.InputLRA, "input_lra"},
+		{&loudness.OutputI, "output_i"}, {&loudness.OutputTP, "output_tp"}, {&loudness.OutputLRA, "output_lra"},
+	}
+	for _, m := range measured {
+		v, err := strconv.ParseFloat(result[m.key], 64)
+		if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+			continue
+		}
+		*m.dst = math.Round(v*100) / 100
+	}
+	return loudness, nil
+}
+
+// loudnormFilter builds the loudnorm filter, measured holds the first pass values for the second
+func loudnormFilter(target float64, measured map[string]string) string {
+	filter := fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s",
+		formatSeconds(target), formatSeconds(loudnormTruePeak), formatSeconds(loudnormRange))
+	if measured != nil {
+		filter += fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+			measured["input_i"], measured["input_tp"], measured["input_lra"], measured["input_thresh"], measured["target_offset"])
+	}
+	return filter + ":print_format=json"
+}
+
+// parseLoudnorm reads the JSON block loudnorm prints at the end of the ffmpeg log
+func parseLoudnorm(output string) (map[string]string, error) {
+	start := strings.LastIndex(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("loudnorm printed no measurement")
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(output[start:end+1]), &values); err != nil {
+		return nil, fmt.Errorf("failed to parse loudnorm measurement: %w", err)
+	}
+	return values, nil
+}
@@ -0,0 +1,21 @@
+package downloader
+
+// metadataArgs makes yt-dlp tag the output with its provenance: title, uploader,
+// source URL and upload date, plus the clip range for time range jobs
+func metadataArgs(thumbnail bool, clipRange string) []string {
+	args := []string{
+		"--embed-metadata",
+		"--parse-metadata", "%(uploader)s:%(meta_uploader)s",
+		"--parse-metadata", "%(webpage_url)s:%(meta_source_url)s",
+		"--parse-metadata", "%(upload_date)s:%(meta_upload_date)s",
+	}
+	if clipRange != "" {
+		// clipRange has no colons (e.g. "00h01m30s-00h02m00s") so it parses as a literal template
+		args = append(args, "--parse-metadata", clipRange+":%(meta_clip_range)s")
+	}
+	if thumbnail {
+		// mp4 and m4a only take jpg/png cover art
+		args = append(args, "--embed-thumbnail", "--convert-thumbnails", "jpg")
+	}
+	return args
+}
@@ -0,0 +1,289 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Segment is one named range of a multi-range job, in source seconds
+type Segment struct {
+	Name  string
+	Begin int
+	End   int
+}
+
+// MultiRangeResult is what a multi-range job produced, Segments and Errors have one entry per
+// requested segment: the result of a segment that finished or the error it failed with.
+// Joined is set when the segments were concatenated into one file.
+type MultiRangeResult struct {
+	Segments []*Result
+	Errors   []error
+	Joined   *Result
+}
+
+// MultiRangeFHD cuts several segments from one source in a single yt-dlp run, so the video is
+// resolved once. Segments are saved as "<jobID>.<n>.<ext>"; with join they are concatenated
+// into "<jobID>.<ext>" and their own files removed. Segments that finished are reported even
+// when others failed or the job was cancelled, the returned error is for the job as a whole.
+func MultiRangeFHD(ctx context.Context, videoURL, jobID string, segments []Segment, join bool, opts Options, onProgress ProgressFunc) (*MultiRangeResult, error) {
+	ctx, cancel := opts.Timeouts.withTotal(ctx)
+	defer cancel()
+
+	files, err := newPrintFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer files.cleanup()
+
+	// Whatever is left in the parts dir is partial, drop it however the job ends
+	partsDir, err := newPartsDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(partsDir)
+
+	args := append([]string{"--no-playlist"}, opts.formatArgs()...)
+	args = append(args, opts.Subtitles.args(true)...)
+	// Evaluated for each segment, the values are in seconds
+	args = append(args, metadataArgs(opts.Thumbnail, "%(section_start)s-%(section_end)s")...)
+	args = append(args, files.arg("sections", "after_move:%(section_number)s|%(filepath)s")...)
+	args = append(args, files.arg("title", "after_move:%(title)s")...)
+	args = append(args, files.sourceFormatArg()...)
+	args = append(args, pathArgs(partsDir)...)
+	for _, seg := range segments {
+		args = append(args, "--download-sections", fmt.Sprintf("*%d-%d", seg.Begin, seg.End))
+	}
+	if opts.Cut == CutReencode {
+		args = append(args, "--force-keyframes-at-cuts")
+	}
+	args = append(args,
+		"-o", jobID+".%(section_number)s.%(ext)s",
+		videoURL,
+	)
+	tracker := newProgressTracker(onProgress)
+	defer tracker.wait()
+	_, runErr := runYtDlp(ctx, args, tracker, opts.Timeouts.Stall)
+
+	paths, err := readSectionPaths(files, len(segments))
+	if err != nil {
+		return nil, err
+	}
+	result := &MultiRangeResult{
+		Segments: make([]*Result, len(segments)),
+		Errors:   make([]error, len(segments)),
+	}
+	// Segments that made it through post-processing are kept, the others are dropped
+	stopped := func() (*MultiRangeResult, error) {
+		for i, path := range paths {
+			if result.Segments[i] == nil {
+				if path != "" {
+					os.Remove(path)
+				}
+				if result.Errors[i] == nil {
+					result.Errors[i] = context.Cause(ctx)
+				}
+			}
+		}
+		return result, context.Cause(ctx)
+	}
+	if ctx.Err() != nil {
+		return stopped()
+	}
+	title, _ := files.read("title")
+	title, _, _ = strings.Cut(title, "\n")
+
+	for i, seg := range segments {
+		path := paths[i]
+		if path == "" {
+			result.Errors[i] = runErr
+			if result.Errors[i] == nil {
+				result.Errors[i] = fmt.Errorf("yt-dlp did not report the file for segment %q", seg.Name)
+			}
+			continue
+		}
+
+		tracker.enterSection(PhasePostProcessing, i+1)
+		var notice string
+		if opts.Subtitles.Enabled() {
+			// Subtitles come for the whole video, line them up with the segment
+			clip := &window{begin: float64(seg.Begin), end: float64(seg.End)}
+			if err := processSubtitles(ctx, path, opts.Subtitles, clip, opts.Encoding); opts.Subtitles.missingSidecar(err) {
+				notice = opts.Subtitles.noSubtitlesNotice()
+			} else if err != nil {
+				if ctx.Err() != nil {
+					return stopped()
+				}
+				os.Remove(path)
+				paths[i] = ""
+				result.Errors[i] = err
+				continue
+			}
+		}
+
+		if opts.Watermark.Enabled() {
+			if err := watermarkVideo(ctx, path, opts.Watermark, opts.Encoding); err != nil {
+				if ctx.Err() != nil {
+					return stopped()
+				}
+				os.Remove(path)
+				paths[i] = ""
+				result.Errors[i] = fmt.Errorf("watermark failed: %w", err)
+				continue
+			}
+		}
+
+		if opts.Encoding.Enabled() {
+			converted, err := convertOutput(ctx, path, opts.Encoding)
+			if err != nil {
+				if ctx.Err() != nil {
+					return stopped()
+				}
+				os.Remove(path)
+				paths[i] = ""
+				result.Errors[i] = fmt.Errorf("conversion failed: %w", err)
+				continue
+			}
+			path, paths[i] = converted, converted
+		}
+
+		var loudness *Loudness
+		if opts.AudioProcessing.Enabled() {
+			var err error
+			if loudness, err = processAudio(ctx, path, opts.AudioProcessing, opts.Audio.Bitrate); err != nil {
+				if ctx.Err() != nil {
+					return stopped()
+				}
+				os.Remove(path)
+				paths[i] = ""
+				result.Errors[i] = fmt.Errorf("audio processing failed: %w", err)
+				continue
+			}
+		}
+
+		res := &Result{Duration: float64(seg.End - seg.Begin), Loudness: loudness, Notice: notice}
+		if err := describeOutput(ctx, res, path); err != nil {
+			if ctx.Err() != nil {
+				return stopped()
+			}
+			// The file is fine, only its details are missing
+			log.Printf("MultiRangeFHD - describeOutput error: %v", err)
+		}
+		if cutStart, cutEnd, err := cutBounds(ctx, path, float64(seg.Begin)); err == nil {
+			res.CutStart, res.CutEnd = cutStart, cutEnd
+		}
+		res.FileName = displayName(title+" ("+seg.Name+")", path)
+		files.sourceFormat(res)
+		result.Segments[i] = res
+	}
+
+	if join {
+		joined, err := joinSegments(ctx, jobID, title, segments, paths, result.Errors)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The segments were cut, they are kept as separate files
+				return stopped()
+			}
+			return result, err
+		}
+		// The segments only live on inside the joined file
+		removeFiles(paths)
+		for _, res := range result.Segments {
+			res.Path = ""
+		}
+		result.Joined = joined
+	}
+
+	if runErr != nil {
+		return result, runErr
+	}
+	return result, nil
+}
+
+// readSectionPaths maps the "<section number>|<path>" lines yt-dlp printed to segment order,
+// segments it did not finish are left empty
+func readSectionPaths(files *printFiles, count int) ([]string, error) {
+	data, err := files.read("sections")
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, count)
+	for _, line := range strings.Split(data, "\n") {
+		number, path, ok := strings.Cut(strings.TrimSpace(line), "|")
+		n, err := strconv.Atoi(number)
+		if !ok || err != nil || n < 1 || n > count || path == "" {
+			continue
+		}
+		paths[n-1] = path
+	}
+	return paths, nil
+}
+
+// joinSegments concatenates the segment files in order without re-encoding, they all come from
+// the same source formats. Cover art does not survive the join.
+func joinSegments(ctx context.Context, jobID, title string, segments []Segment, paths []string, errs []error) (*Result, error) {
+	for i, path := range paths {
+		if path == "" {
+			return nil, fmt.Errorf("cannot join segments, %q failed: %w", segments[i].Name, errs[i])
+		}
+	}
+
+	listFile, err := os.CreateTemp("", "clippy-concat-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create concat list: %w", err)
+	}
+	defer os.Remove(listFile.Name())
+	var ranges []string
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			listFile.Close()
+			return nil, err
+		}
+		fmt.Fprintf(listFile, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+		ranges = append(ranges, fmt.Sprintf("%d-%d", segments[i].Begin, segments[i].End))
+	}
+	if err := listFile.Close(); err != nil {
+		return nil, err
+	}
+
+	joinedPath := filepath.Join(filepath.Dir(paths[0]), jobID+filepath.Ext(paths[0]))
+	err = runFFmpeg(ctx,
+		"-f", "concat", "-safe", "0", "-i", listFile.Name(),
+		"-map", "0:V?", "-map", "0:a?", "-map", "0:s?",
+		"-map_metadata", "0",
+		"-metadata", "clip_range="+strings.Join(ranges, ","),
+		"-c", "copy",
+		joinedPath,
+	)
+	if err != nil {
+		os.Remove(joinedPath)
+		return nil, err
+	}
+
+	res := &Result{}
+	for _, seg := range segments {
+		res.Duration += float64(seg.End - seg.Begin)
+	}
+	if err := describeOutput(ctx, res, joinedPath); err != nil {
+		if ctx.Err() != nil {
+			os.Remove(joinedPath)
+			return nil, context.Cause(ctx)
+		}
+		log.Printf("joinSegments - describeOutput error: %v", err)
+	}
+	res.FileName = displayName(title+" (highlights)", joinedPath)
+	return res, nil
+}
+
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		if path != "" {
+			os.Remove(path)
+		}
+	}
+}
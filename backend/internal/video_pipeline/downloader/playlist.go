@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrNotPlaylist is returned when a URL expanded as a playlist points at a single video
+var ErrNotPlaylist = errors.New("url is not a playlist")
+
+// Playlist is a playlist or channel tab and the entries selected from it
+type Playlist struct {
+	Title   string
+	Entries []PlaylistEntry
+}
+
+// PlaylistEntry is one video of a playlist, Index is its 1-based position in the playlist
+type PlaylistEntry struct {
+	Index    int
+	URL      string
+	Title    string
+	Duration float64
+}
+
+// PlaylistRange selects entries by 1-based position. Zero End means up to the last entry,
+// MaxItems caps how many entries are taken from Start on.
+type PlaylistRange struct {
+	Start    int
+	End      int
+	MaxItems int
+}
+
+// items returns the --playlist-items value for the range
+func (r PlaylistRange) items() string {
+	start := r.Start
+	if start < 1 {
+		start = 1
+	}
+	end := r.End
+	if r.MaxItems > 0 && (end == 0 || end > start+r.MaxItems-1) {
+		end = start + r.MaxItems - 1
+	}
+	if end == 0 {
+		return strconv.Itoa(start) + ":"
+	}
+	return fmt.Sprintf("%d:%d", start, end)
+}
+
+// ExpandPlaylist lists the entries of a playlist or channel tab without resolving each video
+func ExpandPlaylist(ctx context.Context, playlistURL string, rng PlaylistRange) (*Playlist, error) {
+	cmd := exec.CommandContext(ctx, ytDlpPath,
+		"--yes-playlist", "--flat-playlist",
+		"--playlist-items", rng.items(),
+		"-J", playlistURL,
+	)
+	killProcessTree(cmd)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, ytDlpReadError("yt-dlp playlist expansion", err, stderrBuf.String())
+	}
+
+	var raw struct {
+		Type    string `json:"_type"`
+		Title   string `json:"title"`
+		Entries []struct {
+			URL           string  `json:"url"`
+			WebpageURL    string  `json:"webpage_url"`
+			Title         string  `json:"title"`
+			Duration      float64 `json:"duration"`
+			PlaylistIndex int     `json:"playlist_index"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse yt-dlp playlist: %w", err)
+	}
+	if raw.Type != "playlist" {
+		return nil, ErrNotPlaylist
+	}
+
+	playlist := &Playlist{Title: raw.Title}
+	for i, e := range raw.Entries {
+		entryURL := e.WebpageURL
+		if entryURL == "" {
+			entryURL = e.URL
+		}
+		// Flat entries of some extractors only carry IDs, those cannot be downloaded on their own
+		if !strings.HasPrefix(entryURL, "http://") && !strings.HasPrefix(entryURL, "https://") {
+			continue
+		}
+		index := e.PlaylistIndex
+		if index == 0 {
+			index = rng.Start + i
+			if rng.Start < 1 {
+				index = i + 1
+			}
+		}
+		playlist.Entries = append(playlist.Entries, PlaylistEntry{
+			Index:    index,
+			URL:      entryURL,
+			Title:    e.Title,
+			Duration: e.Duration,
+		})
+	}
+	return playlist, nil
+}
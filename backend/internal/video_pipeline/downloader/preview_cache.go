@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// previewEntry is one top level dir of the preview cache: a video's or a job's previews
+type previewEntry struct {
+	path    string
+	size    int64
+	touched time.Time // newest modification inside the dir
+}
+
+// PrunePreviews removes cached previews, the dirs least recently written first, until the
+// cache takes at most maxBytes. Dirs written within minAge are kept, a job may still be
+// filling them. It returns the number of bytes freed.
+func PrunePreviews(maxBytes int64, minAge time.Duration) (int64, error) {
+	dirs, err := os.ReadDir(previewDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var entries []previewEntry
+	var total int64
+	for _, dir := range dirs {
+		entry := previewEntry{path: filepath.Join(previewDir, dir.Name())}
+		filepath.WalkDir(entry.path, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if !d.IsDir() {
+				entry.size += info.Size()
+			}
+			if info.ModTime().After(entry.touched) {
+				entry.touched = info.ModTime()
+			}
+			return nil
+		})
+		entries = append(entries, entry)
+		total += entry.size
+	}
+	if total <= maxBytes {
+		return 0, nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].touched.Before(entries[j].touched) })
+	var freed int64
+	cutoff := time.Now().Add(-minAge)
+	for _, entry := range entries {
+		if total-freed <= maxBytes || entry.touched.After(cutoff) {
+			break
+		}
+		if err := os.RemoveAll(entry.path); err != nil {
+			return freed, err
+		}
+		freed += entry.size
+	}
+	return freed, nil
+}
@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mediaInfo is what ffprobe reports about a finished file
+type mediaInfo struct {
+	Duration   float64
+	Width      int
+	Height     int
+	VideoCodec string
+	AudioCodec string
+	SampleRate int // of the first audio stream
+}
+
+// probeMedia reads duration, resolution and codecs of the first video and audio streams.
+// Cover art is stored as a video stream too, it is skipped.
+func probeMedia(ctx context.Context, path string) (mediaInfo, error) {
+	cmd := exec.CommandContext(ctx, ffprobePath,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format", "-show_streams",
+		path,
+	)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return mediaInfo{}, context.Cause(ctx)
+		}
+		return mediaInfo{}, fmt.Errorf("ffprobe failed: %w: %s", err, lastLines(stderrBuf.String(), 3))
+	}
+
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType   string `json:"codec_type"`
+			CodecName   string `json:"codec_name"`
+			Width       int    `json:"width"`
+			Height      int    `json:"height"`
+			SampleRate  string `json:"sample_rate"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return mediaInfo{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var info mediaInfo
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	for _, s := range probe.Streams {
+		switch {
+		case s.CodecType == "video" && s.Disposition.AttachedPic == 0 && info.VideoCodec == "":
+			info.VideoCodec = s.CodecName
+			info.Width, info.Height = s.Width, s.Height
+		case s.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec = s.CodecName
+			info.SampleRate, _ = strconv.Atoi(s.SampleRate)
+		}
+	}
+	return info, nil
+}
+
+// describeOutput fills the file fields of result from the finished file at path
+func describeOutput(ctx context.Context, result *Result, path string) error {
+	result.Path = path
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat output file: %w", err)
+	}
+	result.Size = stat.Size()
+	result.Container = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+
+	info, err := probeMedia(ctx, path)
+	if err != nil {
+		return err
+	}
+	if info.Duration > 0 {
+		result.Duration = info.Duration
+	}
+	result.Width, result.Height = info.Width, info.Height
+	result.VideoCodec, result.AudioCodec = info.VideoCodec, info.AudioCodec
+	return nil
+}
+
+// displayName turns the title yt-dlp printed into a file name clients can save the output as
+func displayName(printed, path string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(printed))
+	if name == "" {
+		return filepath.Base(path)
+	}
+	return name + filepath.Ext(path)
+}
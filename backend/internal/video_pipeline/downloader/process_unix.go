@@ -0,0 +1,17 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessTree puts cmd in its own process group and makes context cancellation
+// kill the whole group, so ffmpeg children spawned by yt-dlp die with it
+func killProcessTree(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
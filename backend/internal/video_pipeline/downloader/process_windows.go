@@ -0,0 +1,13 @@
+package downloader
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// killProcessTree makes context cancellation kill cmd and every child it spawned
+func killProcessTree(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	}
+}
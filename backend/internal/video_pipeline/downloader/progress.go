@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phases reported while a job runs
+const (
+	PhaseDownloadingVideo = "downloading_video"
+	PhaseDownloadingAudio = "downloading_audio"
+	PhaseMerging          = "merging"
+	PhasePostProcessing   = "post_processing"
+)
+
+// progressInterval limits how often download progress is reported, phase changes are always reported
+const progressInterval = time.Second
+
+// Progress is a snapshot of a running job. Byte counts and percent are for the stream being downloaded.
+type Progress struct {
+	Phase           string
+	Percent         float64
+	DownloadedBytes int64
+	TotalBytes      int64
+	Speed           float64 // bytes per second
+	ETA             int     // seconds, -1 when unknown
+	Section         int     // 1-based segment of multi-range jobs, 0 otherwise
+}
+
+// ProgressFunc receives progress updates, it may be nil
+type ProgressFunc func(Progress)
+
+const progressPrefix = "[clippy-progress]"
+
+// progressTemplate prints one machine readable line per progress tick (see --progress-template)
+var progressTemplate = "download:" + progressPrefix +
+	" %(progress.downloaded_bytes)s|%(progress.total_bytes,progress.total_bytes_estimate)s|%(progress.speed)s|%(progress.eta)s|%(info.vcodec)s|%(info.section_number)s"
+
+func progressArgs() []string {
+	return []string{"--newline", "--progress-template", progressTemplate}
+}
+
+var postProcessorPattern = regexp.MustCompile(`^\[(Merger|ExtractAudio|SponsorBlock|ModifyChapters|EmbedSubtitle|EmbedThumbnail|Metadata|MetadataParser|ThumbnailsConvertor|SubtitlesConvertor|VideoRemuxer|VideoConvertor|Fixup\w+)\]`)
+
+// progressTracker turns yt-dlp output lines into throttled progress updates. Updates are
+// handed to onProgress from a goroutine of their own, a slow callback must not hold up the
+// readers of yt-dlp's output.
+type progressTracker struct {
+	onProgress ProgressFunc
+
+	mu       sync.Mutex // stdout and stderr are read concurrently
+	current  Progress
+	lastSent time.Time
+	// pending is the latest update onProgress has not seen yet, while sending a goroutine is
+	// delivering updates. Updates that pile up behind a slow callback are merged into the latest.
+	pending   *Progress
+	sending   bool
+	delivered sync.WaitGroup
+}
+
+func newProgressTracker(onProgress ProgressFunc) *progressTracker {
+	return &progressTracker{onProgress: onProgress, current: Progress{ETA: -1}}
+}
+
+func (t *progressTracker) handleLine(line string) {
+	if t == nil || t.onProgress == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rest, ok := strings.CutPrefix(line, progressPrefix); ok {
+		t.handleDownload(strings.TrimSpace(rest))
+		return
+	}
+
+	if m := postProcessorPattern.FindStringSubmatch(line); m != nil {
+		phase := PhasePostProcessing
+		if m[1] == "Merger" {
+			phase = PhaseMerging
+		}
+		t.setPhase(phase)
+	}
+}
+
+func (t *progressTracker) handleDownload(fields string) {
+	parts := strings.Split(fields, "|")
+	if len(parts) != 6 {
+		return
+	}
+
+	phase := PhaseDownloadingVideo
+	if parts[4] == "none" {
+		phase = PhaseDownloadingAudio
+	}
+
+	p := Progress{
+		Phase:           phase,
+		DownloadedBytes: int64(parseProgressNumber(parts[0])),
+		TotalBytes:      int64(parseProgressNumber(parts[1])),
+		Speed:           parseProgressNumber(parts[2]),
+		ETA:             -1,
+		Section:         int(parseProgressNumber(parts[5])),
+	}
+	if eta := parseProgressNumber(parts[3]); parts[3] != "NA" {
+		p.ETA = int(eta)
+	}
+	if p.TotalBytes > 0 {
+		p.Percent = float64(p.DownloadedBytes) * 100 / float64(p.TotalBytes)
+		if p.Percent > 100 {
+			p.Percent = 100
+		}
+	}
+
+	phaseChanged := p.Phase != t.current.Phase || p.Section != t.current.Section
+	t.current = p
+	if phaseChanged || p.Percent >= 100 || time.Since(t.lastSent) >= progressInterval {
+		t.send()
+	}
+}
+
+// setPhase switches to a phase without byte counts (merging, post-processing)
+func (t *progressTracker) setPhase(phase string) {
+	if t.current.Phase == phase {
+		return
+	}
+	t.current = Progress{Phase: phase, Percent: 100, ETA: -1, Section: t.current.Section}
+	t.send()
+}
+
+// enter reports a phase for work done outside yt-dlp, such as our own ffmpeg passes
+func (t *progressTracker) enter(phase string) {
+	if t == nil || t.onProgress == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.setPhase(phase)
+}
+
+// enterSection is enter for one segment of a multi-range job
+func (t *progressTracker) enterSection(phase string, section int) {
+	if t == nil || t.onProgress == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current.Section != section {
+		// Report the phase again for the new segment
+		t.current.Section = section
+		t.current.Phase = ""
+	}
+	t.setPhase(phase)
+}
+
+// send queues the current progress for delivery, t.mu is held
+func (t *progressTracker) send() {
+	t.lastSent = time.Now()
+	p := t.current
+	t.pending = &p
+	if !t.sending {
+		t.sending = true
+		t.delivered.Add(1)
+		go t.deliver()
+	}
+}
+
+// deliver hands pending updates to onProgress until none is left
+func (t *progressTracker) deliver() {
+	defer t.delivered.Done()
+	for {
+		t.mu.Lock()
+		p := t.pending
+		t.pending = nil
+		if p == nil {
+			t.sending = false
+			t.mu.Unlock()
+			return
+		}
+		t.mu.Unlock()
+		t.onProgress(*p)
+	}
+}
+
+// wait returns once every update was delivered, so none lands after the job's result
+func (t *progressTracker) wait() {
+	if t == nil {
+		return
+	}
+	t.delivered.Wait()
+}
+
+// parseProgressNumber parses a template value, yt-dlp prints "NA" for unknown fields
+func parseProgressNumber(value string) float64 {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,37 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressTrackerSlowCallback(t *testing.T) {
+	release := make(chan struct{})
+	var got []Progress
+	tracker := newProgressTracker(func(p Progress) {
+		<-release
+		got = append(got, p)
+	})
+
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		tracker.handleLine(progressPrefix + " 10|100|5|9|avc1|NA")
+		tracker.handleLine("[Merger] Merging formats into \"out.mp4\"")
+		tracker.enter(PhasePostProcessing)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("output lines were held up by a blocked progress callback")
+	}
+
+	close(release)
+	tracker.wait()
+	if len(got) == 0 || len(got) > 3 {
+		t.Fatalf("delivered %d updates, want 1 to 3", len(got))
+	}
+	if last := got[len(got)-1]; last.Phase != PhasePostProcessing {
+		t.Errorf("last update phase = %q, want %q", last.Phase, PhasePostProcessing)
+	}
+}
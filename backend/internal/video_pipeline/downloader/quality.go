@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultQuality is used when a request does not ask for a specific preset
+const DefaultQuality = "1080p"
+
+// QualityPreset describes the yt-dlp format selection for a named quality
+type QualityPreset struct {
+	Name       string
+	MaxHeight  int // 0 means no height limit
+	Format     string
+	MuteFormat string // video-only selection, never pulls an audio stream
+	Sort       string
+	Layout     ReframeLayout // vertical presets only, the reframe used when the job asks for none
+}
+
+var qualityPresets = map[string]QualityPreset{
+	"480p":  heightPreset("480p", 480),
+	"720p":  heightPreset("720p", 720),
+	"1080p": heightPreset("1080p", 1080),
+	"1440p": heightPreset("1440p", 1440),
+	"2160p": heightPreset("2160p", 2160),
+	// Vertical presets pull 1080p and reframe it to 9:16, time range jobs only
+	"vertical":      verticalPreset("vertical", ReframeCenter),
+	"vertical-blur": verticalPreset("vertical-blur", ReframeBlur),
+	"best": {
+		Name:       "best",
+		Format:     `bv*+ba*/best`,
+		MuteFormat: `bv`,
+		Sort:       "res,+br",
+	},
+}
+
+// heightPreset prefers h264 + m4a up to the given height and falls back to
+// whatever codec is available at that height (YouTube only serves VP9/AV1 above 1080p)
+func heightPreset(name string, height int) QualityPreset {
+	return QualityPreset{
+		Name:      name,
+		MaxHeight: height,
+		Format: fmt.Sprintf(
+			`bv*[height<=%[1]d][vcodec~=avc1]+ba*[ext=m4a]/bv*[height<=%[1]d]+ba*[ext=m4a]/bv*[height<=%[1]d]+ba*/best[height<=%[1]d]/best`,
+			height,
+		),
+		MuteFormat: fmt.Sprintf(`bv[height<=%[1]d][vcodec~=avc1]/bv[height<=%[1]d]/bv`, height),
+		Sort:       fmt.Sprintf("res:%d,+codec:avc1,+br", height),
+	}
+}
+
+// verticalPreset is the 1080p preset with a default 9:16 layout
+func verticalPreset(name string, layout ReframeLayout) QualityPreset {
+	preset := heightPreset(name, 1080)
+	preset.Layout = layout
+	return preset
+}
+
+// LookupQuality returns the preset registered under name (case-insensitive)
+func LookupQuality(name string) (QualityPreset, error) {
+	preset, ok := qualityPresets[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return QualityPreset{}, fmt.Errorf("unknown quality %q, supported: %s", name, strings.Join(QualityNames(), ", "))
+	}
+	return preset, nil
+}
+
+// QualityNames lists the registered preset names in a stable order
+func QualityNames() []string {
+	names := make([]string, 0, len(qualityPresets))
+	for name := range qualityPresets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		hi, hj := qualityPresets[names[i]].MaxHeight, qualityPresets[names[j]].MaxHeight
+		// "best" has no height limit, keep it last
+		if hi == 0 || hj == 0 {
+			return hj == 0 && hi != 0
+		}
+		if hi == hj {
+			// Vertical presets after the plain one of their height
+			return names[i] < names[j]
+		}
+		return hi < hj
+	})
+	return names
+}
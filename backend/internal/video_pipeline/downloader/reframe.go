@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ReframeLayout is how a landscape clip is fitted into a vertical 9:16 frame
+type ReframeLayout string
+
+const (
+	ReframeNone ReframeLayout = ""
+	// ReframeCenter crops the middle of the frame
+	ReframeCenter ReframeLayout = "center"
+	// ReframeCrop crops at a caller chosen horizontal position
+	ReframeCrop ReframeLayout = "crop"
+	// ReframeBlur fits the whole frame over a blurred, zoomed copy of itself
+	ReframeBlur ReframeLayout = "blur"
+)
+
+// Vertical output size, the one Shorts, Reels and TikTok all take
+const (
+	reframeWidth  = 1080
+	reframeHeight = 1920
+)
+
+// Reframe is the vertical layout of a clip
+type Reframe struct {
+	Layout ReframeLayout
+	// CropX places the crop with ReframeCrop: 0 is the left edge of the source, 1 the right edge
+	CropX float64
+}
+
+// ReframeLayoutNames lists the valid layouts
+func ReframeLayoutNames() []string {
+	return []string{string(ReframeCenter), string(ReframeCrop), string(ReframeBlur)}
+}
+
+// NewReframe validates a layout, cropX is only used (and required) by ReframeCrop
+func NewReframe(layout string, cropX *float64) (Reframe, error) {
+	switch l := ReframeLayout(strings.ToLower(strings.TrimSpace(layout))); l {
+	case ReframeNone:
+		if cropX != nil {
+			return Reframe{}, fmt.Errorf("a crop offset requires layout %q", ReframeCrop)
+		}
+		return Reframe{}, nil
+	case ReframeCenter, ReframeBlur:
+		if cropX != nil {
+			return Reframe{}, fmt.Errorf("a crop offset requires layout %q", ReframeCrop)
+		}
+		return Reframe{Layout: l, CropX: 0.5}, nil
+	case ReframeCrop:
+		if cropX == nil {
+			return Reframe{}, fmt.Errorf("layout %q requires a crop offset", ReframeCrop)
+		}
+		if *cropX < 0 || *cropX > 1 {
+			return Reframe{}, fmt.Errorf("crop offset must be between 0 (left) and 1 (right)")
+		}
+		return Reframe{Layout: l, CropX: *cropX}, nil
+	}
+	return Reframe{}, fmt.Errorf("unknown reframe layout %q, expected one of: %s", layout, strings.Join(ReframeLayoutNames(), ", "))
+}
+
+// Enabled reports whether the clip is reframed
+func (r Reframe) Enabled() bool {
+	return r.Layout != ReframeNone
+}
+
+// filterGraph returns the ffmpeg filter graph turning the main video of input 0 into the [v] output
+func (r Reframe) filterGraph() string {
+	if r.Layout == ReframeBlur {
+		return fmt.Sprintf(
+			"[0:V:0]split[bg][fg];"+
+				"[bg]scale=%[1]d:%[2]d:force_original_aspect_ratio=increase,crop=%[1]d:%[2]d,boxblur=20:2[bg];"+
+				"[fg]scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease[fg];"+
+				"[bg][fg]overlay=(W-w)/2:(H-h)/2,setsar=1[v]",
+			reframeWidth, reframeHeight,
+		)
+	}
+	// The widest 9:16 window the source allows, slid across the slack by CropX
+	return fmt.Sprintf(
+		"[0:V:0]crop='min(iw,ih*9/16)':'min(ih,iw*16/9)':'(iw-ow)*%s':'(ih-oh)/2',"+
+			"scale=%d:%d:flags=lanczos,setsar=1[v]",
+		formatSeconds(r.CropX), reframeWidth, reframeHeight,
+	)
+}
+
+// reframeVideo re-encodes the clip at mediaPath into the vertical frame in place, with the job's
+// codec when it asked for one
+func reframeVideo(ctx context.Context, mediaPath string, r Reframe, e Encoding) error {
+	args := []string{
+		"-i", mediaPath,
+		"-filter_complex", r.filterGraph(),
+		"-map", "[v]", "-map", "0:a?", "-map", "0:s?", "-map_metadata", "0", "-map_chapters", "0",
+	}
+	args = append(args, e.filterVideoArgs()...)
+	args = append(args, "-c:a", "copy", "-c:s", "copy")
+	return ffmpegReplace(ctx, mediaPath, args)
+}
@@ -0,0 +1,244 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ImageFormat is the file format of a snapshot
+type ImageFormat string
+
+const (
+	ImageJPEG ImageFormat = "jpg"
+	ImagePNG  ImageFormat = "png"
+)
+
+// Snapshot bounds, a zero width keeps the frame at its source size
+const (
+	MinSnapshotWidth = 16
+	MaxSnapshotWidth = 1920
+	// Frames are taken from the source stream of at most this height
+	snapshotSourceHeight = 1080
+)
+
+var (
+	// ErrPastEnd is returned when a frame is asked for past the end of the video
+	ErrPastEnd = errors.New("timestamp is past the end of the video")
+	// ErrNoVideoTrack is returned when the file has no video to take frames from
+	ErrNoVideoTrack = errors.New("output has no video track")
+)
+
+// previewDir holds cached snapshots, storyboards and waveforms, one dir per video or job
+var previewDir = filepath.Join(outputDir, ".previews")
+
+// Snapshot is a single frame to grab
+type Snapshot struct {
+	At     float64 // seconds
+	Format ImageFormat
+	Width  int // 0 keeps the source width
+}
+
+// NewSnapshot validates a snapshot request, an empty format is jpg
+func NewSnapshot(at float64, format string, width int) (Snapshot, error) {
+	s := Snapshot{At: at, Width: width}
+	switch f := ImageFormat(strings.ToLower(strings.TrimSpace(format))); f {
+	case "", "jpeg", ImageJPEG:
+		s.Format = ImageJPEG
+	case ImagePNG:
+		s.Format = ImagePNG
+	default:
+		return Snapshot{}, fmt.Errorf("unknown image format %q, expected jpg or png", format)
+	}
+	if at < 0 {
+		return Snapshot{}, fmt.Errorf("timestamp cannot be negative")
+	}
+	if width != 0 && (width < MinSnapshotWidth || width > MaxSnapshotWidth) {
+		return Snapshot{}, fmt.Errorf("width must be between %d and %d pixels", MinSnapshotWidth, MaxSnapshotWidth)
+	}
+	return s, nil
+}
+
+// fileName is the cache name of the frame, keyed by its millisecond and size
+func (s Snapshot) fileName() string {
+	name := fmt.Sprintf("frame_%d", int64(s.At*1000+0.5))
+	if s.Width > 0 {
+		name += fmt.Sprintf("_w%d", s.Width)
+	}
+	return name + "." + string(s.Format)
+}
+
+// SourceSnapshot returns the path of a frame of the source video, grabbing it from the
+// stream unless it is cached already
+func SourceSnapshot(ctx context.Context, videoURL string, s Snapshot) (string, error) {
+	// A known ID finds cached frames without asking yt-dlp
+	if id := youtubeVideoID(videoURL); id != "" {
+		if path := filepath.Join(previewDir, id, s.fileName()); cached(path) {
+			return path, nil
+		}
+	}
+
+	stream, err := resolveStream(ctx, videoURL, videoStreamFormat(snapshotSourceHeight))
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(previewDir, cacheKey(stream.ID), s.fileName())
+	if cached(path) {
+		return path, nil
+	}
+	if stream.Duration > 0 && s.At >= stream.Duration {
+		return "", fmt.Errorf("%w: the video is %s seconds long", ErrPastEnd, formatSeconds(stream.Duration))
+	}
+	if err := grabFrame(ctx, stream.URL, s, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// FileSnapshot returns the path of a frame of a finished output, key names its cache dir
+func FileSnapshot(ctx context.Context, key, mediaPath string, s Snapshot) (string, error) {
+	path := filepath.Join(previewDir, "job-"+cacheKey(key), s.fileName())
+	if cached(path) {
+		return path, nil
+	}
+
+	info, err := probeMedia(ctx, mediaPath)
+	if err != nil {
+		return "", err
+	}
+	if info.VideoCodec == "" {
+		return "", ErrNoVideoTrack
+	}
+	if info.Duration > 0 && s.At >= info.Duration {
+		return "", fmt.Errorf("%w: the output is %s seconds long", ErrPastEnd, formatSeconds(info.Duration))
+	}
+	if err := grabFrame(ctx, mediaPath, s, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// grabFrame decodes the frame at s.At of input into path
+func grabFrame(ctx context.Context, input string, s Snapshot, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create preview dir: %w", err)
+	}
+	// Concurrent requests for the same frame each write their own temp file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".frame-*."+string(s.Format))
+	if err != nil {
+		return fmt.Errorf("failed to create frame file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := []string{"-ss", formatSeconds(s.At), "-i", input, "-map", "0:V:0", "-frames:v", "1", "-an", "-sn"}
+	if s.Width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", s.Width))
+	}
+	if s.Format == ImageJPEG {
+		args = append(args, "-q:v", "2")
+	}
+	if err := runFFmpeg(ctx, append(args, "-update", "1", tmp.Name())...); err != nil {
+		return err
+	}
+	// Seeking into the last partial second can leave ffmpeg with nothing to write
+	if !cached(tmp.Name()) {
+		return fmt.Errorf("%w: no frame at %s seconds", ErrPastEnd, formatSeconds(s.At))
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store frame: %w", err)
+	}
+	return nil
+}
+
+// sourceStream is a directly readable stream of a source video
+type sourceStream struct {
+	ID       string
+	URL      string
+	Duration float64 // 0 when unknown, e.g. live streams
+}
+
+// videoStreamFormat selects a single video stream of at most maxHeight
+func videoStreamFormat(maxHeight int) string {
+	return fmt.Sprintf("bv*[height<=%d]/b[height<=%d]/bv*/b", maxHeight, maxHeight)
+}
+
+// resolveStream asks yt-dlp for the URL of the single stream format selects, so ffmpeg can
+// read or seek in it without downloading the video
+func resolveStream(ctx context.Context, videoURL, format string) (sourceStream, error) {
+	cmd := exec.CommandContext(ctx, ytDlpPath, "--no-playlist", "-f", format,
+		"--print", "id", "--print", "duration", "--print", "urls", videoURL)
+	killProcessTree(cmd)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return sourceStream{}, context.Cause(ctx)
+		}
+		return sourceStream{}, fmt.Errorf("yt-dlp stream lookup failed: %w: %s", err, lastLines(stderrBuf.String(), 3))
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) < 3 || lines[0] == "" || lines[2] == "" {
+		return sourceStream{}, fmt.Errorf("yt-dlp printed no stream for %s", videoURL)
+	}
+	stream := sourceStream{ID: strings.TrimSpace(lines[0]), URL: strings.TrimSpace(lines[2])}
+	// "NA" for live streams
+	stream.Duration, _ = strconv.ParseFloat(strings.TrimSpace(lines[1]), 64)
+	return stream, nil
+}
+
+var youtubeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// youtubeVideoID reads the video ID out of a YouTube URL, empty for other URLs
+func youtubeVideoID(videoURL string) string {
+	parsed, err := url.Parse(videoURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	var id string
+	switch host {
+	case "youtu.be":
+		id = strings.Trim(parsed.Path, "/")
+	case "youtube.com", "m.youtube.com", "music.youtube.com":
+		if parsed.Path == "/watch" {
+			id = parsed.Query().Get("v")
+			break
+		}
+		for _, prefix := range []string{"/shorts/", "/embed/", "/live/"} {
+			if rest, ok := strings.CutPrefix(parsed.Path, prefix); ok {
+				id, _, _ = strings.Cut(rest, "/")
+			}
+		}
+	}
+	if !youtubeIDPattern.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+// cacheKey turns a video or job ID into a safe dir name, IDs of other sites may hold any character
+func cacheKey(id string) string {
+	return strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// cached reports whether path is a non-empty file
+func cached(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() > 0
+}
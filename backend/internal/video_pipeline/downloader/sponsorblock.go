@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// SponsorBlockMode controls what yt-dlp does with SponsorBlock segments
+type SponsorBlockMode string
+
+const (
+	SponsorBlockOff    SponsorBlockMode = "off"
+	SponsorBlockRemove SponsorBlockMode = "remove" // cut the segments out of the file
+	SponsorBlockMark   SponsorBlockMode = "mark"   // keep the media, add the segments as chapters
+)
+
+// sponsorCategories are the SponsorBlock categories a job may select
+var sponsorCategories = []string{"sponsor", "intro", "outro", "selfpromo", "interaction"}
+
+// SponsorBlock is the per-job SponsorBlock setting
+type SponsorBlock struct {
+	Mode       SponsorBlockMode
+	Categories []string
+}
+
+// SponsorSegment is a SponsorBlock segment that was removed or marked, in source video seconds
+type SponsorSegment struct {
+	Category string  `json:"category"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+}
+
+// NewSponsorBlock validates mode and categories, an empty category list selects all of them
+func NewSponsorBlock(mode string, categories []string) (SponsorBlock, error) {
+	m := SponsorBlockMode(strings.ToLower(strings.TrimSpace(mode)))
+	switch m {
+	case "", SponsorBlockOff:
+		if len(categories) > 0 {
+			return SponsorBlock{}, fmt.Errorf("sponsorblock categories require mode %q or %q", SponsorBlockRemove, SponsorBlockMark)
+		}
+		return SponsorBlock{Mode: SponsorBlockOff}, nil
+	case SponsorBlockRemove, SponsorBlockMark:
+	default:
+		return SponsorBlock{}, fmt.Errorf("unknown sponsorblock mode %q", mode)
+	}
+
+	if len(categories) == 0 {
+		return SponsorBlock{Mode: m, Categories: append([]string(nil), sponsorCategories...)}, nil
+	}
+
+	seen := make(map[string]bool, len(categories))
+	selected := make([]string, 0, len(categories))
+	for _, category := range categories {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if !validSponsorCategory(category) {
+			return SponsorBlock{}, fmt.Errorf("unknown sponsorblock category %q, supported: %s", category, strings.Join(sponsorCategories, ", "))
+		}
+		if !seen[category] {
+			seen[category] = true
+			selected = append(selected, category)
+		}
+	}
+	return SponsorBlock{Mode: m, Categories: selected}, nil
+}
+
+// Enabled reports whether the job asked for SponsorBlock processing
+func (s SponsorBlock) Enabled() bool {
+	return s.Mode == SponsorBlockRemove || s.Mode == SponsorBlockMark
+}
+
+// args returns the yt-dlp flags for the setting, the segments SponsorBlock returned are printed into files
+func (s SponsorBlock) args(files *printFiles) []string {
+	if !s.Enabled() {
+		return nil
+	}
+	args := []string{"--sponsorblock-" + string(s.Mode), strings.Join(s.Categories, ",")}
+	return append(args, files.arg("sponsorblock", "after_move:%(sponsorblock_chapters)j")...)
+}
+
+// readSponsorSegments parses the sponsorblock_chapters yt-dlp printed after the move stage
+func readSponsorSegments(files *printFiles) ([]SponsorSegment, error) {
+	data, err := files.read("sponsorblock")
+	if err != nil {
+		return nil, err
+	}
+
+	// yt-dlp prints "NA" when the video has no segments in the selected categories
+	if data == "" || data == "NA" || data == "null" {
+		return nil, nil
+	}
+
+	var chapters []struct {
+		Category  string  `json:"category"`
+		StartTime float64 `json:"start_time"`
+		EndTime   float64 `json:"end_time"`
+	}
+	if err := json.Unmarshal([]byte(data), &chapters); err != nil {
+		return nil, fmt.Errorf("failed to parse sponsorblock segments: %w", err)
+	}
+
+	segments := make([]SponsorSegment, 0, len(chapters))
+	for _, ch := range chapters {
+		segments = append(segments, SponsorSegment{Category: ch.Category, Start: ch.StartTime, End: ch.EndTime})
+	}
+	return segments, nil
+}
+
+// clipSponsorSegments keeps the parts of segments that fall inside [begin, end]
+// and returns them with the total seconds they cover inside the window
+func clipSponsorSegments(segments []SponsorSegment, begin, end float64) ([]SponsorSegment, float64) {
+	sorted := append([]SponsorSegment(nil), segments...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var clipped []SponsorSegment
+	var covered float64
+	lastEnd := begin
+	for _, seg := range sorted {
+		start, stop := math.Max(seg.Start, begin), math.Min(seg.End, end)
+		if stop <= start {
+			continue
+		}
+		clipped = append(clipped, SponsorSegment{Category: seg.Category, Start: start, End: stop})
+
+		// Segments from different categories can overlap, only count each second once
+		if start < lastEnd {
+			start = lastEnd
+		}
+		if stop > start {
+			covered += stop - start
+			lastEnd = stop
+		}
+	}
+	return clipped, covered
+}
+
+func validSponsorCategory(category string) bool {
+	for _, c := range sponsorCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Storyboard defaults and bounds
+const (
+	DefaultStoryboardInterval = 10.0 // seconds between frames
+	DefaultStoryboardWidth    = 160
+	DefaultStoryboardColumns  = 10
+	MinStoryboardInterval     = 1.0
+	MaxStoryboardInterval     = 600.0
+	MinStoryboardWidth        = 64
+	MaxStoryboardWidth        = 480
+	MaxStoryboardColumns      = 20
+	MaxStoryboardFrames       = 2000
+	// storyboardRows caps the rows of one sprite sheet, longer videos get more sheets
+	storyboardRows = 10
+	// Frames are small, a low resolution stream decodes much faster
+	storyboardSourceHeight = 480
+)
+
+// StoryboardVTTName is the WebVTT track of a storyboard, its cues point into the sprite sheets
+const StoryboardVTTName = "storyboard.vtt"
+
+// StoryboardOptions is the frame interval and layout of a storyboard
+type StoryboardOptions struct {
+	Interval float64 // seconds
+	Width    int     // of one frame
+	Columns  int     // frames per sprite sheet row
+}
+
+// Storyboard is a finished storyboard: sprite sheets and the track indexing them, all in Dir
+type Storyboard struct {
+	VideoID    string
+	Dir        string
+	Sprites    []string // file names, in order
+	Frames     int
+	TileWidth  int
+	TileHeight int
+	Cached     bool // found on disk, nothing was generated
+}
+
+// NewStoryboardOptions validates storyboard options, zero values take the defaults
+func NewStoryboardOptions(interval float64, width, columns int) (StoryboardOptions, error) {
+	if interval == 0 {
+		interval = DefaultStoryboardInterval
+	}
+	if width == 0 {
+		width = DefaultStoryboardWidth
+	}
+	if columns == 0 {
+		columns = DefaultStoryboardColumns
+	}
+	if interval < MinStoryboardInterval || interval > MaxStoryboardInterval {
+		return StoryboardOptions{}, fmt.Errorf("interval must be between %g and %g seconds", MinStoryboardInterval, MaxStoryboardInterval)
+	}
+	if width < MinStoryboardWidth || width > MaxStoryboardWidth {
+		return StoryboardOptions{}, fmt.Errorf("width must be between %d and %d pixels", MinStoryboardWidth, MaxStoryboardWidth)
+	}
+	if columns < 1 || columns > MaxStoryboardColumns {
+		return StoryboardOptions{}, fmt.Errorf("columns must be between 1 and %d", MaxStoryboardColumns)
+	}
+	return StoryboardOptions{Interval: interval, Width: width, Columns: columns}, nil
+}
+
+// dirName is the cache dir of a storyboard with these options, next to the video's snapshots
+func (o StoryboardOptions) dirName() string {
+	return fmt.Sprintf("storyboard_%d_w%d_c%d", int64(o.Interval*1000+0.5), o.Width, o.Columns)
+}
+
+// GenerateStoryboard builds sprite sheets of frames every o.Interval seconds of the source and a
+// WebVTT thumbnail track over them, or returns the cached storyboard of the same video and options
+func GenerateStoryboard(ctx context.Context, videoURL string, o StoryboardOptions, timeouts Timeouts) (*Storyboard, error) {
+	ctx, cancel := timeouts.withTotal(ctx)
+	defer cancel()
+
+	if id := youtubeVideoID(videoURL); id != "" {
+		if sb, err := readStoryboard(id, o); err == nil {
+			return sb, nil
+		}
+	}
+
+	stream, err := resolveStream(ctx, videoURL, videoStreamFormat(storyboardSourceHeight))
+	if err != nil {
+		return nil, err
+	}
+	key := cacheKey(stream.ID)
+	if sb, err := readStoryboard(key, o); err == nil {
+		return sb, nil
+	}
+	if stream.Duration <= 0 {
+		return nil, fmt.Errorf("storyboards need a video of known length, live streams have none")
+	}
+	frames := int(math.Ceil(stream.Duration / o.Interval))
+	if frames > MaxStoryboardFrames {
+		return nil, fmt.Errorf("%d frames is more than the %d a storyboard may hold, use a longer interval", frames, MaxStoryboardFrames)
+	}
+	rows := min(storyboardRows, (frames+o.Columns-1)/o.Columns)
+
+	videoDir := filepath.Join(previewDir, key)
+	if err := os.MkdirAll(videoDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create preview dir: %w", err)
+	}
+	// Built aside and renamed into place, so a cut short run never looks cached
+	buildDir, err := os.MkdirTemp(videoDir, ".storyboard-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storyboard dir: %w", err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	// The fps filter keeps one frame per interval, tile packs columns x rows of them per sheet
+	filter := fmt.Sprintf("fps=1000/%d,scale=%d:-2,tile=%dx%d",
+		int64(o.Interval*1000+0.5), o.Width, o.Columns, rows)
+	if err := runFFmpeg(ctx,
+		"-i", stream.URL, "-map", "0:V:0", "-an", "-sn",
+		"-vf", filter, "-q:v", "4",
+		filepath.Join(buildDir, "sprite_%03d.jpg"),
+	); err != nil {
+		return nil, err
+	}
+
+	sprites, err := spriteNames(buildDir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := probeMedia(ctx, filepath.Join(buildDir, sprites[0]))
+	if err != nil {
+		return nil, err
+	}
+	tileWidth, tileHeight := info.Width/o.Columns, info.Height/rows
+	perSheet := o.Columns * rows
+	// Stream durations are rounded, never point cues past the sheets ffmpeg really wrote
+	frames = min(frames, len(sprites)*perSheet)
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i := 0; i < frames; i++ {
+		start := float64(i) * o.Interval
+		// The last cue ends with the video, not a whole interval later
+		end := math.Min(start+o.Interval, stream.Duration)
+		tile := i % perSheet
+		fmt.Fprintf(&b, "%s --> %s\n%s#xywh=%d,%d,%d,%d\n\n",
+			formatCueTime(start, true), formatCueTime(end, true), sprites[i/perSheet],
+			tile%o.Columns*tileWidth, tile/o.Columns*tileHeight, tileWidth, tileHeight)
+	}
+	if err := os.WriteFile(filepath.Join(buildDir, StoryboardVTTName), []byte(b.String()), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write storyboard track: %w", err)
+	}
+
+	dir := filepath.Join(videoDir, o.dirName())
+	if err := os.Rename(buildDir, dir); err != nil {
+		// Another job finished the same storyboard first
+		if sb, readErr := readStoryboard(key, o); readErr == nil {
+			return sb, nil
+		}
+		return nil, fmt.Errorf("failed to store storyboard: %w", err)
+	}
+	return &Storyboard{
+		VideoID:    stream.ID,
+		Dir:        dir,
+		Sprites:    sprites,
+		Frames:     frames,
+		TileWidth:  tileWidth,
+		TileHeight: tileHeight,
+	}, nil
+}
+
+// readStoryboard loads a cached storyboard of the video stored under key
+func readStoryboard(key string, o StoryboardOptions) (*Storyboard, error) {
+	dir := filepath.Join(previewDir, key, o.dirName())
+	track, err := os.ReadFile(filepath.Join(dir, StoryboardVTTName))
+	if err != nil {
+		return nil, err
+	}
+	sprites, err := spriteNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := &Storyboard{VideoID: key, Dir: dir, Sprites: sprites, Cached: true}
+	for _, line := range strings.Split(string(track), "\n") {
+		_, xywh, ok := strings.Cut(line, "#xywh=")
+		if !ok {
+			continue
+		}
+		sb.Frames++
+		if sb.TileWidth == 0 {
+			var x, y int
+			fmt.Sscanf(xywh, "%d,%d,%d,%d", &x, &y, &sb.TileWidth, &sb.TileHeight)
+		}
+	}
+	return sb, nil
+}
+
+// spriteNames lists the sprite sheets in dir, in order
+func spriteNames(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "sprite_*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("ffmpeg wrote no sprite sheets")
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = filepath.Base(m)
+	}
+	sort.Strings(names)
+	return names, nil
+}
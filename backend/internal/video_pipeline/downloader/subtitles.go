@@ -0,0 +1,352 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SubtitleMode controls how downloaded subtitles end up in the output
+type SubtitleMode string
+
+const (
+	SubtitlesOff     SubtitleMode = "off"
+	SubtitlesSidecar SubtitleMode = "sidecar" // separate .srt/.vtt files next to the media
+	SubtitlesEmbed   SubtitleMode = "embed"   // soft subtitle tracks inside the container
+	SubtitlesBurn    SubtitleMode = "burn"    // rendered into the video frames by ffmpeg
+)
+
+const (
+	DefaultSubtitleLanguage = "en"
+	DefaultSubtitleFormat   = "srt"
+	maxSubtitleLanguages    = 5
+)
+
+// ErrNoSubtitles is returned when the video has no subtitles in any of the requested languages
+var ErrNoSubtitles = errors.New("no subtitles found")
+
+var subtitleLanguagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// Subtitles is the per-job subtitle setting
+type Subtitles struct {
+	Mode      SubtitleMode
+	Languages []string
+	Auto      bool   // also accept auto-generated subtitles when no manual track exists
+	Format    string // srt or vtt, only sidecar files keep vtt
+}
+
+// NewSubtitles validates a subtitle request, defaulting to English SRT
+func NewSubtitles(mode string, languages []string, auto bool, format string) (Subtitles, error) {
+	m := SubtitleMode(strings.ToLower(strings.TrimSpace(mode)))
+	switch m {
+	case "", SubtitlesOff:
+		if len(languages) > 0 || auto || format != "" {
+			return Subtitles{}, fmt.Errorf("subtitle options require mode %q, %q or %q", SubtitlesSidecar, SubtitlesEmbed, SubtitlesBurn)
+		}
+		return Subtitles{Mode: SubtitlesOff}, nil
+	case SubtitlesSidecar, SubtitlesEmbed, SubtitlesBurn:
+	default:
+		return Subtitles{}, fmt.Errorf("unknown subtitle mode %q", mode)
+	}
+
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		format = DefaultSubtitleFormat
+	}
+	if format != "srt" && format != "vtt" {
+		return Subtitles{}, fmt.Errorf("unsupported subtitle format %q, supported: srt, vtt", format)
+	}
+	if format == "vtt" && m != SubtitlesSidecar {
+		return Subtitles{}, fmt.Errorf("subtitle format vtt is only available for sidecar files")
+	}
+
+	if len(languages) == 0 {
+		languages = []string{DefaultSubtitleLanguage}
+	}
+	if len(languages) > maxSubtitleLanguages {
+		return Subtitles{}, fmt.Errorf("at most %d subtitle languages can be requested", maxSubtitleLanguages)
+	}
+	if m == SubtitlesBurn && len(languages) > 1 {
+		return Subtitles{}, fmt.Errorf("only one subtitle language can be burned in")
+	}
+
+	langs := make([]string, 0, len(languages))
+	for _, lang := range languages {
+		lang = strings.TrimSpace(lang)
+		if !subtitleLanguagePattern.MatchString(lang) {
+			return Subtitles{}, fmt.Errorf("invalid subtitle language %q", lang)
+		}
+		langs = append(langs, lang)
+	}
+
+	return Subtitles{Mode: m, Languages: langs, Auto: auto, Format: format}, nil
+}
+
+// Enabled reports whether the job asked for subtitles
+func (s Subtitles) Enabled() bool {
+	return s.Mode == SubtitlesSidecar || s.Mode == SubtitlesEmbed || s.Mode == SubtitlesBurn
+}
+
+// args returns the yt-dlp flags for the setting. Clips embed their subtitles
+// after trimming, so yt-dlp only embeds for full videos.
+func (s Subtitles) args(clip bool) []string {
+	if !s.Enabled() {
+		return nil
+	}
+	args := []string{"--write-subs", "--sub-langs", strings.Join(s.Languages, ","), "--convert-subs", s.Format}
+	if s.Auto {
+		args = append(args, "--write-auto-subs")
+	}
+	if s.Mode == SubtitlesEmbed && !clip {
+		args = append(args, "--embed-subs")
+	}
+	return args
+}
+
+// missingSidecar reports whether err only means that a sidecar job has no subtitles to write.
+// The media is fine without them, so those jobs finish with a notice instead of failing.
+func (s Subtitles) missingSidecar(err error) bool {
+	return s.Mode == SubtitlesSidecar && errors.Is(err, ErrNoSubtitles)
+}
+
+// noSubtitlesNotice is the notice of a sidecar job finished without subtitles
+func (s Subtitles) noSubtitlesNotice() string {
+	return fmt.Sprintf("Finished without subtitles, none found for languages %s", strings.Join(s.Languages, ", "))
+}
+
+// window is a clip range in source seconds
+type window struct {
+	begin, end float64
+}
+
+// processSubtitles applies the subtitle mode to the files yt-dlp wrote next to mediaPath.
+// For clips (clip != nil) the cues are first shifted and trimmed to start at time zero.
+// Burned-in subtitles are encoded with e's codec when the job asked for one.
+func processSubtitles(ctx context.Context, mediaPath string, s Subtitles, clip *window, e Encoding) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	tracks, err := findSubtitleFiles(mediaPath, s)
+	if err != nil {
+		return err
+	}
+	if len(tracks) == 0 {
+		// Full video embeds are done by yt-dlp, which removes the sidecar files
+		if s.Mode == SubtitlesEmbed && clip == nil {
+			return nil
+		}
+		return fmt.Errorf("%w for languages %s", ErrNoSubtitles, strings.Join(s.Languages, ", "))
+	}
+
+	if clip != nil {
+		for _, track := range tracks {
+			if err := trimSubtitleFile(track.path, *clip); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch s.Mode {
+	case SubtitlesEmbed:
+		if clip == nil {
+			return nil
+		}
+		err = embedSubtitles(ctx, mediaPath, tracks)
+	case SubtitlesBurn:
+		err = burnSubtitles(ctx, mediaPath, tracks[0].path, e)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The subtitles now live inside the media file
+	for _, track := range tracks {
+		os.Remove(track.path)
+	}
+	return nil
+}
+
+type subtitleTrack struct {
+	lang string
+	path string
+}
+
+// findSubtitleFiles locates "<media base>.<lang>.<format>" files in requested language order
+func findSubtitleFiles(mediaPath string, s Subtitles) ([]subtitleTrack, error) {
+	base := strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath))
+	var tracks []subtitleTrack
+	for _, lang := range s.Languages {
+		path := base + "." + lang + "." + s.Format
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		tracks = append(tracks, subtitleTrack{lang: lang, path: path})
+	}
+	return tracks, nil
+}
+
+type subtitleCue struct {
+	start, end float64
+	text       []string
+}
+
+var cueTimingPattern = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{3})`)
+
+// trimSubtitleFile rewrites an SRT/VTT file keeping only the cues inside the clip, shifted to time zero
+func trimSubtitleFile(path string, clip window) error {
+	vtt := strings.EqualFold(filepath.Ext(path), ".vtt")
+
+	cues, err := readSubtitleCues(path)
+	if err != nil {
+		return fmt.Errorf("failed to read subtitles %s: %w", filepath.Base(path), err)
+	}
+
+	var kept []subtitleCue
+	for _, cue := range cues {
+		if cue.end <= clip.begin || cue.start >= clip.end {
+			continue
+		}
+		if cue.start < clip.begin {
+			cue.start = clip.begin
+		}
+		if cue.end > clip.end {
+			cue.end = clip.end
+		}
+		cue.start -= clip.begin
+		cue.end -= clip.begin
+		kept = append(kept, cue)
+	}
+
+	var b strings.Builder
+	if vtt {
+		b.WriteString("WEBVTT\n\n")
+	}
+	for i, cue := range kept {
+		if !vtt {
+			fmt.Fprintf(&b, "%d\n", i+1)
+		}
+		fmt.Fprintf(&b, "%s --> %s\n", formatCueTime(cue.start, vtt), formatCueTime(cue.end, vtt))
+		for _, line := range cue.text {
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+func readSubtitleCues(path string) ([]subtitleCue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cues []subtitleCue
+	var current *subtitleCue
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := cueTimingPattern.FindStringSubmatch(line); m != nil {
+			start, err := parseCueTime(m[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseCueTime(m[2])
+			if err != nil {
+				return nil, err
+			}
+			cues = append(cues, subtitleCue{start: start, end: end})
+			current = &cues[len(cues)-1]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			current = nil
+			continue
+		}
+		if current != nil {
+			current.text = append(current.text, line)
+		}
+	}
+	return cues, scanner.Err()
+}
+
+// parseCueTime parses "HH:MM:SS,mmm", "HH:MM:SS.mmm" or "MM:SS.mmm" into seconds
+func parseCueTime(value string) (float64, error) {
+	parts := strings.Split(strings.Replace(value, ",", ".", 1), ":")
+	var seconds float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cue time %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+func formatCueTime(seconds float64, vtt bool) string {
+	ms := int64(seconds*1000 + 0.5)
+	sep := ","
+	if vtt {
+		sep = "."
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// embedSubtitles muxes the tracks into mediaPath as soft subtitles, copying the other streams
+func embedSubtitles(ctx context.Context, mediaPath string, tracks []subtitleTrack) error {
+	codec := "mov_text"
+	switch strings.ToLower(filepath.Ext(mediaPath)) {
+	case ".mkv":
+		codec = "srt"
+	case ".webm":
+		codec = "webvtt"
+	}
+
+	args := []string{"-i", mediaPath}
+	for _, track := range tracks {
+		args = append(args, "-i", track.path)
+	}
+	args = append(args, "-map", "0")
+	for i := range tracks {
+		args = append(args, "-map", strconv.Itoa(i+1))
+	}
+	args = append(args, "-c", "copy", "-c:s", codec)
+	for i, track := range tracks {
+		args = append(args, fmt.Sprintf("-metadata:s:s:%d", i), "language="+track.lang)
+	}
+	return ffmpegReplace(ctx, mediaPath, args)
+}
+
+// burnSubtitles renders a subtitle file into the video frames
+func burnSubtitles(ctx context.Context, mediaPath, subtitlePath string, e Encoding) error {
+	// Map the main streams explicitly so an embedded cover image is not picked as the video input
+	args := []string{
+		"-i", mediaPath,
+		"-map", "0:v:0", "-map", "0:a?", "-map_metadata", "0",
+		"-vf", "subtitles=" + escapeFilterValue(subtitlePath),
+	}
+	args = append(args, e.filterVideoArgs()...)
+	args = append(args, "-c:a", "copy")
+	return ffmpegReplace(ctx, mediaPath, args)
+}
+
+// escapeFilterValue escapes a path for use as a filter option inside an ffmpeg filter graph:
+// once for the option value and once more for the graph itself
+func escapeFilterValue(value string) string {
+	value = filepath.ToSlash(value)
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(value)
+}
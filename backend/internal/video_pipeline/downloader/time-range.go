@@ -1,77 +1,192 @@
 package downloader
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
-	"os/exec"
-	"path/filepath"
-	"regexp"
-	"time"
+	"log"
+	"math"
+	"os"
 )
 
-
-func TimeRangeFHD(videoURL string, begin, end int, downloadID string) error {
-	start := time.Now()
-    secondsToHHMMSS := func(sec int) string {
-        h := sec / 3600
-        m := (sec % 3600) / 60
-        s := sec % 60
-        return fmt.Sprintf("%02dh%02dm%02ds", h, m, s)
-    }
-    beginInt := secondsToHHMMSS(begin)
-    endInt := secondsToHHMMSS(end)
+func TimeRangeFHD(ctx context.Context, videoURL string, begin, end float64, downloadID string, opts Options, onProgress ProgressFunc) (*Result, error) {
+	ctx, cancel := opts.Timeouts.withTotal(ctx)
+	defer cancel()
+	secondsToHHMMSS := func(sec float64) string {
+		ms := int(math.Round(sec * 1000))
+		h := ms / 3600000
+		m := (ms % 3600000) / 60000
+		s := (ms % 60000) / 1000
+		if ms%1000 != 0 {
+			return fmt.Sprintf("%02dh%02dm%02d.%03ds", h, m, s, ms%1000)
+		}
+		return fmt.Sprintf("%02dh%02dm%02ds", h, m, s)
+	}
+	beginInt := secondsToHHMMSS(begin)
+	endInt := secondsToHHMMSS(end)
 	// fmt.Println("Begin, end:", begin, end)
 
-	// ../bin/yt-dlp.exe --no-playlist -f 'bv*[height<=1080][vcodec~=avc1]+ba*[ext=m4a]/bv*[height<=1080]+ba*[ext=m4a]/bv*+ba*/best[height<=1080]/best'  -S 'res:1080,+codec:avc1,+br' --download-sections '*30-90' -o 'outputDir/%(title)s (%(height)sp, h264).%(ext)s' 'https://www.youtube.com/watch?v=dQw4w9WgXcQ'
-	cmd_1080p := exec.Command(
-		ytDlpPath,
-		"--no-playlist",
-		"-f", `bv*[height<=1080][vcodec~=avc1]+ba*[ext=m4a]/bv*[height<=1080]+ba*[ext=m4a]/bv*+ba*/best[height<=1080]/best`,
-		"-S", "res:1080,+codec:avc1,+br",
-		"--download-section", fmt.Sprintf("*%d-%d", begin, end),
-		"-o", filepath.Join(outputDir, fmt.Sprintf("%%(title)s (%s-%s,%%(height)sp, h264).%%(ext)s", beginInt, endInt)),
+	// ../bin/yt-dlp.exe --no-playlist -f 'bv*[height<=1080][vcodec~=avc1]+ba*[ext=m4a]/bv*[height<=1080]+ba*[ext=m4a]/bv*+ba*/best[height<=1080]/best'  -S 'res:1080,+codec:avc1,+br' --download-sections '*30-90' -o 'outputDir/%(title)s (%(height)sp, %(vcodec.:4)s).%(ext)s' 'https://www.youtube.com/watch?v=dQw4w9WgXcQ'
+	files, err := newPrintFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer files.cleanup()
+
+	// Whatever is left in the parts dir is partial, drop it however the job ends
+	partsDir, err := newPartsDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(partsDir)
+
+	args := append([]string{"--no-playlist"}, opts.formatArgs()...)
+	args = append(args, opts.SponsorBlock.args(files)...)
+	args = append(args, opts.Subtitles.args(true)...)
+	args = append(args, metadataArgs(opts.Thumbnail, beginInt+"-"+endInt)...)
+	args = append(args, files.finalPathArg()...)
+	args = append(args, files.sourceFormatArg()...)
+	args = append(args, files.nameArg(fmt.Sprintf("%s-%s,%s", beginInt, endInt, opts.fileLabel()))...)
+	args = append(args, pathArgs(partsDir)...)
+	args = append(args, opts.Cut.sectionArgs(begin, end)...)
+	args = append(args,
+		// Named after the job so the file can be found again without guessing from titles
+		"-o", downloadID+".%(ext)s",
 		videoURL,
 	)
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
-	cmd_1080p.Stdout = &stdoutBuf
-	cmd_1080p.Stderr = &stderrBuf
-
-	if err := cmd_1080p.Run(); err != nil {
-		fmt.Println("Fail:", err)
-		return err
+	tracker := newProgressTracker(onProgress)
+	defer tracker.wait()
+	if _, err := runYtDlp(ctx, args, tracker, opts.Timeouts.Stall); err != nil {
+		return nil, err
+	}
+
+	finalPath, err := files.finalPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate downloaded clip: %w", err)
 	}
 
-	// Combine both stdout and stderr for scanning, since yt-dlp may print to either
-	combined := append(stdoutBuf.Bytes(), stderrBuf.Bytes()...)
-	scanner := bufio.NewScanner(bytes.NewReader(combined))
-	re := regexp.MustCompile(`\[download\] Destination: (.+)`)
-	var downloaded string
-	for scanner.Scan() {
-		line := scanner.Text()
-		if matches := re.FindStringSubmatch(line); matches != nil {
-			downloaded = matches[1]
-			break
+	if opts.Cut == CutAccurate {
+		// The download is padded to reach keyframes, cut it down to the exact range
+		from, _ := opts.Cut.fetchWindow(begin, end)
+		tracker.enter(PhasePostProcessing)
+		if err := accurateCut(ctx, finalPath, begin-from, end-from); err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("accurate cut failed: %w", err)
 		}
 	}
 
-	if downloaded == "" {
-		fmt.Println("Video is already downloaded.")
+	if opts.Reframe.Enabled() {
+		// Before subtitles, so burned-in lines are laid out on the vertical frame
+		tracker.enter(PhasePostProcessing)
+		if err := reframeVideo(ctx, finalPath, opts.Reframe, opts.Encoding); err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("reframe failed: %w", err)
+		}
+	}
+
+	// Estimated length, replaced by the probed one below when ffprobe succeeds
+	result := &Result{Duration: end - begin}
+	if opts.SponsorBlock.Enabled() {
+		segments, err := readSponsorSegments(files)
+		if err != nil {
+			os.Remove(finalPath)
+			return nil, fmt.Errorf("reading sponsor segments failed: %w", err)
+		}
+		// SponsorBlock reports segments for the whole video, keep the ones inside the clip
+		clipped, covered := clipSponsorSegments(segments, begin, end)
+		result.SponsorSegments = clipped
+		if opts.SponsorBlock.Mode == SponsorBlockRemove {
+			result.Duration -= covered
+		}
+	}
 
+	if opts.Subtitles.Enabled() {
+		// Subtitles come for the whole video, line them up with the clip
+		clip := &window{begin: begin, end: end}
+		tracker.enter(PhasePostProcessing)
+		if err := processSubtitles(ctx, finalPath, opts.Subtitles, clip, opts.Encoding); opts.Subtitles.missingSidecar(err) {
+			result.Notice = opts.Subtitles.noSubtitlesNotice()
+		} else if err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("subtitles failed: %w", err)
+		}
+	}
+
+	if opts.Watermark.Enabled() {
+		tracker.enter(PhasePostProcessing)
+		if err := watermarkVideo(ctx, finalPath, opts.Watermark, opts.Encoding); err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("watermark failed: %w", err)
+		}
+	}
+
+	if opts.Encoding.Enabled() {
+		tracker.enter(PhasePostProcessing)
+		converted, err := convertOutput(ctx, finalPath, opts.Encoding)
+		if err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("conversion failed: %w", err)
+		}
+		finalPath = converted
+	}
+
+	if opts.AudioProcessing.Enabled() {
+		tracker.enter(PhasePostProcessing)
+		loudness, err := processAudio(ctx, finalPath, opts.AudioProcessing, opts.Audio.Bitrate)
+		if err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("audio processing failed: %w", err)
+		}
+		result.Loudness = loudness
+	}
+
+	if opts.Animation.Enabled() {
+		tracker.enter(PhasePostProcessing)
+		animPath, fps, err := exportAnimation(ctx, finalPath, opts.Animation)
+		if err != nil {
+			os.Remove(finalPath)
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, fmt.Errorf("animation export failed: %w", err)
+		}
+		finalPath = animPath
+		result.FPS = fps
+	}
+
+	if err := describeOutput(ctx, result, finalPath); err != nil {
+		if ctx.Err() != nil {
+			os.Remove(finalPath)
+			return nil, context.Cause(ctx)
+		}
+		// The file is fine, only its details are missing
+		log.Printf("TimeRangeFHD - describeOutput error: %v", err)
+	}
+	if cutStart, cutEnd, err := cutBounds(ctx, finalPath, begin); err == nil {
+		result.CutStart, result.CutEnd = cutStart, cutEnd
 	} else {
-		base := filepath.Base(downloaded) // // Example: "Rick Astley - Never Gonna Give You Up (Official Video) (4K Remaster) (1080p, h264).mp4"
-		// // Cut .mp4
-		// ext := filepath.Ext(base)
-		// title := base[:len(base)-len(ext)]
-		// // Remove " (1080p, h264)"
-		// reTitle := regexp.MustCompile(`^(.*) \(1080p, h264\)$`)
-		// if m := reTitle.FindStringSubmatch(title); m != nil {
-		//     title = m[1]
-		// }
-		fmt.Println("✅ Download sucessfully:", base)
-		// fmt.Println("🎵 Video title:", title)
+		log.Printf("TimeRangeFHD - cutBounds error: %v", err)
 	}
-	fmt.Println("Took:", time.Since(start))
-    return nil
+	name, _ := files.read("name")
+	result.FileName = displayName(name, finalPath)
+	files.sourceFormat(result)
+
+	return result, nil
 }
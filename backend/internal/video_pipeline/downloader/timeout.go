@@ -0,0 +1,46 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned when a job runs longer than its overall limit
+	ErrTimeout = errors.New("job exceeded its time limit")
+	// ErrStalled is returned when yt-dlp prints nothing for longer than the stall limit
+	ErrStalled = errors.New("job made no progress within the stall limit")
+)
+
+// Timeouts bounds how long a job may run. They are server settings, not chosen by clients.
+// A zero value disables that limit.
+type Timeouts struct {
+	Total time.Duration // whole job including our own ffmpeg passes
+	Stall time.Duration // longest yt-dlp may go without printing a line
+}
+
+// withTotal applies the overall limit to ctx, context.Cause reports ErrTimeout once it is hit
+func (t Timeouts) withTotal(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.Total <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, t.Total, ErrTimeout)
+}
+
+// watchStall cancels the run with ErrStalled when no activity arrives within limit
+func watchStall(ctx context.Context, cancel context.CancelCauseFunc, activity <-chan struct{}, limit time.Duration) {
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-activity:
+			timer.Reset(limit)
+		case <-timer.C:
+			cancel(ErrStalled)
+			return
+		}
+	}
+}
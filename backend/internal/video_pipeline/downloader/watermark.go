@@ -0,0 +1,212 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WatermarkPosition is the corner, or the center, a watermark is placed in
+type WatermarkPosition string
+
+const (
+	WatermarkTopLeft     WatermarkPosition = "top-left"
+	WatermarkTopRight    WatermarkPosition = "top-right"
+	WatermarkBottomLeft  WatermarkPosition = "bottom-left"
+	WatermarkBottomRight WatermarkPosition = "bottom-right"
+	WatermarkCenter      WatermarkPosition = "center"
+)
+
+// Defaults and bounds for watermarks
+const (
+	DefaultWatermarkPosition = WatermarkBottomRight
+	DefaultWatermarkMargin   = 24
+	DefaultWatermarkOpacity  = 0.8
+	// Logos default to a fraction of the video width, text to a fraction of its height
+	DefaultWatermarkImageScale = 0.15
+	DefaultWatermarkTextScale  = 0.05
+	MaxWatermarkMargin         = 500
+	MaxWatermarkTextLength     = 200
+	MaxWatermarkImageBytes     = 2 << 20
+	MaxWatermarkImageSide      = 2048
+)
+
+// watermarkFont is the font file text watermarks are drawn with, fontconfig picks one when empty.
+// Read per job, package init runs before main loads the .env file.
+func watermarkFont() string {
+	return getConfigValue("WATERMARK_FONT", "")
+}
+
+// Watermark is a logo or a line of text drawn over the video
+type Watermark struct {
+	ImagePath string // PNG logo, empty for text watermarks
+	Text      string
+	Position  WatermarkPosition
+	Margin    int     // pixels between the watermark and the frame edges
+	Opacity   float64 // 0 to 1
+	Scale     float64 // logo width as a fraction of the video width, text height as one of the video height
+}
+
+// WatermarkPositionNames lists the valid positions
+func WatermarkPositionNames() []string {
+	return []string{
+		string(WatermarkTopLeft), string(WatermarkTopRight),
+		string(WatermarkBottomLeft), string(WatermarkBottomRight),
+		string(WatermarkCenter),
+	}
+}
+
+// NewWatermark validates a watermark, exactly one of imagePath and text must be set.
+// An empty position and zero opacity or scale take the defaults.
+func NewWatermark(imagePath, text, position string, margin int, opacity, scale float64) (Watermark, error) {
+	text = strings.TrimSpace(text)
+	if (imagePath == "") == (text == "") {
+		return Watermark{}, fmt.Errorf("a watermark needs either an image or a text")
+	}
+	if len(text) > MaxWatermarkTextLength || strings.ContainsAny(text, "\r\n") {
+		return Watermark{}, fmt.Errorf("watermark text must be a single line of at most %d characters", MaxWatermarkTextLength)
+	}
+
+	wm := Watermark{ImagePath: imagePath, Text: text}
+	switch p := WatermarkPosition(strings.ToLower(strings.TrimSpace(position))); p {
+	case "":
+		wm.Position = DefaultWatermarkPosition
+	case WatermarkTopLeft, WatermarkTopRight, WatermarkBottomLeft, WatermarkBottomRight, WatermarkCenter:
+		wm.Position = p
+	default:
+		return Watermark{}, fmt.Errorf("unknown watermark position %q, expected one of: %s", position, strings.Join(WatermarkPositionNames(), ", "))
+	}
+
+	if margin < 0 || margin > MaxWatermarkMargin {
+		return Watermark{}, fmt.Errorf("watermark margin must be between 0 and %d pixels", MaxWatermarkMargin)
+	}
+	wm.Margin = margin
+
+	if opacity == 0 {
+		opacity = DefaultWatermarkOpacity
+	}
+	if opacity < 0 || opacity > 1 {
+		return Watermark{}, fmt.Errorf("watermark opacity must be between 0 and 1")
+	}
+	wm.Opacity = opacity
+
+	if scale == 0 {
+		scale = DefaultWatermarkImageScale
+		if text != "" {
+			scale = DefaultWatermarkTextScale
+		}
+	}
+	if scale < 0.01 || scale > 1 {
+		return Watermark{}, fmt.Errorf("watermark scale must be between 0.01 and 1")
+	}
+	wm.Scale = scale
+	return wm, nil
+}
+
+// Enabled reports whether the job is watermarked
+func (w Watermark) Enabled() bool {
+	return w.ImagePath != "" || w.Text != ""
+}
+
+// SaveWatermarkImage checks data is a PNG of sensible size and stores it as the logo of watermark id
+func SaveWatermarkImage(id string, data []byte) (string, error) {
+	if len(data) > MaxWatermarkImageBytes {
+		return "", fmt.Errorf("watermark image cannot exceed %d MB", MaxWatermarkImageBytes>>20)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("watermark image must be a PNG: %w", err)
+	}
+	if config.Width > MaxWatermarkImageSide || config.Height > MaxWatermarkImageSide {
+		return "", fmt.Errorf("watermark image cannot be larger than %dx%d", MaxWatermarkImageSide, MaxWatermarkImageSide)
+	}
+
+	dir := filepath.Join(outputDir, ".watermarks")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create watermark dir: %w", err)
+	}
+	path := filepath.Join(dir, id+".png")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to save watermark image: %w", err)
+	}
+	return path, nil
+}
+
+// overlayPosition returns the overlay x:y placing a w x h logo on a W x H frame
+func (w Watermark) overlayPosition() string {
+	m := w.Margin
+	switch w.Position {
+	case WatermarkTopLeft:
+		return fmt.Sprintf("%d:%d", m, m)
+	case WatermarkTopRight:
+		return fmt.Sprintf("W-w-%d:%d", m, m)
+	case WatermarkBottomLeft:
+		return fmt.Sprintf("%d:H-h-%d", m, m)
+	case WatermarkCenter:
+		return "(W-w)/2:(H-h)/2"
+	}
+	return fmt.Sprintf("W-w-%d:H-h-%d", m, m)
+}
+
+// textPosition returns the drawtext x and y options placing the text on the frame
+func (w Watermark) textPosition() string {
+	m := w.Margin
+	switch w.Position {
+	case WatermarkTopLeft:
+		return fmt.Sprintf("x=%d:y=%d", m, m)
+	case WatermarkTopRight:
+		return fmt.Sprintf("x=w-tw-%d:y=%d", m, m)
+	case WatermarkBottomLeft:
+		return fmt.Sprintf("x=%d:y=h-th-%d", m, m)
+	case WatermarkCenter:
+		return "x=(w-tw)/2:y=(h-th)/2"
+	}
+	return fmt.Sprintf("x=w-tw-%d:y=h-th-%d", m, m)
+}
+
+// watermarkVideo draws the watermark over the main video of mediaPath in place
+func watermarkVideo(ctx context.Context, mediaPath string, w Watermark, e Encoding) error {
+	args := []string{"-i", mediaPath}
+	opacity := formatSeconds(w.Opacity)
+
+	if w.ImagePath != "" {
+		args = append(args, "-i", w.ImagePath, "-filter_complex", fmt.Sprintf(
+			"[1:v]format=rgba,colorchannelmixer=aa=%s[logo];"+
+				"[logo][0:V:0]scale2ref=w='main_w*%s':h='ow/a'[logo][base];"+
+				"[base][logo]overlay=%s:format=auto,format=yuv420p[v]",
+			opacity, formatSeconds(w.Scale), w.overlayPosition(),
+		))
+	} else {
+		// The text goes through a file so it needs no filter graph escaping, and expansion is off
+		// so sequences like %{...} in it are drawn as typed
+		textFile, err := os.CreateTemp("", "clippy-watermark-*.txt")
+		if err != nil {
+			return fmt.Errorf("failed to create watermark text file: %w", err)
+		}
+		defer os.Remove(textFile.Name())
+		if _, err := textFile.WriteString(w.Text); err != nil {
+			textFile.Close()
+			return fmt.Errorf("failed to write watermark text: %w", err)
+		}
+		if err := textFile.Close(); err != nil {
+			return err
+		}
+
+		drawtext := "drawtext=textfile=" + escapeFilterValue(textFile.Name()) + ":expansion=none"
+		if font := watermarkFont(); font != "" {
+			drawtext += ":fontfile=" + escapeFilterValue(font)
+		}
+		drawtext += fmt.Sprintf(":fontsize=h*%s:fontcolor=white@%s:shadowcolor=black@%s:shadowx=2:shadowy=2:%s",
+			formatSeconds(w.Scale), opacity, formatSeconds(w.Opacity*0.6), w.textPosition())
+		args = append(args, "-filter_complex", "[0:V:0]"+drawtext+"[v]")
+	}
+
+	args = append(args, "-map", "[v]", "-map", "0:a?", "-map", "0:s?", "-map_metadata", "0", "-map_chapters", "0")
+	args = append(args, e.filterVideoArgs()...)
+	args = append(args, "-c:a", "copy", "-c:s", "copy")
+	return ffmpegReplace(ctx, mediaPath, args)
+}
@@ -0,0 +1,284 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Waveform bounds, resolution is in peaks per second
+const (
+	DefaultWaveformResolution = 100
+	MinWaveformResolution     = 1
+	MaxWaveformResolution     = 1000
+	// MaxWaveformPeaks bounds one waveform, about 2.7 hours at the default resolution
+	MaxWaveformPeaks = 1_000_000
+	// Peaks only trace the envelope, a low decode rate is plenty and much faster
+	waveformSampleRate = 16000
+)
+
+var (
+	// ErrNoAudioTrack is returned when the media has no audio to draw a waveform of
+	ErrNoAudioTrack = errors.New("media has no audio track")
+	// ErrWaveformTooLong is returned when the media needs more peaks than a waveform may hold
+	ErrWaveformTooLong = errors.New("media is too long for the requested waveform resolution")
+)
+
+// Waveform is the resolution to compute peaks at
+type Waveform struct {
+	Resolution int // peaks per second
+}
+
+// WaveformFiles are the two encodings of the same peaks, in the audiowaveform formats that
+// waveform libraries such as peaks.js and wavesurfer.js read
+type WaveformFiles struct {
+	JSON string
+	Dat  string // binary, version 2 with 8-bit samples
+}
+
+// waveformData is audiowaveform's JSON layout: min and max of each pixel, interleaved
+type waveformData struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+// NewWaveform validates a waveform resolution, 0 takes the default
+func NewWaveform(resolution int) (Waveform, error) {
+	if resolution == 0 {
+		resolution = DefaultWaveformResolution
+	}
+	if resolution < MinWaveformResolution || resolution > MaxWaveformResolution {
+		return Waveform{}, fmt.Errorf("resolution must be between %d and %d peaks per second", MinWaveformResolution, MaxWaveformResolution)
+	}
+	return Waveform{Resolution: resolution}, nil
+}
+
+// samplesPerPixel is how many decoded samples fold into one peak
+func (w Waveform) samplesPerPixel() int {
+	return max(1, int(math.Round(float64(waveformSampleRate)/float64(w.Resolution))))
+}
+
+// files returns where the waveform of base (a path without extension) is cached
+func (w Waveform) files(base string) WaveformFiles {
+	name := fmt.Sprintf("%s.waveform_%d", base, w.samplesPerPixel())
+	return WaveformFiles{JSON: name + ".json", Dat: name + ".dat"}
+}
+
+// cached reports whether both encodings are on disk
+func (f WaveformFiles) cached() bool {
+	return cached(f.JSON) && cached(f.Dat)
+}
+
+// SourceWaveform returns the waveform files of the source video's audio, computing them from
+// the audio stream unless they are cached already
+func SourceWaveform(ctx context.Context, videoURL string, w Waveform) (WaveformFiles, error) {
+	// A known ID finds cached peaks without asking yt-dlp
+	if id := youtubeVideoID(videoURL); id != "" {
+		if files := w.files(filepath.Join(previewDir, id, "source")); files.cached() {
+			return files, nil
+		}
+	}
+
+	stream, err := resolveStream(ctx, videoURL, "ba/b")
+	if err != nil {
+		return WaveformFiles{}, err
+	}
+	files := w.files(filepath.Join(previewDir, cacheKey(stream.ID), "source"))
+	if files.cached() {
+		return files, nil
+	}
+	if stream.Duration*float64(w.Resolution) > MaxWaveformPeaks {
+		return WaveformFiles{}, fmt.Errorf("%w: at most %d peaks, the video needs %.0f", ErrWaveformTooLong, MaxWaveformPeaks, stream.Duration*float64(w.Resolution))
+	}
+	if err := writeWaveform(ctx, stream.URL, w, files); err != nil {
+		return WaveformFiles{}, err
+	}
+	return files, nil
+}
+
+// FileWaveform returns the waveform files of a finished output, cached next to it
+func FileWaveform(ctx context.Context, mediaPath string, w Waveform) (WaveformFiles, error) {
+	files := w.files(strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)))
+	if files.cached() {
+		return files, nil
+	}
+
+	info, err := probeMedia(ctx, mediaPath)
+	if err != nil {
+		return WaveformFiles{}, err
+	}
+	if info.AudioCodec == "" {
+		return WaveformFiles{}, ErrNoAudioTrack
+	}
+	if info.Duration*float64(w.Resolution) > MaxWaveformPeaks {
+		return WaveformFiles{}, fmt.Errorf("%w: at most %d peaks, the output needs %.0f", ErrWaveformTooLong, MaxWaveformPeaks, info.Duration*float64(w.Resolution))
+	}
+	if err := writeWaveform(ctx, mediaPath, w, files); err != nil {
+		return WaveformFiles{}, err
+	}
+	return files, nil
+}
+
+// writeWaveform computes the peaks of input and stores them in both encodings
+func writeWaveform(ctx context.Context, input string, w Waveform, files WaveformFiles) error {
+	spp := w.samplesPerPixel()
+	peaks, err := computePeaks(ctx, input, spp)
+	if err != nil {
+		return err
+	}
+	data := waveformData{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      waveformSampleRate,
+		SamplesPerPixel: spp,
+		Bits:            8,
+		Length:          len(peaks) / 2,
+		Data:            peaks,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(files.JSON), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create waveform dir: %w", err)
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(files.JSON, encoded); err != nil {
+		return err
+	}
+	return writeFileAtomic(files.Dat, data.dat())
+}
+
+// dat encodes the peaks in audiowaveform's binary format: version, flags (1 is 8-bit), sample
+// rate, samples per pixel, length and channels as little endian int32, then the samples
+func (d waveformData) dat() []byte {
+	var dat bytes.Buffer
+	for _, v := range []int32{int32(d.Version), 1, int32(d.SampleRate), int32(d.SamplesPerPixel), int32(d.Length), int32(d.Channels)} {
+		binary.Write(&dat, binary.LittleEndian, v)
+	}
+	binary.Write(&dat, binary.LittleEndian, d.Data)
+	return dat.Bytes()
+}
+
+// computePeaks decodes the first audio stream of input to mono and returns the min and max of
+// every spp samples, interleaved and scaled so the loudest peak reaches full 8-bit range
+func computePeaks(ctx context.Context, input string, spp int) ([]int8, error) {
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-hide_banner", "-nostdin",
+		"-i", input, "-map", "0:a:0", "-ac", "1", "-ar", strconv.Itoa(waveformSampleRate),
+		"-f", "s16le", "-c:a", "pcm_s16le", "-")
+	killProcessTree(cmd)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var (
+		raw     []int16 // min, max pairs at full 16-bit scale
+		lo, hi  int16
+		count   int
+		sample  [2]byte
+		tooLong bool
+		readErr error
+		reader  = bufio.NewReaderSize(stdout, 64*1024)
+	)
+	for {
+		if _, readErr = io.ReadFull(reader, sample[:]); readErr != nil {
+			break
+		}
+		v := int16(binary.LittleEndian.Uint16(sample[:]))
+		if count == 0 || v < lo {
+			lo = v
+		}
+		if count == 0 || v > hi {
+			hi = v
+		}
+		count++
+		if count == spp {
+			raw = append(raw, lo, hi)
+			count = 0
+			if len(raw)/2 > MaxWaveformPeaks {
+				tooLong = true
+				break
+			}
+		}
+	}
+	if tooLong {
+		// Stop decoding, the rest would be thrown away
+		cmd.Process.Kill()
+	}
+	if count > 0 {
+		raw = append(raw, lo, hi)
+	}
+	waitErr := cmd.Wait()
+	switch {
+	case ctx.Err() != nil:
+		return nil, context.Cause(ctx)
+	case tooLong:
+		return nil, fmt.Errorf("%w: at most %d peaks", ErrWaveformTooLong, MaxWaveformPeaks)
+	case waitErr != nil:
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", waitErr, lastLines(stderrBuf.String(), 3))
+	case readErr != io.EOF && readErr != io.ErrUnexpectedEOF:
+		return nil, fmt.Errorf("failed to read decoded audio: %w", readErr)
+	}
+	return scalePeaks(raw), nil
+}
+
+// scalePeaks scales 16-bit peaks to 8 bits so the loudest one reaches full range
+func scalePeaks(raw []int16) []int8 {
+	peak := 0
+	for _, v := range raw {
+		peak = max(peak, int(v), -int(v))
+	}
+	peaks := make([]int8, len(raw))
+	if peak == 0 {
+		// Silence stays flat instead of being blown up
+		return peaks
+	}
+	scale := 127 / float64(peak)
+	for i, v := range raw {
+		peaks[i] = int8(math.Round(float64(v) * scale))
+	}
+	return peaks
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into place, so
+// concurrent readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(path), err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWaveformDat(t *testing.T) {
+	tests := []struct {
+		name string
+		data waveformData
+		want []byte
+	}{
+		{
+			name: "no peaks",
+			data: waveformData{Version: 2, Channels: 1, SampleRate: 16000, SamplesPerPixel: 160, Bits: 8},
+			want: []byte{
+				2, 0, 0, 0,
+				1, 0, 0, 0,
+				0x80, 0x3e, 0, 0,
+				160, 0, 0, 0,
+				0, 0, 0, 0,
+				1, 0, 0, 0,
+			},
+		},
+		{
+			name: "min and max pairs follow the header",
+			data: waveformData{Version: 2, Channels: 1, SampleRate: 16000, SamplesPerPixel: 16000, Bits: 8, Length: 2, Data: []int8{-127, 127, -1, 0}},
+			want: []byte{
+				2, 0, 0, 0,
+				1, 0, 0, 0,
+				0x80, 0x3e, 0, 0,
+				0x80, 0x3e, 0, 0,
+				2, 0, 0, 0,
+				1, 0, 0, 0,
+				0x81, 0x7f, 0xff, 0,
+			},
+		},
+		{
+			name: "samples per pixel above one byte",
+			data: waveformData{Version: 2, Channels: 1, SampleRate: 16000, SamplesPerPixel: 300, Bits: 8, Length: 1, Data: []int8{-3, 5}},
+			want: []byte{
+				2, 0, 0, 0,
+				1, 0, 0, 0,
+				0x80, 0x3e, 0, 0,
+				0x2c, 0x01, 0, 0,
+				1, 0, 0, 0,
+				1, 0, 0, 0,
+				0xfd, 5,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.data.dat(); !bytes.Equal(got, tt.want) {
+				t.Errorf("dat() = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScalePeaks(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []int16
+		want []int8
+	}{
+		{name: "empty", raw: nil, want: []int8{}},
+		{name: "silence stays flat", raw: []int16{0, 0, 0, 0}, want: []int8{0, 0, 0, 0}},
+		{name: "quiet audio is raised to full range", raw: []int16{-100, 100, -50, 25}, want: []int8{-127, 127, -64, 32}},
+		{name: "negative peak sets the scale", raw: []int16{-32768, 16384}, want: []int8{-127, 64}},
+		{name: "full scale is kept", raw: []int16{-32767, 32767}, want: []int8{-127, 127}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scalePeaks(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scalePeaks(%v) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
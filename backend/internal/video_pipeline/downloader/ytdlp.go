@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// maxOutputLine bounds a single yt-dlp output line, long JSON prints can exceed bufio's default
+const maxOutputLine = 1024 * 1024
+
+// runYtDlp runs yt-dlp and feeds its stdout/stderr to tracker line by line as they arrive.
+// The combined output is returned for callers that scan it once the process is done.
+// Cancelling ctx kills yt-dlp together with any ffmpeg it started, as does going
+// longer than stall without a line of output (0 disables the check). The error is then
+// the cancel cause: ErrStalled, ErrTimeout or context.Canceled.
+func runYtDlp(ctx context.Context, args []string, tracker *progressTracker, stall time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	cmd := exec.CommandContext(ctx, ytDlpPath, append(progressArgs(), args...)...)
+	killProcessTree(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		combined bytes.Buffer
+		wg       sync.WaitGroup
+		activity = make(chan struct{}, 1)
+	)
+	if stall > 0 {
+		go watchStall(ctx, cancel, activity, stall)
+	}
+	read := func(r io.Reader) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxOutputLine)
+		for scanner.Scan() {
+			line := scanner.Text()
+			tracker.handleLine(line)
+			select {
+			case activity <- struct{}{}:
+			default:
+			}
+
+			mu.Lock()
+			combined.WriteString(line)
+			combined.WriteByte('\n')
+			mu.Unlock()
+		}
+		// Keep draining so yt-dlp never blocks on a full pipe
+		io.Copy(io.Discard, r)
+	}
+
+	wg.Add(2)
+	go read(stdout)
+	go read(stderr)
+	wg.Wait()
+
+	err = cmd.Wait()
+	if err != nil && ctx.Err() != nil {
+		return combined.Bytes(), context.Cause(ctx)
+	}
+	return combined.Bytes(), err
+}
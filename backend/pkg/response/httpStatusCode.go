@@ -11,9 +11,27 @@ const (
 
 // Client error codes (400xxx)
 const (
-	ErrInvalidRequestBody = 400001 // invalid request body
-	ErrURLRequired        = 400002 // url is required
-	ErrDownloadIDRequired = 400003 // download id is required
+	ErrInvalidRequestBody  = 400001 // invalid request body
+	ErrURLRequired         = 400002 // url is required
+	ErrDownloadIDRequired  = 400003 // download id is required
+	ErrInvalidQuality      = 400004 // unknown quality preset
+	ErrInvalidAudio        = 400005 // invalid audio mode, codec or bitrate
+	ErrInvalidSponsorBlock = 400006 // invalid sponsorblock mode or category
+	ErrInvalidSubtitles    = 400007 // invalid subtitle mode, language or format
+	ErrEndPastDuration     = 400008 // time range ends after the video
+	ErrInvalidPlaylist     = 400009 // not a playlist, bad range or nothing to download
+	ErrInvalidClips        = 400010 // bad clip segments or output mode
+	ErrInvalidCutPrecision = 400011 // unknown cut precision or one the job cannot use
+	ErrInvalidTimeRange    = 400012 // unparseable timestamps or a range out of bounds
+	ErrInvalidAnimation    = 400013 // bad gif/webp options or a job that cannot be animated
+	ErrInvalidReframe      = 400014 // unknown vertical layout or a job that cannot be reframed
+	ErrInvalidWatermark    = 400015 // bad watermark upload or a job that cannot be watermarked
+	ErrInvalidEncoding     = 400016 // unknown codec or container, or a combination that cannot work
+	ErrInvalidSnapshot     = 400017 // bad snapshot timestamp, format or width
+	ErrInvalidStoryboard   = 400018 // bad storyboard interval, width or columns
+	ErrInvalidWaveform     = 400019 // bad waveform resolution or format, or media without audio
+	ErrNoChapters          = 400020 // range selected by chapter on a video without chapters
+	ErrVideoUnavailable    = 400021 // yt-dlp could not read the url: unsupported, private or removed
 )
 
 // Server error codes (500xxx)
@@ -21,11 +39,28 @@ const (
 	ErrDownloadStartFailed = 500001 // failed to start download
 	ErrSerializeResponse   = 500002 // failed to serialize response
 	ErrSerializeStatus     = 500003 // failed to serialize status
+	ErrProbeFailed         = 500004 // yt-dlp could not read the video metadata
+	ErrWatermarkFailed     = 500005 // failed to store, list or delete a watermark
+	ErrPreviewFailed       = 500006 // failed to grab a frame, compute a waveform or start a storyboard
+)
+
+// Payment required error codes (402xxx)
+const (
+	ErrInsufficientCredits = 402001 // not enough credits for the requested jobs
 )
 
 // Not found error codes (404xxx)
 const (
-	ErrDownloadNotFound = 404001 // download not found
+	ErrDownloadNotFound  = 404001 // download not found
+	ErrFileNotFound      = 404002 // download file no longer on disk
+	ErrWatermarkNotFound = 404003 // watermark does not exist or belongs to another user
+)
+
+// Conflict error codes (409xxx)
+const (
+	ErrDownloadNotRunning = 409001 // download already finished, nothing to cancel
+	ErrFileNotReady       = 409002 // download has not produced its file yet
+	ErrWatermarkInUse     = 409003 // watermark is needed by queued or running jobs
 )
 
 // Unauthorized error codes (401xxx)
@@ -33,6 +68,11 @@ const (
 	ErrUnauthorized = 401001 // unauthorized access
 )
 
+// Forbidden error codes (403xxx)
+const (
+	ErrDownloadForbidden = 403001 // download belongs to another user
+)
+
 const (
     ErrTooManyRequests = 429001 // too many requests
 )
@@ -46,10 +86,38 @@ var msg = map[int]string{
 	ErrInvalidRequestBody:  "Invalid request body",
 	ErrURLRequired:         "URL is required",
 	ErrDownloadIDRequired:  "Download ID is required",
+	ErrInvalidQuality:      "Invalid quality preset",
+	ErrInvalidAudio:        "Invalid audio options",
+	ErrInvalidSponsorBlock: "Invalid sponsorblock options",
+	ErrInvalidSubtitles:    "Invalid subtitle options",
+	ErrEndPastDuration:     "End time is past the end of the video",
+	ErrInvalidPlaylist:     "Invalid playlist request",
+	ErrInvalidClips:        "Invalid clip request",
+	ErrInvalidCutPrecision: "Invalid cut precision",
+	ErrInvalidTimeRange:    "Invalid time range",
+	ErrInvalidAnimation:    "Invalid animation options",
+	ErrInvalidReframe:      "Invalid reframe options",
+	ErrInvalidWatermark:    "Invalid watermark",
+	ErrInvalidEncoding:     "Invalid codec or container",
+	ErrInvalidSnapshot:     "Invalid snapshot request",
+	ErrInvalidStoryboard:   "Invalid storyboard options",
+	ErrInvalidWaveform:     "Invalid waveform request",
+	ErrNoChapters:          "Video has no chapters",
+	ErrVideoUnavailable:    "Video unavailable",
 	ErrDownloadStartFailed: "Failed to start download",
 	ErrSerializeResponse:   "Failed to serialize response",
 	ErrSerializeStatus:     "Failed to serialize status",
+	ErrProbeFailed:         "Failed to read video metadata",
+	ErrWatermarkFailed:     "Watermark operation failed",
+	ErrPreviewFailed:       "Failed to generate preview",
+	ErrInsufficientCredits: "Insufficient credits",
 	ErrDownloadNotFound:    "Download not found",
+	ErrDownloadNotRunning:  "Download is not running",
+	ErrFileNotReady:        "Download file is not ready",
+	ErrWatermarkInUse:      "Watermark is in use",
+	ErrFileNotFound:        "Download file not found",
+	ErrWatermarkNotFound:   "Watermark not found",
 	ErrUnauthorized:        "Unauthorized access",
+	ErrDownloadForbidden:   "Access denied",
     ErrTooManyRequests:    "Too many requests",
 }
@@ -1,6 +1,9 @@
 package utils
 
-import "os"
+import (
+    "os"
+    "strconv"
+)
 
 func GetEnv(key, defaultValue string) string {
     if value, exists := os.LookupEnv(key); exists {
@@ -8,3 +11,12 @@ func GetEnv(key, defaultValue string) string {
     }
     return defaultValue
 }
+
+func GetEnvAsInt(key string, defaultValue int) int {
+    if value, exists := os.LookupEnv(key); exists {
+        if n, err := strconv.Atoi(value); err == nil {
+            return n
+        }
+    }
+    return defaultValue
+}